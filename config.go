@@ -1,22 +1,157 @@
 package main
 
 import (
-	"github.com/elliottsam/winrm-dns-client/dns"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
 )
 
 type config struct {
-	ServerName string
-	Username   string
-	Password   string
+	ServerName        string
+	Servers           []string
+	StickyServer      bool
+	Username          string
+	Password          string
+	PasswordFile      string
+	CredentialCommand []string
+	Vault             *vaultConfig
+	AuthType          string
+	Realm             string
+	Krb5Conf          string
+	Keytab            string
+	CCache            string
+	Port              int
+	HTTPS             bool
+	Insecure          bool
+	CACert            string
+	CACertFile        string
+	Cert              string
+	Key               string
+	ProxyURL          string
+	BastionHost       string
+	BastionUser       string
+	BastionPassword   string
+	BastionPrivateKey string
+	BastionHostKey    string
+	BastionInsecure   bool
+	ConnectionTimeout string
+	OperationTimeout  string
+	MaxRetries        int
+	MaxConcurrent     int
+	PersistentShell   bool
+	Backend           string
+	ConfigurationName string
+	Transport         string
+	SSHPrivateKey     string
+	SSHKnownHosts     string
+	SSHShell          string
+	SSHInsecure       bool
+	// ReplicationServers are checked for record changes for up to
+	// ReplicationTimeout every ReplicationInterval
+	ReplicationServers  []string
+	ReplicationTimeout  string
+	ReplicationInterval string
 }
 
 // Client configures the WinRM endpoint for managing Microsoft DNS
 func (c *config) Client() (*dns.Client, error) {
+	if err := c.loadCredentials(); err != nil {
+		return nil, err
+	}
+	if c.Username == "" {
+		return nil, fmt.Errorf("A username is required unless read with credential_command or vault")
+	}
+
 	client := dns.Client{
-		ServerName: c.ServerName,
-		Username:   c.Username,
-		Password:   c.Password,
+		ServerName:              c.ServerName,
+		Servers:                 c.Servers,
+		StickyServer:            c.StickyServer,
+		Username:                c.Username,
+		Password:                c.Password,
+		AuthType:                c.AuthType,
+		Realm:                   c.Realm,
+		Krb5Conf:                c.Krb5Conf,
+		Keytab:                  c.Keytab,
+		CCache:                  c.CCache,
+		Port:                    c.Port,
+		HTTPS:                   c.HTTPS,
+		Insecure:                c.Insecure,
+		CACert:                  []byte(c.CACert),
+		Cert:                    []byte(c.Cert),
+		Key:                     []byte(c.Key),
+		ProxyURL:                c.ProxyURL,
+		BastionHost:             c.BastionHost,
+		BastionUser:             c.BastionUser,
+		BastionPassword:         c.BastionPassword,
+		BastionPrivateKey:       []byte(c.BastionPrivateKey),
+		BastionHostKey:          []byte(c.BastionHostKey),
+		BastionInsecure:         c.BastionInsecure,
+		MaxRetries:              c.MaxRetries,
+		MaxConcurrentOperations: c.MaxConcurrent,
+		PersistentShell:         c.PersistentShell,
+		Backend:                 c.Backend,
+		ConfigurationName:       c.ConfigurationName,
+		Transport:               c.Transport,
+		SSHPrivateKey:           []byte(c.SSHPrivateKey),
+		SSHKnownHosts:           c.SSHKnownHosts,
+		SSHShell:                c.SSHShell,
+		SSHInsecure:             c.SSHInsecure,
+		ReplicationServers:      c.ReplicationServers,
+	}
+
+	if c.CACertFile != "" {
+		cacert, err := ioutil.ReadFile(c.CACertFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading CA certificate: %v", err)
+		}
+		client.CACert = cacert
+	}
+
+	if c.ConnectionTimeout != "" {
+		timeout, err := time.ParseDuration(c.ConnectionTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid connection timeout: %v", err)
+		}
+		client.ConnectionTimeout = timeout
 	}
+	if c.OperationTimeout != "" {
+		timeout, err := time.ParseDuration(c.OperationTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid operation timeout: %v", err)
+		}
+		client.OperationTimeout = timeout
+	}
+
+	if c.ServerName == "" && len(c.Servers) == 0 {
+		return nil, fmt.Errorf("One of server_name or servers is required")
+	}
+
+	if c.ReplicationTimeout != "" {
+		timeout, err := time.ParseDuration(c.ReplicationTimeout)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid replication timeout: %v", err)
+		}
+		client.ReplicationTimeout = timeout
+	}
+	if c.ReplicationInterval != "" {
+		interval, err := time.ParseDuration(c.ReplicationInterval)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid replication interval: %v", err)
+		}
+		client.ReplicationInterval = interval
+	}
+
+	switch {
+	case c.Transport == "ssh":
+		// the dns client requires a password or private key
+	case c.AuthType == "" || c.AuthType == "basic" || c.AuthType == "ntlm":
+		if c.Password == "" && c.Cert == "" {
+			return nil, fmt.Errorf("A password is required unless authenticating with Kerberos or a client certificate")
+		}
+	}
+
 	if err := client.ConfigureWinRMClient(); err != nil {
 		return nil, err
 	}
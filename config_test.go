@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestConfigClient_HTTPS(t *testing.T) {
+	c := config{
+		ServerName: "dc.test.local",
+		Username:   "user",
+		Password:   "pass",
+		HTTPS:      true,
+	}
+
+	client, err := c.Client()
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	if !client.HTTPS {
+		t.Fatal("Expected HTTPS to be enabled")
+	}
+}
+
+func TestConfigClient_MissingCACertFile(t *testing.T) {
+	c := config{
+		ServerName: "dc.test.local",
+		HTTPS:      true,
+		CACertFile: "does-not-exist.pem",
+	}
+
+	if _, err := c.Client(); err == nil {
+		t.Fatal("Expected error reading missing CA certificate")
+	}
+}
+
+func TestConfigClient_PasswordRequired(t *testing.T) {
+	c := config{
+		ServerName: "dc.test.local",
+		Username:   "user",
+		AuthType:   "ntlm",
+	}
+
+	if _, err := c.Client(); err == nil {
+		t.Fatal("Expected error when no password is set")
+	}
+}
+
+func TestConfigClient_InvalidTimeout(t *testing.T) {
+	c := config{
+		ServerName:       "dc.test.local",
+		Username:         "user",
+		Password:         "pass",
+		OperationTimeout: "1 minute",
+	}
+
+	if _, err := c.Client(); err == nil {
+		t.Fatal("Expected error for invalid operation timeout")
+	}
+}
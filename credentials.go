@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// credentialTimeout limits running the credential command and reading the
+// credentials from Vault
+const credentialTimeout = time.Minute
+
+// vaultConfig locates the username and password in a Vault KV secret,
+// Address, Token, Namespace and CACertFile default to the environment
+// variables the Vault CLI reads
+type vaultConfig struct {
+	Address       string
+	Token         string
+	Namespace     string
+	CACertFile    string
+	Path          string
+	UsernameField string
+	PasswordField string
+}
+
+// loadCredentials sets the username and password read from password_file,
+// credential_command or Vault, a username read is only used when username
+// is not set
+func (c *config) loadCredentials() error {
+	var sources []string
+	if c.Password != "" {
+		sources = append(sources, "password")
+	}
+	if c.PasswordFile != "" {
+		sources = append(sources, "password_file")
+	}
+	if len(c.CredentialCommand) > 0 {
+		sources = append(sources, "credential_command")
+	}
+	if c.Vault != nil {
+		sources = append(sources, "vault")
+	}
+	if len(sources) > 1 {
+		return fmt.Errorf("Only one of password, password_file, credential_command or vault can be set, got %s", strings.Join(sources, " and "))
+	}
+
+	var username, password string
+	var err error
+	switch {
+	case c.PasswordFile != "":
+		password, err = readPasswordFile(c.PasswordFile)
+	case len(c.CredentialCommand) > 0:
+		username, password, err = runCredentialCommand(c.CredentialCommand)
+	case c.Vault != nil:
+		username, password, err = c.Vault.read()
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if c.Username == "" {
+		c.Username = username
+	}
+	c.Password = password
+	return nil
+}
+
+// readPasswordFile returns the first line of the file at path
+func readPasswordFile(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("Error reading password file: %v", err)
+	}
+	password := strings.SplitN(string(b), "\n", 2)[0]
+	password = strings.TrimSuffix(password, "\r")
+	if password == "" {
+		return "", fmt.Errorf("Password file %s is empty", path)
+	}
+	return password, nil
+}
+
+// runCredentialCommand runs command and reads the username and password it
+// prints as a JSON object, the output is never included in errors
+func runCredentialCommand(command []string) (string, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), credentialTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", "", fmt.Errorf("Error running credential command %s: %v\nStdErr: %s", command[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	var credentials struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.Unmarshal(stdout.Bytes(), &credentials); err != nil {
+		return "", "", fmt.Errorf("Error reading the output of credential command %s, expected a JSON object with username and password", command[0])
+	}
+	if credentials.Password == "" {
+		return "", "", fmt.Errorf("Credential command %s returned no password", command[0])
+	}
+	return credentials.Username, credentials.Password, nil
+}
+
+// read returns the username and password fields of the secret, the data of
+// KV version 2 secrets is nested under data
+func (v *vaultConfig) read() (string, string, error) {
+	address := v.Address
+	if address == "" {
+		address = os.Getenv("VAULT_ADDR")
+	}
+	if address == "" {
+		return "", "", fmt.Errorf("The Vault address is required, set address or VAULT_ADDR")
+	}
+	token, err := v.token()
+	if err != nil {
+		return "", "", err
+	}
+	client, err := v.httpClient()
+	if err != nil {
+		return "", "", err
+	}
+
+	path := strings.Trim(v.Path, "/")
+	req, err := http.NewRequest("GET", strings.TrimSuffix(address, "/")+"/v1/"+path, nil)
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid Vault address: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", token)
+	namespace := v.Namespace
+	if namespace == "" {
+		namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if namespace != "" {
+		req.Header.Set("X-Vault-Namespace", namespace)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("Error reading %s from Vault: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	var secret struct {
+		Errors []string               `json:"errors"`
+		Data   map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil && resp.StatusCode == http.StatusOK {
+		return "", "", fmt.Errorf("Error reading %s from Vault: %v", path, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("Error reading %s from Vault: %s %s", path, resp.Status, strings.Join(secret.Errors, ", "))
+	}
+
+	data := secret.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+	usernameField, passwordField := v.UsernameField, v.PasswordField
+	if usernameField == "" {
+		usernameField = "username"
+	}
+	if passwordField == "" {
+		passwordField = "password"
+	}
+	username, _ := data[usernameField].(string)
+	password, _ := data[passwordField].(string)
+	if password == "" {
+		return "", "", fmt.Errorf("Vault secret %s has no %s field", path, passwordField)
+	}
+	return username, password, nil
+}
+
+// token returns the Vault token, from VAULT_TOKEN or the token helper file
+// ~/.vault-token when not set
+func (v *vaultConfig) token() (string, error) {
+	if v.Token != "" {
+		return v.Token, nil
+	}
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		return token, nil
+	}
+	home, err := os.UserHomeDir()
+	if err == nil {
+		if b, err := ioutil.ReadFile(filepath.Join(home, ".vault-token")); err == nil && strings.TrimSpace(string(b)) != "" {
+			return strings.TrimSpace(string(b)), nil
+		}
+	}
+	return "", fmt.Errorf("A Vault token is required, set token or VAULT_TOKEN")
+}
+
+// httpClient returns the client connecting to Vault, verifying its
+// certificate with the CA certificate file when set
+func (v *vaultConfig) httpClient() (*http.Client, error) {
+	client := &http.Client{Timeout: credentialTimeout}
+	caCertFile := v.CACertFile
+	if caCertFile == "" {
+		caCertFile = os.Getenv("VAULT_CACERT")
+	}
+	if caCertFile == "" {
+		return client, nil
+	}
+
+	b, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Vault CA certificate: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("Vault CA certificate %s holds no PEM certificate", caCertFile)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	client.Transport = transport
+	return client, nil
+}
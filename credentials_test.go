@@ -0,0 +1,179 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestConfigClient_PasswordFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	password := filepath.Join(dir, "password")
+	ioutil.WriteFile(password, []byte("pass\r\n"), 0600)
+	empty := filepath.Join(dir, "empty")
+	ioutil.WriteFile(empty, []byte("\n"), 0600)
+
+	cases := []struct {
+		name   string
+		config func(c *config)
+		err    string
+	}{
+		{"password file", func(c *config) {
+			c.Password = ""
+			c.PasswordFile = password
+		}, ""},
+		{"empty file", func(c *config) {
+			c.Password = ""
+			c.PasswordFile = empty
+		}, "is empty"},
+		{"missing file", func(c *config) {
+			c.Password = ""
+			c.PasswordFile = filepath.Join(dir, "missing")
+		}, "Error reading password file"},
+		{"password and password file", func(c *config) {
+			c.PasswordFile = password
+		}, "Only one of password, password_file, credential_command or vault can be set, got password and password_file"},
+	}
+	for _, tc := range cases {
+		c := testCredentialsConfig()
+		tc.config(&c)
+		testCredentials(t, tc.name, c, tc.err)
+	}
+}
+
+func TestConfigClient_CredentialCommand(t *testing.T) {
+	cases := []struct {
+		name     string
+		username string
+		command  string
+		err      string
+	}{
+		{"username and password", "", `echo '{"username": "user", "password": "pass"}'`, ""},
+		{"password only", "user", `echo '{"password": "pass"}'`, ""},
+		{"username set", "user", `echo '{"username": "other", "password": "pass"}'`, ""},
+		{"failure", "", `echo 'helper: access denied' >&2; exit 3`, "helper: access denied"},
+		{"not json", "user", `echo s3cret`, "expected a JSON object with username and password"},
+		{"no password", "user", `echo '{"username": "user"}'`, "returned no password"},
+		{"no username", "", `echo '{"password": "pass"}'`, "A username is required"},
+	}
+	for _, tc := range cases {
+		c := testCredentialsConfig()
+		c.Username = tc.username
+		c.Password = ""
+		c.CredentialCommand = []string{"sh", "-c", tc.command}
+		err := testCredentials(t, tc.name, c, tc.err)
+		if err != nil && strings.Contains(err.Error(), "s3cret") {
+			t.Errorf("%s: expected the output of the command not to be in errors, got %v", tc.name, err)
+		}
+	}
+}
+
+func TestConfigClient_Vault(t *testing.T) {
+	secrets := map[string]interface{}{
+		// KV version 2 nests the secret under data
+		"/v1/secret/data/dns": map[string]interface{}{
+			"data":     map[string]interface{}{"username": "user", "password": "pass"},
+			"metadata": map[string]interface{}{"version": 3},
+		},
+		"/v1/kv/dns":        map[string]interface{}{"login": "user", "secret": "pass"},
+		"/v1/kv/nopassword": map[string]interface{}{"username": "user"},
+	}
+	handler := http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "token" || (strings.HasPrefix(r.URL.Path, "/v1/kv/") && r.Header.Get("X-Vault-Namespace") != "dns") {
+			rw.WriteHeader(http.StatusForbidden)
+			rw.Write([]byte(`{"errors":["permission denied"]}`))
+			return
+		}
+		data, ok := secrets[r.URL.Path]
+		if !ok {
+			rw.WriteHeader(http.StatusNotFound)
+			rw.Write([]byte(`{"errors":[]}`))
+			return
+		}
+		json.NewEncoder(rw).Encode(map[string]interface{}{"data": data})
+	})
+	vault := httptest.NewServer(handler)
+	defer vault.Close()
+	tlsVault := httptest.NewTLSServer(handler)
+	defer tlsVault.Close()
+
+	dir, err := ioutil.TempDir("", "vault")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	cacert := filepath.Join(dir, "ca.pem")
+	ioutil.WriteFile(cacert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tlsVault.Certificate().Raw}), 0600)
+
+	cases := []struct {
+		name  string
+		vault vaultConfig
+		err   string
+	}{
+		{"kv version 2", vaultConfig{Address: vault.URL, Token: "token", Path: "secret/data/dns"}, ""},
+		{"kv version 1 with fields", vaultConfig{Address: vault.URL, Token: "token", Namespace: "dns", Path: "/kv/dns", UsernameField: "login", PasswordField: "secret"}, ""},
+		{"tls", vaultConfig{Address: tlsVault.URL, Token: "token", CACertFile: cacert, Path: "secret/data/dns"}, ""},
+		{"tls without ca", vaultConfig{Address: tlsVault.URL, Token: "token", Path: "secret/data/dns"}, "certificate"},
+		{"wrong token", vaultConfig{Address: vault.URL, Token: "wrong", Path: "secret/data/dns"}, "403 Forbidden permission denied"},
+		{"missing secret", vaultConfig{Address: vault.URL, Token: "token", Path: "secret/data/missing"}, "404 Not Found"},
+		{"missing field", vaultConfig{Address: vault.URL, Token: "token", Namespace: "dns", Path: "kv/nopassword"}, "Vault secret kv/nopassword has no password field"},
+	}
+	for _, tc := range cases {
+		c := testCredentialsConfig()
+		c.Username = ""
+		c.Password = ""
+		vault := tc.vault
+		c.Vault = &vault
+		testCredentials(t, tc.name, c, tc.err)
+	}
+
+	// the address, token and namespace default to the Vault CLI environment
+	os.Setenv("VAULT_ADDR", vault.URL)
+	os.Setenv("VAULT_TOKEN", "token")
+	os.Setenv("VAULT_NAMESPACE", "dns")
+	defer os.Unsetenv("VAULT_ADDR")
+	defer os.Unsetenv("VAULT_TOKEN")
+	defer os.Unsetenv("VAULT_NAMESPACE")
+	c := testCredentialsConfig()
+	c.Username = ""
+	c.Password = ""
+	c.Vault = &vaultConfig{Path: "kv/dns", UsernameField: "login", PasswordField: "secret"}
+	testCredentials(t, "environment", c, "")
+}
+
+// testCredentialsConfig returns a configuration with the username and
+// password the credential sources in the tests hold
+func testCredentialsConfig() config {
+	return config{
+		ServerName: "dc.test.local",
+		Username:   "user",
+		Password:   "pass",
+	}
+}
+
+// testCredentials configures the client with c, expecting an error
+// containing expected when it is set and the credentials to be read
+// otherwise
+func testCredentials(t *testing.T, name string, c config, expected string) error {
+	client, err := c.Client()
+	if expected == "" && err != nil {
+		t.Errorf("%s: unexpected error: %s", name, err)
+	}
+	if expected == "" && err == nil && (client.Username != "user" || client.Password != "pass") {
+		t.Errorf("%s: expected the credentials of user, got %s and %s", name, client.Username, client.Password)
+	}
+	if expected != "" && (err == nil || !strings.Contains(err.Error(), expected)) {
+		t.Errorf("%s: expected error containing %q, got %v", name, expected, err)
+	}
+	return err
+}
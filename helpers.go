@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// defaultResourceTimeout limits how long scripts run when applying a
+// resource unless a timeouts block is configured
+const defaultResourceTimeout = 10 * time.Minute
+
+// validateDuration ensures a string attribute can be parsed as a duration
+func validateDuration(v interface{}, k string) (ws []string, es []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		es = append(es, fmt.Errorf("%q must be a valid duration: %v", k, err))
+	}
+	return
+}
+
+// locks serialises operations changing the same DNS server objects, others
+// run concurrently up to max_concurrent_operations
+var locks = &keyedMutex{locks: map[string]*keyedLock{}}
+
+// keyedMutex holds a mutex for every key in use
+type keyedMutex struct {
+	mu    sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	// refs is the number of holders and waiters, the lock is removed once
+	// it drops to zero
+	refs int
+}
+
+// Lock locks key and returns the function unlocking it
+func (k *keyedMutex) Lock(key string) func() {
+	k.mu.Lock()
+	l, ok := k.locks[key]
+	if !ok {
+		l = &keyedLock{}
+		k.locks[key] = l
+	}
+	l.refs++
+	k.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		k.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(k.locks, key)
+		}
+		k.mu.Unlock()
+	}
+}
+
+// suppressEquivalentDuration ignores differences between durations that are
+// written differently but have the same value, e.g. 1h and 1h0m0s
+func suppressEquivalentDuration(k, old, new string, d *schema.ResourceData) bool {
+	o, err := time.ParseDuration(old)
+	if err != nil {
+		return false
+	}
+	n, err := time.ParseDuration(new)
+	if err != nil {
+		return false
+	}
+	return o == n
+}
+
+// validateDomainName ensures a name can be converted to the A-labels stored
+// by the DNS server
+func validateDomainName(v interface{}, k string) (ws []string, es []error) {
+	if _, err := dns.ToASCII(v.(string)); err != nil {
+		es = append(es, fmt.Errorf("%q: %v", k, err))
+	}
+	return
+}
+
+// stateASCII stores names given in Unicode as their A-labels, so the state
+// matches what the server returns
+func stateASCII(v interface{}) string {
+	name, err := dns.ToASCII(v.(string))
+	if err != nil {
+		return v.(string)
+	}
+	return name
+}
+
+// suppressEquivalentHostname ignores differences between a CNAME target
+// given in Unicode and its A-labels
+func suppressEquivalentHostname(k, old, new string, d *schema.ResourceData) bool {
+	if d.Get("type").(string) != "CNAME" {
+		return false
+	}
+	return stateASCII(old) == stateASCII(new)
+}
+
+// secondsToDuration converts seconds returned from the server into the
+// duration format used in configuration
+func secondsToDuration(s float64) string {
+	return (time.Duration(s) * time.Second).String()
+}
+
+// validateStringInSlice ensures a string attribute is one of the allowed values
+func validateStringInSlice(valid []string) schema.SchemaValidateFunc {
+	return func(v interface{}, k string) (ws []string, es []error) {
+		for _, s := range valid {
+			if v.(string) == s {
+				return
+			}
+		}
+		es = append(es, fmt.Errorf("%q must be one of %v, got: %s", k, valid, v.(string)))
+		return
+	}
+}
+
+func interfaceToStrings(l []interface{}) []string {
+	result := make([]string, 0, len(l))
+	for _, v := range l {
+		result = append(result, v.(string))
+	}
+	return result
+}
+
+// sameStrings returns if both slices hold the same values ignoring order
+// and case
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	used := make([]bool, len(b))
+	for _, x := range a {
+		found := false
+		for i, y := range b {
+			if !used[i] && strings.EqualFold(x, y) {
+				used[i] = true
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// hashDomain hashes domain names ignoring case and a trailing dot
+func hashDomain(v interface{}) int {
+	return hashcode.String(strings.TrimSuffix(strings.ToLower(v.(string)), "."))
+}
+
+// timeoutClient returns the client limited to the timeout of the operation
+// being applied, updates run while creating use the create timeout
+func timeoutClient(d *schema.ResourceData, m interface{}, key string) *dns.Client {
+	if key == schema.TimeoutUpdate && d.IsNewResource() {
+		key = schema.TimeoutCreate
+	}
+	return m.(*dns.Client).WithTimeout(d.Timeout(key))
+}
+
+// singleServer returns an error when the provider is configured with
+// servers, the settings, policies and scopes of one server are not
+// replicated and cannot be managed through whichever of them answers
+func singleServer(m interface{}, resource string) error {
+	if len(m.(*dns.Client).Servers) > 0 {
+		return fmt.Errorf("%s applies to a single server and cannot be used with servers, configure a provider with server_name for it", resource)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func TestSingleServer(t *testing.T) {
+	client := &dns.Client{Servers: []string{"dc1.test.local", "dc2.test.local"}}
+	cases := []struct {
+		name     string
+		resource *schema.Resource
+		raw      map[string]interface{}
+	}{
+		{"windows-dns_query_policy", resourceDNSQueryPolicy(), map[string]interface{}{"name": "weighted", "zone": "test.local"}},
+		{"windows-dns_block_policy", resourceDNSBlockPolicy(), map[string]interface{}{"name": "sinkhole", "domains": []interface{}{"malware.example.com"}}},
+		{"windows-dns_recursion_scope", resourceDNSRecursionScope(), map[string]interface{}{"name": "partner"}},
+	}
+
+	for _, tc := range cases {
+		d := schema.TestResourceDataRaw(t, tc.resource.Schema, tc.raw)
+		d.SetId(tc.raw["name"].(string))
+		_, existsErr := tc.resource.Exists(d, client)
+		errs := map[string]error{
+			"create": tc.resource.Create(d, client),
+			"read":   tc.resource.Read(d, client),
+			"update": tc.resource.Update(d, client),
+			"delete": tc.resource.Delete(d, client),
+			"exists": existsErr,
+		}
+		for op, err := range errs {
+			if err == nil || !strings.Contains(err.Error(), tc.name+" applies to a single server") {
+				t.Errorf("%s %s: expected servers to be rejected, got %v", tc.name, op, err)
+			}
+		}
+	}
+}
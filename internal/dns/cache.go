@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ServerCache holds the cache settings of a DNS server
+type ServerCache struct {
+	MaxTTL                    float64
+	MaxNegativeTTL            float64
+	EnablePollutionProtection bool
+	LockingPercent            int
+	MaxKBSize                 int
+}
+
+// ReadServerCache retrieves the cache settings from the DNS server
+func (c *Client) ReadServerCache() (ServerCache, error) {
+	const pscript = `
+Get-DnsServerCache | select @{n='MaxTTL';e={$_.MaxTTL.TotalSeconds}}, @{n='MaxNegativeTTL';e={$_.MaxNegativeTtl.TotalSeconds}}, EnablePollutionProtection, LockingPercent, MaxKBSize | ConvertTo-Json
+`
+	// restricted endpoints return the settings as an object
+	const pscriptRestricted = `
+Get-DnsServerCache
+`
+	var cache ServerCache
+
+	if c.restricted() {
+		output, err := c.ExecutePowerShellScript(pscriptRestricted)
+		if err != nil {
+			return ServerCache{}, fmt.Errorf("Running PowerShell script: %v", err)
+		}
+		if len(output.objects) == 0 {
+			return ServerCache{}, fmt.Errorf("No cache settings returned")
+		}
+		o := output.objects[0]
+		return ServerCache{
+			MaxTTL:                    psSeconds(psProperty(o, "MaxTTL")),
+			MaxNegativeTTL:            psSeconds(psProperty(o, "MaxNegativeTtl")),
+			EnablePollutionProtection: psProperty(o, "EnablePollutionProtection") == true,
+			LockingPercent:            int(psNumber(psProperty(o, "LockingPercent"))),
+			MaxKBSize:                 int(psNumber(psProperty(o, "MaxKBSize"))),
+		}, nil
+	}
+
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return ServerCache{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	if output.stdout == "" {
+		return ServerCache{}, fmt.Errorf("No cache settings returned")
+	}
+	if err := json.Unmarshal([]byte(output.stdout), &cache); err != nil {
+		return ServerCache{}, fmt.Errorf("Unmarshalling response: %v", err)
+	}
+
+	return cache, nil
+}
+
+// UpdateServerCache applies cache settings to the DNS server
+func (c *Client) UpdateServerCache(cache ServerCache) (ServerCache, error) {
+	const tmplpscript = `
+Set-DnsServerCache -MaxTTL {{ timespan .MaxTTL }} -MaxNegativeTtl {{ timespan .MaxNegativeTTL }} -EnablePollutionProtection ${{ .EnablePollutionProtection }} -LockingPercent {{ .LockingPercent }} -MaxKBSize {{ .MaxKBSize }}
+`
+	pscript, err := tmplExec(cache, tmplpscript)
+	if err != nil {
+		return ServerCache{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return ServerCache{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return c.ReadServerCache()
+}
+
+// ClearServerCache removes entries from the DNS server cache, if name is
+// empty the whole cache is cleared otherwise only records for name are removed
+func (c *Client) ClearServerCache(name string) error {
+	const tmplscriptAll = `
+Clear-DnsServerCache -Force
+`
+	const tmplscriptName = `
+Get-DnsServerResourceRecord -ZoneName '..Cache' -Name '{{ . }}' -ErrorAction SilentlyContinue | Remove-DnsServerResourceRecord -ZoneName '..Cache' -Force
+`
+	tmpl := tmplscriptAll
+	if name != "" {
+		tmpl = tmplscriptName
+	}
+
+	pscript, err := tmplExec(name, tmpl)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,528 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+// defaultTimeout is used for connecting and WinRM operations when no
+// timeout is set
+const defaultTimeout = 60 * time.Second
+
+const (
+	// maxCommandLine is the longest command line Windows starts, scripts
+	// longer than this once encoded are sent over stdin instead
+	maxCommandLine = 8191
+	// maxInputChunk is the most input sent in one request, it fits in the
+	// WS-Management envelope once base64 encoded
+	maxInputChunk = 32 * 1024
+)
+
+// Client struct for holding winrm.Client configuration
+type Client struct {
+	ServerName string
+	// Servers are tried in order when set instead of ServerName, each can
+	// be given as host:port, StickyServer keeps using the server that last
+	// answered instead of going back to the first one available, and
+	// FailbackInterval is how long a server that could not be reached is
+	// skipped for, defaulting to 1m
+	Servers          []string
+	StickyServer     bool
+	FailbackInterval time.Duration
+	Username         string
+	Password         string
+	Port             int
+	HTTPS            bool
+	Insecure         bool
+	// AuthType is basic, ntlm or kerberos, defaults to basic
+	AuthType string
+	// Kerberos realm, krb5.conf path and the keytab or credential cache
+	// used instead of a password
+	Realm    string
+	Krb5Conf string
+	Keytab   string
+	CCache   string
+	// PEM encoded CA certificate used to verify the server, and client
+	// certificate and key used to authenticate instead of a password
+	CACert []byte
+	Cert   []byte
+	Key    []byte
+	// ConnectionTimeout limits connecting to the server, OperationTimeout
+	// is how long the server may take to answer a WinRM request, both
+	// default to 60s
+	ConnectionTimeout time.Duration
+	OperationTimeout  time.Duration
+	// MaxRetries is how many times scripts failing with a transient error
+	// are tried again, RetryBackoff is the wait before the first retry and
+	// defaults to 1s
+	MaxRetries   int
+	RetryBackoff time.Duration
+	// PersistentShell runs scripts in a PowerShell process kept open between
+	// operations instead of starting one for every script
+	PersistentShell bool
+	// MaxConcurrentOperations limits how many scripts run at once, zero
+	// does not limit them
+	MaxConcurrentOperations int
+	// ReplicationServers are polled after a record changes until they all
+	// see the change, for up to ReplicationTimeout every ReplicationInterval,
+	// which default to 5m and 10s
+	ReplicationServers  []string
+	ReplicationTimeout  time.Duration
+	ReplicationInterval time.Duration
+	// ProxyURL is an http, socks5 or socks5h URL of the proxy connections
+	// are made through, BastionHost an SSH server reached through the proxy
+	// if set that connections are then made from. The bastion's host key is
+	// checked against BastionHostKey, in authorized_keys format, or the
+	// known_hosts file of SSHKnownHosts when it is not set, BastionInsecure
+	// skips the check
+	ProxyURL          string
+	BastionHost       string
+	BastionUser       string
+	BastionPassword   string
+	BastionPrivateKey []byte
+	BastionHostKey    []byte
+	BastionInsecure   bool
+	// Backend runs scripts with powershell.exe when empty or powershell,
+	// in a PowerShell remoting runspace pool when psrp, and auto uses the
+	// runspace pool falling back to powershell.exe when it is unavailable
+	Backend string
+	// ConfigurationName is the session configuration, such as a JEA
+	// endpoint, runspace pools are opened in, as a name or resource URI.
+	// Scripts are then sent as pipelines of commands, as endpoints in
+	// NoLanguage mode require, and never fall back to powershell.exe
+	ConfigurationName string
+	// Transport is winrm when empty, or ssh to run scripts with SSHShell,
+	// defaulting to powershell, over SSH logging in with Password or the
+	// PEM encoded SSHPrivateKey. Host keys are checked against the
+	// SSHKnownHosts file, ~/.ssh/known_hosts when not set, unless
+	// SSHInsecure
+	Transport     string
+	SSHPrivateKey []byte
+	SSHKnownHosts string
+	SSHShell      string
+	SSHInsecure   bool
+	Client        *winrm.Client
+	shell         *persistentShell
+	pool          *runspacePool
+	zones         *zoneCache
+	servers       *serverSet
+	// address is the host and port connected to, through dial when set
+	address   string
+	dial      dialFunc
+	bastion   *sshConnection
+	sshConn   *sshConnection
+	transport winrm.Transporter
+	// operations holds a value for every script running when
+	// MaxConcurrentOperations is set
+	operations chan struct{}
+	url        string
+	// deadline set by WithTimeout after which running scripts are stopped
+	deadline time.Time
+}
+
+// Output returned from running PS scripts on WinRm server
+type Output struct {
+	stdout   string
+	stderr   string
+	exitcode int
+	objects  []interface{}
+}
+
+// Objects returns the deserialised objects written by the script when it
+// ran in a runspace pool
+func (o *Output) Objects() []interface{} {
+	return o.objects
+}
+
+// GenerateClient generates the winrm.client configuration
+func GenerateClient(sn, un, pwd string) Client {
+	return Client{
+		ServerName: sn,
+		Username:   un,
+		Password:   pwd,
+	}
+}
+
+// ConfigureWinRMClient creates the connection to the winrm server
+func (c *Client) ConfigureWinRMClient() error {
+	if len(c.Servers) > 0 {
+		return c.configureServers()
+	}
+	switch c.Transport {
+	case "", "winrm":
+	case "ssh":
+		return c.configureSSH()
+	default:
+		return fmt.Errorf("Unsupported transport: %s", c.Transport)
+	}
+
+	port := c.Port
+	if port == 0 {
+		port = 5985
+		if c.HTTPS {
+			port = 5986
+		}
+	}
+
+	connectionTimeout := c.ConnectionTimeout
+	if connectionTimeout <= 0 {
+		connectionTimeout = defaultTimeout
+	}
+	params := *winrm.DefaultParameters
+	if c.OperationTimeout > 0 {
+		params.Timeout = wsmanDuration(c.OperationTimeout)
+	}
+
+	dial, err := c.newDialer(connectionTimeout)
+	if err != nil {
+		return err
+	}
+	c.dial = dial
+	settings := httpSettings{dial: dial}
+
+	switch {
+	case len(c.Cert) > 0 || len(c.Key) > 0:
+		if !c.HTTPS {
+			return fmt.Errorf("Client certificate authentication requires HTTPS")
+		}
+		c.transport = &basicTransport{httpSettings: settings, authorization: wsmanMutualAuthorization}
+	case c.AuthType == "ntlm":
+		c.transport = &ntlmTransport{httpSettings: settings, username: c.Username, password: c.Password, encrypt: !c.HTTPS}
+	case c.AuthType == "kerberos":
+		krb, err := c.kerberosClient()
+		if err != nil {
+			return err
+		}
+		c.transport = &kerberosTransport{httpSettings: settings, krb: krb, spn: "HTTP/" + c.ServerName, encrypt: !c.HTTPS}
+	case c.AuthType == "" || c.AuthType == "basic":
+		authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(c.Username+":"+c.Password))
+		c.transport = &basicTransport{httpSettings: settings, authorization: authorization}
+	default:
+		return fmt.Errorf("Unsupported authentication type: %s", c.AuthType)
+	}
+	// the transport is kept to send PowerShell remoting requests, which
+	// winrm.Client has no methods for
+	transport := c.transport
+	params.TransportDecorator = func() winrm.Transporter {
+		return transport
+	}
+
+	switch {
+	case c.restricted() && c.Backend == "powershell":
+		return fmt.Errorf("Configuration %s requires the psrp backend", c.ConfigurationName)
+	case c.Backend == "psrp" || c.Backend == "auto" || (c.restricted() && c.Backend == ""):
+		c.pool = &runspacePool{client: c, runspaces: c.MaxConcurrentOperations}
+	case c.Backend == "" || c.Backend == "powershell":
+	default:
+		return fmt.Errorf("Unsupported backend: %s", c.Backend)
+	}
+
+	endpoint := winrm.NewEndpoint(c.ServerName, port, c.HTTPS, c.Insecure, c.CACert, c.Cert, c.Key, connectionTimeout)
+	client, err := winrm.NewClientWithParameters(endpoint, c.Username, c.Password, &params)
+	if err != nil {
+		return fmt.Errorf("Error creating WinRM client: %v", err)
+	}
+	c.Client = client
+	if c.MaxConcurrentOperations > 0 {
+		c.operations = make(chan struct{}, c.MaxConcurrentOperations)
+	}
+	scheme := "http"
+	if c.HTTPS {
+		scheme = "https"
+	}
+	c.url = fmt.Sprintf("%s://%s:%d/wsman", scheme, c.ServerName, port)
+	c.address = net.JoinHostPort(c.ServerName, strconv.Itoa(port))
+	if c.PersistentShell {
+		c.shell = &persistentShell{}
+	}
+	c.zones = &zoneCache{zones: map[string]*zoneSnapshot{}}
+
+	return nil
+}
+
+// Close ends the persistent PowerShell session and runspace pool if open,
+// and closes the connections kept authenticated
+func (c *Client) Close() {
+	if c.servers != nil {
+		c.servers.close()
+	}
+	if c.shell != nil {
+		c.shell.close()
+	}
+	if c.pool != nil {
+		c.pool.close()
+	}
+	if c.bastion != nil {
+		c.bastion.close()
+	}
+	if c.sshConn != nil {
+		c.sshConn.close()
+	}
+	if t, ok := c.transport.(interface{ close() }); ok {
+		t.close()
+	}
+}
+
+// WithTimeout returns a copy of the client that stops scripts running for
+// longer than timeout, the WinRM operation timeout is lowered to match so
+// requests are not held on the server past it
+func (c *Client) WithTimeout(timeout time.Duration) *Client {
+	if timeout <= 0 || (c.Client == nil && c.sshConn == nil && c.servers == nil) {
+		return c
+	}
+	return c.withDeadline(time.Now().Add(timeout))
+}
+
+// withDeadline returns a copy of the client that stops scripts at deadline
+func (c *Client) withDeadline(deadline time.Time) *Client {
+	if deadline.IsZero() {
+		return c
+	}
+
+	client := *c
+	client.deadline = deadline
+	if c.Client == nil {
+		return &client
+	}
+	winrmClient := *c.Client
+	if timeout := time.Until(deadline); timeout < wsmanTimeout(winrmClient.Parameters.Timeout) {
+		winrmClient.Parameters.Timeout = wsmanDuration(timeout)
+	}
+	client.Client = &winrmClient
+	return &client
+}
+
+// ExecutePowerShellScript runs a PS script on the winrm server, scripts
+// failing with a transient error are retried
+func (c *Client) ExecutePowerShellScript(pscript string) (*Output, error) {
+	var output *Output
+	err := c.retry(func() error {
+		var err error
+		output, err = c.execute(pscript)
+		return err
+	})
+	return output, err
+}
+
+// execute runs a PS script once
+func (c *Client) execute(pscript string) (*Output, error) {
+	release, err := c.acquire()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	if c.servers != nil {
+		return c.servers.execute(c, pscript)
+	}
+	return c.executeOnServer(pscript)
+}
+
+// executeOnServer runs a PS script on the server the client is configured
+// for
+func (c *Client) executeOnServer(pscript string) (*Output, error) {
+	var (
+		out, outerr string
+		exitcode    int
+		result      *psrpResult
+		err         error
+	)
+	ran := false
+	if c.pool != nil && !c.pool.isDisabled() {
+		out, outerr, result, err = c.pool.run(c, pscript)
+		ran = true
+		if openErr, ok := err.(psrpOpenError); ok && c.Backend == "auto" && !c.restricted() && openErr.refused() {
+			log.Printf("[WARN] PowerShell remoting is unavailable on %s, falling back to powershell.exe: %v", c.ServerName, err)
+			c.pool.disable()
+			ran = false
+		}
+	}
+	switch {
+	case ran:
+	case c.sshConn != nil:
+		out, outerr, exitcode, err = c.runSSH(pscript)
+	case c.shell != nil:
+		out, outerr, err = c.shell.run(c, pscript)
+	case len(powershell(pscript)) <= maxCommandLine:
+		out, outerr, exitcode, err = c.run(powershell(pscript), "")
+	default:
+		out, outerr, exitcode, err = c.run(powershell(stdinLoader), loaderInput(pscript))
+	}
+	if err != nil && isTLSError(err) {
+		return nil, c.tlsError(err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Error executing script: %w\nStdErr: %v", err, outerr)
+	}
+	if outerr != "" && !strings.Contains(outerr, "<T>Completed</T>") {
+		scriptErr := scriptError{stderr: outerr}
+		if result != nil {
+			scriptErr.records = result.errors
+		}
+		return nil, scriptErr
+	}
+
+	output := &Output{stdout: out, stderr: outerr, exitcode: exitcode}
+	if result != nil {
+		output.objects = result.objects
+	}
+	return output, nil
+}
+
+// scriptError is returned when a script wrote errors, records holds the
+// error records when the script ran in a runspace pool, other backends only
+// write them as text
+type scriptError struct {
+	stderr  string
+	records []interface{}
+}
+
+func (e scriptError) Error() string {
+	return fmt.Sprintf("Error executing script\nStdErr: %v", e.stderr)
+}
+
+// hasWin32Error returns if one of the error records is for the Windows
+// error code, from its FullyQualifiedErrorId such as "WIN32 9601,Get-DnsServerZone"
+// or the HResult of its exception
+func (e scriptError) hasWin32Error(code int) bool {
+	id := fmt.Sprintf("WIN32 %d,", code)
+	hresult := int64(int32(0x80070000 | uint32(code)))
+	for _, record := range e.records {
+		if s, ok := psProperty(record, "FullyQualifiedErrorId").(string); ok && strings.HasPrefix(s, id) {
+			return true
+		}
+		if h, ok := psProperty(psProperty(record, "Exception"), "HResult").(int64); ok && h == hresult {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire waits until fewer than MaxConcurrentOperations scripts are
+// running, giving up at the deadline set by WithTimeout, and returns the
+// function to call once the script finished
+func (c *Client) acquire() (func(), error) {
+	if c.operations == nil {
+		return func() {}, nil
+	}
+
+	var expired <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case c.operations <- struct{}{}:
+		return func() { <-c.operations }, nil
+	case <-expired:
+		return nil, fmt.Errorf("Timeout waiting for script to start on %s, %d scripts are running", c.name(), cap(c.operations))
+	}
+}
+
+// run executes command in a new shell, sending input to it, and returns its
+// output, the command is stopped when the deadline set by WithTimeout passes
+func (c *Client) run(command, input string) (string, string, int, error) {
+	shell, err := c.Client.CreateShell()
+	if err != nil {
+		return "", "", 1, err
+	}
+	defer shell.Close()
+
+	cmd, err := shell.Execute(command)
+	if err != nil {
+		return "", "", 1, err
+	}
+	if err := writeInput(cmd.Stdin, input); err != nil {
+		cmd.Close()
+		return "", "", 1, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	var stdoutErr, stderrErr error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		_, stdoutErr = io.Copy(&stdout, cmd.Stdout)
+	}()
+	go func() {
+		defer wg.Done()
+		_, stderrErr = io.Copy(&stderr, cmd.Stderr)
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		cmd.Wait()
+		wg.Wait()
+		close(done)
+	}()
+
+	var expired <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-done:
+	case <-expired:
+		cmd.Close()
+		return "", "", 1, fmt.Errorf("Timeout waiting for script to complete on %s", c.ServerName)
+	}
+
+	if stdoutErr == nil {
+		stdoutErr = stderrErr
+	}
+	return stdout.String(), stderr.String(), cmd.ExitCode(), stdoutErr
+}
+
+// wsmanDuration formats d as the xs:duration used for WS-Management
+// timeouts, e.g. PT60S
+func wsmanDuration(d time.Duration) string {
+	seconds := int64(d / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+	return fmt.Sprintf("PT%dS", seconds)
+}
+
+// wsmanTimeout parses a timeout formatted by wsmanDuration, the default
+// timeout is returned when it cannot be parsed
+func wsmanTimeout(s string) time.Duration {
+	seconds, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimPrefix(s, "PT"), "S"), 64)
+	if err != nil || seconds <= 0 {
+		return defaultTimeout
+	}
+	return time.Duration(seconds * float64(time.Second))
+}
+
+// tlsError describes the server certificate failing verification
+func (c *Client) tlsError(err error) error {
+	return fmt.Errorf("TLS verification of %s failed, set the CA certificate that issued the WinRM certificate or disable verification: %v", c.ServerName, err)
+}
+
+// isTimeoutError returns if err was caused by a script running past the
+// deadline set by WithTimeout
+func isTimeoutError(err error) bool {
+	return strings.Contains(err.Error(), "Timeout waiting for script")
+}
+
+// isTLSError returns if err was caused by the server certificate failing
+// verification
+func isTLSError(err error) bool {
+	msg := err.Error()
+	return strings.Contains(msg, "x509:") || strings.Contains(msg, "tls:")
+}
@@ -0,0 +1,319 @@
+package dns
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientCertRequiresHTTPS(t *testing.T) {
+	c := Client{
+		ServerName: "dc.test.local",
+		Cert:       []byte("cert"),
+		Key:        []byte("key"),
+	}
+
+	if _, err := testConfigure(c); err == nil {
+		t.Fatal("Expected error when using a client certificate without HTTPS")
+	}
+}
+
+func TestClientUnknownAuthType(t *testing.T) {
+	c := Client{
+		ServerName: "dc.test.local",
+		AuthType:   "digest",
+	}
+
+	if _, err := testConfigure(c); err == nil {
+		t.Fatal("Expected error for unsupported authentication type")
+	}
+}
+
+func TestClientOperationTimeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	c := Client{
+		ServerName:        u.Hostname(),
+		Port:              port,
+		Username:          "user",
+		Password:          "pass",
+		ConnectionTimeout: time.Second,
+		OperationTimeout:  time.Second,
+	}
+
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	start := time.Now()
+	if _, err := client.Client.CreateShell(); err == nil {
+		t.Fatal("Expected error when the server does not answer")
+	}
+	if elapsed := time.Since(start); elapsed > 4*time.Second {
+		t.Fatalf("Expected request to time out after 2s, took %s", elapsed)
+	}
+}
+
+func TestClientWithTimeout(t *testing.T) {
+	server := httptest.NewServer(testSlowCommandHandler(t, "PT1S"))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	port, _ := strconv.Atoi(u.Port())
+
+	c := Client{
+		ServerName: u.Hostname(),
+		Port:       port,
+		Username:   "user",
+		Password:   "pass",
+	}
+
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	start := time.Now()
+	_, err = client.WithTimeout(time.Second).ExecutePowerShellScript("Start-Sleep 3600")
+	if err == nil || !strings.Contains(err.Error(), "Timeout") {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected script to be stopped after 1s, took %s", elapsed)
+	}
+	if client.Client.Parameters.Timeout != "PT60S" {
+		t.Fatalf("Expected provider client to keep its operation timeout, got %s", client.Client.Parameters.Timeout)
+	}
+}
+
+func TestClientPersistentShell(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		if strings.Contains(script, "fail") {
+			return "", "+ FullyQualifiedErrorId : WIN32 9714,Get-DnsServerResourceRecord"
+		}
+		return strings.TrimSpace(script) + "\r\n", ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	c.PersistentShell = true
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	for _, script := range []string{"one", "two", "three"} {
+		if _, err := client.ExecutePowerShellScript(script); err != nil {
+			t.Fatalf("Error running %s: %s", script, err)
+		}
+	}
+	if shells, closed := server.Shells(); shells != 1 || closed != 0 {
+		t.Fatalf("Expected scripts to run in one open shell, created %d and closed %d", shells, closed)
+	}
+	if scripts := server.Scripts(); len(scripts) != 3 || scripts[2] != "three" {
+		t.Fatalf("Unexpected scripts: %v", scripts)
+	}
+
+	if _, err := client.ExecutePowerShellScript("fail"); err == nil || !strings.Contains(err.Error(), "WIN32 9714") {
+		t.Fatalf("Expected script error, got %v", err)
+	}
+
+	client.Close()
+	if _, closed := server.Shells(); closed != 1 {
+		t.Fatal("Expected the shell to be closed")
+	}
+}
+
+func TestClientUnicodeScript(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return "", ""
+	})
+	defer server.Close()
+
+	script := "Add-DnsServerResourceRecord -Txt -DescriptiveText 'Café Zürich 日本 😀'"
+	for _, persistent := range []bool{false, true} {
+		c := server.config()
+		c.PersistentShell = persistent
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if _, err := client.ExecutePowerShellScript(script); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		client.Close()
+	}
+
+	scripts := server.Scripts()
+	if len(scripts) != 2 || !strings.Contains(scripts[0], script) || scripts[1] != script {
+		t.Fatalf("Expected script to be sent unchanged, got %q", scripts)
+	}
+}
+
+func TestClientLargeScript(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return fmt.Sprintf("%d\r\n", len(script)), ""
+	})
+	defer server.Close()
+
+	small := "Get-DnsServerZone -Name 'bücher.example.com'"
+	large := strings.Repeat("Add-DnsServerResourceRecord -ZoneName example.com -Txt -Name big -DescriptiveText 'Zürich'\n", 4000)
+	for _, backend := range []struct {
+		name       string
+		persistent bool
+	}{
+		{"powershell", false},
+		{"powershell", true},
+		{"psrp", false},
+	} {
+		c := server.config()
+		c.Backend = backend.name
+		c.PersistentShell = backend.persistent
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		for _, script := range []string{small, large} {
+			output, err := client.ExecutePowerShellScript(script)
+			if err != nil {
+				t.Fatalf("Error running a script of %d bytes with %s: %s", len(script), backend.name, err)
+			}
+			if objects := output.Objects(); backend.name == "psrp" && (len(objects) != 1 || objects[0] != fmt.Sprintf("%d\r\n", len(script))) {
+				t.Fatalf("Unexpected output %v", objects)
+			}
+		}
+		client.Close()
+	}
+
+	scripts := server.Scripts()
+	if len(scripts) != 6 {
+		t.Fatalf("Expected 6 scripts to run, got %d", len(scripts))
+	}
+	for i, script := range scripts {
+		expected := small
+		if i%2 == 1 {
+			expected = large
+		}
+		if script != expected {
+			t.Fatalf("Script %d of %d bytes was not received unchanged, got %d bytes", i, len(expected), len(script))
+		}
+	}
+}
+
+func TestClientMaxConcurrentOperations(t *testing.T) {
+	var mu sync.Mutex
+	running, most := 0, 0
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		running++
+		if running > most {
+			most = running
+		}
+		mu.Unlock()
+		time.Sleep(50 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		return "ok\r\n", ""
+	})
+	defer server.Close()
+
+	for _, tc := range []struct {
+		backend    string
+		persistent bool
+	}{
+		{"powershell", false},
+		{"powershell", true},
+		{"psrp", false},
+	} {
+		mu.Lock()
+		most = 0
+		mu.Unlock()
+
+		c := server.config()
+		c.Backend = tc.backend
+		c.PersistentShell = tc.persistent
+		c.MaxConcurrentOperations = 2
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 6)
+		for i := 0; i < 6; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				if _, err := client.ExecutePowerShellScript(fmt.Sprintf("script %d", i)); err != nil {
+					errs <- err
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Errorf("%s: %s", tc.backend, err)
+		}
+		client.Close()
+
+		mu.Lock()
+		if most != 2 {
+			t.Errorf("%s persistent %t: expected 2 scripts to run at once, %d did", tc.backend, tc.persistent, most)
+		}
+		mu.Unlock()
+	}
+}
+
+func TestClientMaxConcurrentOperations_Timeout(t *testing.T) {
+	started := make(chan struct{})
+	finish := make(chan struct{})
+	server := newTestWinRM(t, func(script string) (string, string) {
+		if script == "slow" {
+			close(started)
+			<-finish
+		}
+		return "ok\r\n", ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	c.MaxConcurrentOperations = 1
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := client.ExecutePowerShellScript("slow")
+		done <- err
+	}()
+	<-started
+
+	_, err = client.WithTimeout(100 * time.Millisecond).ExecutePowerShellScript("fast")
+	if err == nil || !strings.Contains(err.Error(), "Timeout waiting for script to start") {
+		t.Fatalf("Expected timeout waiting for the running script, got %v", err)
+	}
+
+	close(finish)
+	if err := <-done; err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := client.ExecutePowerShellScript("fast"); err != nil {
+		t.Fatalf("Expected script to run once the slot is free, got %s", err)
+	}
+}
@@ -0,0 +1,215 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// PSObject is an object deserialised from the output of a PowerShell
+// pipeline, Value holds the list, dictionary or primitive it wraps if any
+type PSObject struct {
+	TypeNames  []string
+	ToString   string
+	Properties map[string]interface{}
+	Value      interface{}
+}
+
+// String returns the text PowerShell displays for the object
+func (o *PSObject) String() string {
+	if o.ToString != "" || o.Value == nil {
+		return o.ToString
+	}
+	return formatPSValue(o.Value)
+}
+
+// formatPSValue renders a deserialised value as text
+func formatPSValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case *PSObject:
+		return v.String()
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// clixmlNode is an element of a CLIXML document
+type clixmlNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr   `xml:",any,attr"`
+	Content string       `xml:",chardata"`
+	Nodes   []clixmlNode `xml:",any"`
+}
+
+func (n *clixmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// clixmlDecoder deserialises the CLIXML of a PSRP message, objects and type
+// names are referenced by id within a message
+type clixmlDecoder struct {
+	objects map[string]*PSObject
+	types   map[string][]string
+}
+
+// decodeCLIXML deserialises the object serialised in data
+func decodeCLIXML(data []byte) (interface{}, error) {
+	var node clixmlNode
+	if err := xml.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("Invalid PowerShell object: %v", err)
+	}
+	d := &clixmlDecoder{objects: map[string]*PSObject{}, types: map[string][]string{}}
+	return d.decode(&node), nil
+}
+
+func (d *clixmlDecoder) decode(n *clixmlNode) interface{} {
+	switch n.XMLName.Local {
+	case "Nil":
+		return nil
+	case "S", "G", "URI", "Version", "XD", "SBK", "TS":
+		return decodeCLIXMLString(n.Content)
+	case "C":
+		c, _ := strconv.Atoi(n.Content)
+		return string(rune(c))
+	case "B":
+		return n.Content == "true"
+	case "SB", "I16", "I32", "I64":
+		i, _ := strconv.ParseInt(n.Content, 10, 64)
+		return i
+	case "By", "U16", "U32", "U64":
+		u, _ := strconv.ParseUint(n.Content, 10, 64)
+		return u
+	case "Sg", "Db", "D":
+		f, _ := strconv.ParseFloat(n.Content, 64)
+		return f
+	case "DT":
+		if t, err := time.Parse(time.RFC3339Nano, n.Content); err == nil {
+			return t
+		}
+		return n.Content
+	case "BA":
+		b, _ := base64.StdEncoding.DecodeString(n.Content)
+		return b
+	case "Ref":
+		if o, ok := d.objects[n.attr("RefId")]; ok {
+			return o
+		}
+		return nil
+	case "Obj":
+		return d.decodeObject(n)
+	default:
+		return decodeCLIXMLString(n.Content)
+	}
+}
+
+func (d *clixmlDecoder) decodeObject(n *clixmlNode) *PSObject {
+	o := &PSObject{Properties: map[string]interface{}{}}
+	if id := n.attr("RefId"); id != "" {
+		d.objects[id] = o
+	}
+
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		switch child.XMLName.Local {
+		case "TN":
+			for _, t := range child.Nodes {
+				o.TypeNames = append(o.TypeNames, t.Content)
+			}
+			d.types[child.attr("RefId")] = o.TypeNames
+		case "TNRef":
+			o.TypeNames = d.types[child.attr("RefId")]
+		case "ToString":
+			o.ToString = decodeCLIXMLString(child.Content)
+		case "Props", "MS":
+			for j := range child.Nodes {
+				property := &child.Nodes[j]
+				o.Properties[decodeCLIXMLString(property.attr("N"))] = d.decode(property)
+			}
+		case "LST", "IE", "STK", "QUE":
+			list := []interface{}{}
+			for j := range child.Nodes {
+				list = append(list, d.decode(&child.Nodes[j]))
+			}
+			o.Value = list
+		case "DCT":
+			dict := map[string]interface{}{}
+			for _, entry := range child.Nodes {
+				var key string
+				var value interface{}
+				for k := range entry.Nodes {
+					switch entry.Nodes[k].attr("N") {
+					case "Key":
+						key = formatPSValue(d.decode(&entry.Nodes[k]))
+					case "Value":
+						value = d.decode(&entry.Nodes[k])
+					}
+				}
+				dict[key] = value
+			}
+			o.Value = dict
+		default:
+			o.Value = d.decode(child)
+		}
+	}
+	return o
+}
+
+// decodeCLIXMLString reverses the _xHHHH_ escaping of characters that
+// cannot be written in XML
+func decodeCLIXMLString(s string) string {
+	if !strings.Contains(s, "_x") {
+		return s
+	}
+
+	var out strings.Builder
+	var units []uint16
+	flush := func() {
+		out.WriteString(string(utf16.Decode(units)))
+		units = units[:0]
+	}
+	for i := 0; i < len(s); {
+		if i+7 <= len(s) && s[i] == '_' && s[i+1] == 'x' && s[i+6] == '_' {
+			if u, err := strconv.ParseUint(s[i+2:i+6], 16, 16); err == nil {
+				units = append(units, uint16(u))
+				i += 7
+				continue
+			}
+		}
+		flush()
+		out.WriteByte(s[i])
+		i++
+	}
+	flush()
+	return out.String()
+}
+
+// encodeCLIXMLString escapes s for a CLIXML string element, characters XML
+// cannot hold and the start of an escape sequence are written as _xHHHH_
+func encodeCLIXMLString(s string) string {
+	var out bytes.Buffer
+	for i, r := range s {
+		switch {
+		case r == '_' && strings.HasPrefix(s[i+1:], "x"):
+			out.WriteString("_x005F_")
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r':
+			fmt.Fprintf(&out, "_x%04X_", r)
+		default:
+			xml.EscapeText(&out, []byte(string(r)))
+		}
+	}
+	return out.String()
+}
@@ -17,14 +17,49 @@ type Record struct {
 	NewTTL   float64
 }
 
-// ReadRecords returns all DNS records matching query
+// ReadRecords returns all DNS records matching query, records are served from
+// a snapshot of the zone taken on the first read
 func (c *Client) ReadRecords(rec Record) ([]Record, error) {
+	if c.zones != nil && rec.Name != "" {
+		return c.zones.records(c, rec)
+	}
+	return c.queryRecords(rec)
+}
+
+// tmplAddRecordA and tmplAddRecordCname are the templates used to add a
+// record
+const (
+	tmplAddRecordA = `
+Add-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -Name {{ .Name }} -A -IPv4Address {{ .Value }} -TimeToLive {{ timespan .TTL }}
+`
+	tmplAddRecordCname = `
+Add-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -Name {{ .Name }} -CName -HostNameAlias {{ .Value }} -TimeToLive {{ timespan .TTL }}
+`
+)
+
+// tmplRemoveRecord removes a record on restricted endpoints, where records
+// cannot be filtered before being piped to Remove-DnsServerResourceRecord
+const tmplRemoveRecord = `
+Remove-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -Name {{ .Name }} -RRType {{ .Type }} -RecordData {{ .Value }} -Force
+`
+
+// queryRecords reads the DNS records matching query from the server
+func (c *Client) queryRecords(rec Record) ([]Record, error) {
 	// powershell script template to read record from DNS
 	const tmplpscript = `
 Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}{{ if .Name }} -Name {{.Name}}{{end}} | ?{($_.RecordType -eq 'A' -or $_.RecordType -eq 'CNAME') -and $_.HostName -eq '{{ .Name }}'} | select DistinguishedName, HostName, RecordData, RecordType, TimeToLive | ConvertTo-Json
 `
+	// restricted endpoints return the records as objects, which are
+	// filtered here
+	const tmplpscriptRestricted = `
+Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}{{ if .Name }} -Name {{.Name}}{{end}}
+`
+	tmpl := tmplpscript
+	if c.restricted() {
+		tmpl = tmplpscriptRestricted
+	}
 
-	pscript, err := tmplExec(rec, tmplpscript)
+	pscript, err := tmplExec(rec, tmpl)
 	if err != nil {
 		return []Record{}, fmt.Errorf("Creating template: %v", err)
 	}
@@ -32,6 +67,18 @@ Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}{{ if .Name }} -Name {{.Name}}
 	if err != nil {
 		return []Record{}, fmt.Errorf("Running PowerShell script: %v", err)
 	}
+	if c.restricted() {
+		var records []Record
+		for _, r := range recordObjects(output.objects, rec.Dnszone) {
+			if strings.EqualFold(r.Name, rec.Name) {
+				records = append(records, r)
+			}
+		}
+		if len(records) == 0 {
+			return []Record{}, fmt.Errorf("No Record found: %v", rec.Name)
+		}
+		return records, nil
+	}
 	if output.stdout == "" {
 		return []Record{}, fmt.Errorf("No Record found: %v", rec.Name)
 	}
@@ -45,29 +92,11 @@ Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}{{ if .Name }} -Name {{.Name}}
 
 // ReadRecord performs DNS Record lookup from server
 func (c *Client) ReadRecord(rec Record) (Record, error) {
-	// powershell script template to read record from DNS
-	const tmplpscript = `
-Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}{{ if .Name }} -Name {{.Name}}{{end}} | ?{($_.RecordType -eq 'A' -or $_.RecordType -eq 'CNAME') -and $_.HostName -eq '{{ .Name }}'} | select DistinguishedName, HostName, RecordData, RecordType, TimeToLive | ConvertTo-Json
-`
-
-	pscript, err := tmplExec(rec, tmplpscript)
+	records, err := c.ReadRecords(rec)
 	if err != nil {
-		return Record{}, fmt.Errorf("Creating template: %v", err)
+		return Record{}, err
 	}
-	output, err := c.ExecutePowerShellScript(pscript)
-	if err != nil {
-		return Record{}, fmt.Errorf("Running PowerShell script: %v", err)
-	}
-	if output.stdout == "" {
-		return Record{}, fmt.Errorf("No Record found: %v", rec.Name)
-	}
-	output.stdout = makeResponseArray(output.stdout)
-
-	resp, err := unmarshalResponse(output.stdout)
-	if err != nil {
-		return Record{}, fmt.Errorf("Unmarshalling response: %v", err)
-	}
-	for _, v := range *convertResponse(resp, rec) {
+	for _, v := range records {
 		if v.Value == rec.Value {
 			return v, nil
 		}
@@ -100,41 +129,26 @@ func (c *Client) ReadRecordfromID(recID string) (Record, error) {
 
 // CreateRecord creates new DNS records on server
 func (c *Client) CreateRecord(rec Record) ([]Record, error) {
-	const tmplscriptA = `
-Add-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -Name {{ .Name }} -A -IPv4Address {{ .Value }} -TimeToLive (New-TimeSpan -Seconds {{ .TTL }})
-`
-	const tmplscriptCname = `
-Add-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -Name {{ .Name }} -CName -HostNameAlias {{ .Value }} -TimeToLive (New-TimeSpan -Seconds {{ .TTL }})
-`
-	var (
-		pscript string
-		err     error
-	)
-
 	if c.RecordExist(rec) {
 		return []Record{}, fmt.Errorf("Record already exists: %v", rec)
 	}
 	rec.ID = fmt.Sprintf("%s|%s|%s", rec.Dnszone, rec.Name, rec.Value)
-	switch rec.Type {
-	case "A":
-		pscript, err = tmplExec(rec, tmplscriptA)
-		if err != nil {
-			return []Record{}, fmt.Errorf("Creating template: %v", err)
-		}
-	case "CNAME":
-		pscript, err = tmplExec(rec, tmplscriptCname)
-		if err != nil {
-			return []Record{}, fmt.Errorf("Creating template: %v", err)
-		}
-	}
-	_, err = c.ExecutePowerShellScript(pscript)
+	err := c.addRecord(rec)
+	c.invalidate(rec)
 	if err != nil {
-		return []Record{}, fmt.Errorf("Executing PowerShell script: %v", err)
+		return []Record{}, err
 	}
-	record, err := c.ReadRecordfromID(rec.ID)
+	var record Record
+	err = c.readAfterChange(func() error {
+		c.invalidate(rec)
+		var err error
+		record, err = c.ReadRecordfromID(rec.ID)
+		return err
+	})
 	if err != nil {
 		return []Record{}, fmt.Errorf("Reading record: %v", err)
 	}
+	c.waitForReplication(record, true)
 
 	var result []Record
 	result = append(result, record)
@@ -159,24 +173,34 @@ func (c *Client) DeleteRecord(rec Record) error {
 		return fmt.Errorf("Record not found: %v", rec)
 	}
 
-	switch rec.Type {
-	case "A":
+	switch {
+	case c.restricted():
+		pscript, err = tmplExec(rec, tmplRemoveRecord)
+		if err != nil {
+			return fmt.Errorf("Creating template: %v", err)
+		}
+	case rec.Type == "A":
 		pscript, err = tmplExec(rec, tmplscriptA)
 		if err != nil {
 			return fmt.Errorf("Creating template: %v", err)
 		}
-	case "CNAME":
+	case rec.Type == "CNAME":
 		pscript, err = tmplExec(rec, tmplscriptCname)
 		if err != nil {
 			return fmt.Errorf("Creating template: %v", err)
 		}
 	}
 
-	_, err = c.ExecutePowerShellScript(pscript)
+	err = c.executeChange(pscript, func() (bool, error) {
+		c.invalidate(rec)
+		return !c.RecordExist(rec), nil
+	})
+	c.invalidate(rec)
 	if err != nil {
 		return fmt.Errorf("Executing PowerShell script: %v", err)
 	}
 
+	c.waitForReplication(rec, false)
 	return nil
 }
 
@@ -218,35 +242,132 @@ Set-DnsServerResourceRecord -ZoneName {{ .Dnszone }} -NewInputObject $new -OldIn
 	}
 	rec.NewValue = newValue
 	rec.NewTTL = newTTL
-	switch rec.Type {
-	case "A":
-		pscript, err = tmplExec(rec, tmplscriptA)
+	if c.restricted() {
+		err = c.replaceRecord(rec)
+		c.invalidate(rec)
 		if err != nil {
-			return Record{}, fmt.Errorf("Createing template: %v", err)
+			return Record{}, err
 		}
-	case "CNAME":
-		pscript, err = tmplExec(rec, tmplscriptCname)
-		if err != nil {
-			return Record{}, fmt.Errorf("Createing template: %v", err)
+	} else {
+		switch rec.Type {
+		case "A":
+			pscript, err = tmplExec(rec, tmplscriptA)
+			if err != nil {
+				return Record{}, fmt.Errorf("Createing template: %v", err)
+			}
+		case "CNAME":
+			pscript, err = tmplExec(rec, tmplscriptCname)
+			if err != nil {
+				return Record{}, fmt.Errorf("Createing template: %v", err)
+			}
 		}
-	}
 
-	_, err = c.ExecutePowerShellScript(pscript)
-	if err != nil {
-		return Record{}, fmt.Errorf("Excuting PowerShell script: %v", err)
+		_, err = c.ExecutePowerShellScript(pscript)
+		c.invalidate(rec)
+		if err != nil {
+			return Record{}, fmt.Errorf("Excuting PowerShell script: %v", err)
+		}
 	}
 	if rec.NewValue != "" {
 		rec.Value = rec.NewValue
 	}
 
-	rec, err = c.ReadRecord(rec)
+	updated := rec
+	err = c.readAfterChange(func() error {
+		c.invalidate(updated)
+		var err error
+		rec, err = c.ReadRecord(updated)
+		return err
+	})
 	if err != nil {
 		return Record{}, fmt.Errorf("Reading updated record: %v", err)
 	}
+	c.waitForReplication(rec, true)
 
 	return rec, nil
 }
 
+// addRecord adds rec, an attempt whose response was lost is not repeated
+// once the record is found
+func (c *Client) addRecord(rec Record) error {
+	var (
+		pscript string
+		err     error
+	)
+	switch rec.Type {
+	case "A":
+		pscript, err = tmplExec(rec, tmplAddRecordA)
+	case "CNAME":
+		pscript, err = tmplExec(rec, tmplAddRecordCname)
+	}
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	err = c.executeChange(pscript, func() (bool, error) {
+		c.invalidate(rec)
+		return c.RecordExist(rec), nil
+	})
+	if err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+	return nil
+}
+
+// replaceRecord changes a record on a restricted endpoint, where the
+// changed record cannot be built for Set-DnsServerResourceRecord, by
+// removing it and adding it again with the new value and TTL
+func (c *Client) replaceRecord(rec Record) error {
+	updated := rec
+	if rec.NewValue != "" {
+		updated.Value = rec.NewValue
+	}
+	if rec.NewTTL != 0 {
+		updated.TTL = rec.NewTTL
+	}
+
+	pscript, err := tmplExec(rec, tmplRemoveRecord)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	err = c.executeChange(pscript, func() (bool, error) {
+		c.invalidate(rec)
+		return !c.RecordExist(rec), nil
+	})
+	c.invalidate(rec)
+	if err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+	if err := c.addRecord(updated); err != nil {
+		return fmt.Errorf("Adding %s.%s after removing it: %v", rec.Name, rec.Dnszone, err)
+	}
+	return nil
+}
+
+// recordObjects returns the A and CNAME records among the objects written
+// by Get-DnsServerResourceRecord
+func recordObjects(objects []interface{}, zone string) []Record {
+	var records []Record
+	for _, o := range objects {
+		rec := Record{
+			Dnszone: zone,
+			Name:    formatPSValue(psProperty(o, "HostName")),
+			Type:    formatPSValue(psProperty(o, "RecordType")),
+			TTL:     psSeconds(psProperty(o, "TimeToLive")),
+		}
+		switch rec.Type {
+		case "A":
+			rec.Value = formatPSValue(psProperty(psProperty(o, "RecordData"), "IPv4Address"))
+		case "CNAME":
+			rec.Value = formatPSValue(psProperty(psProperty(o, "RecordData"), "HostNameAlias"))
+		default:
+			continue
+		}
+		rec.ID = fmt.Sprintf("%s|%s|%s", rec.Dnszone, rec.Name, rec.Value)
+		records = append(records, rec)
+	}
+	return records
+}
+
 // RecordExist returns if record exists or not
 func (c *Client) RecordExist(rec Record) bool {
 	var records []Record
@@ -271,3 +392,10 @@ func (c *Client) RecordExist(rec Record) bool {
 	}
 	return false
 }
+
+// invalidate makes the next read of rec's name fetch it from the server
+func (c *Client) invalidate(rec Record) {
+	if c.zones != nil {
+		c.zones.invalidate(rec.Dnszone, rec.Name)
+	}
+}
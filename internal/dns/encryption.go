@@ -0,0 +1,98 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Values used to build multipart/encrypted WinRM messages, see MS-WSMV
+// 2.2.9.1
+const (
+	encryptedBoundary       = "--Encrypted Boundary"
+	encryptedStreamType     = "\tContent-Type: application/octet-stream\r\n"
+	spnegoEncryptedProtocol = "application/HTTP-SPNEGO-session-encrypted"
+)
+
+// sealer encrypts and decrypts messages with the keys established when
+// authenticating
+type sealer interface {
+	// seal returns the signature and encrypted data of message
+	seal(message []byte) ([]byte, []byte, error)
+	// unseal verifies the signature and decrypts data
+	unseal(signature, data []byte) ([]byte, error)
+}
+
+// encryptedContentType returns the Content-Type header of a message
+// encrypted with protocol
+func encryptedContentType(protocol string) string {
+	return fmt.Sprintf(`multipart/encrypted;protocol="%s";boundary="Encrypted Boundary"`, protocol)
+}
+
+// encryptMessage seals message and wraps it in a multipart/encrypted body
+func encryptMessage(s sealer, protocol string, message []byte) ([]byte, error) {
+	signature, data, err := s.seal(message)
+	if err != nil {
+		return nil, fmt.Errorf("Error encrypting message: %v", err)
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString(encryptedBoundary + "\r\n")
+	b.WriteString("\tContent-Type: " + protocol + "\r\n")
+	b.WriteString(fmt.Sprintf("\tOriginalContent: type=%s;Length=%d\r\n", soapContentType, len(message)))
+	b.WriteString(encryptedBoundary + "\r\n")
+	b.WriteString(encryptedStreamType)
+	binary.Write(&b, binary.LittleEndian, uint32(len(signature)))
+	b.Write(signature)
+	b.Write(data)
+	b.WriteString(encryptedBoundary + "--\r\n")
+
+	return b.Bytes(), nil
+}
+
+// decryptMessage unwraps a multipart/encrypted body and unseals it
+func decryptMessage(s sealer, body []byte) ([]byte, error) {
+	parts := bytes.Split(body, []byte(encryptedBoundary+"\r\n"))
+	var sections [][]byte
+	for _, p := range parts {
+		if len(p) > 0 {
+			sections = append(sections, p)
+		}
+	}
+	if len(sections) != 2 {
+		return nil, errors.New("Invalid encrypted message")
+	}
+
+	header := string(sections[0])
+	i := strings.Index(header, "Length=")
+	if i < 0 {
+		return nil, errors.New("Encrypted message has no length")
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(header[i+len("Length="):]))
+	if err != nil {
+		return nil, fmt.Errorf("Invalid encrypted message length: %v", err)
+	}
+
+	stream := bytes.TrimSuffix(sections[1], []byte(encryptedBoundary+"--\r\n"))
+	stream = bytes.TrimPrefix(stream, []byte(encryptedStreamType))
+	if len(stream) < 4 {
+		return nil, errors.New("Encrypted message is too short")
+	}
+	signatureLength := int(binary.LittleEndian.Uint32(stream[:4]))
+	if len(stream) < 4+signatureLength {
+		return nil, errors.New("Encrypted message is too short")
+	}
+
+	message, err := s.unseal(stream[4:4+signatureLength], stream[4+signatureLength:])
+	if err != nil {
+		return nil, fmt.Errorf("Error decrypting message: %v", err)
+	}
+	if len(message) != length {
+		return nil, fmt.Errorf("Decrypted message length %d does not match %d", len(message), length)
+	}
+
+	return message, nil
+}
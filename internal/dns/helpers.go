@@ -3,17 +3,20 @@ package dns
 import (
 	"bytes"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"text/template"
+	"unicode/utf16"
 
 	"github.com/olekukonko/tablewriter"
 )
 
-func tmplExec(r Record, tp string) (string, error) {
-	t := template.New("tmpl")
+func tmplExec(r interface{}, tp string) (string, error) {
+	t := template.New("tmpl").Funcs(template.FuncMap{"timespan": timeSpan})
 	t, err := t.Parse(tp)
 	if err != nil {
 		return "", fmt.Errorf("Parsing template: %v", err)
@@ -81,12 +84,50 @@ func OutputTable(rec []Record) {
 	table.Render()
 }
 
+// stdinLoader reads a base64 encoded script from stdin up to an empty line
+// and runs it, for scripts too long to pass on the command line
+const stdinLoader = `
+$encoded = New-Object Text.StringBuilder
+while ($true) {
+	$line = [Console]::In.ReadLine()
+	if ([string]::IsNullOrEmpty($line)) { break }
+	[void]$encoded.Append($line)
+}
+& ([ScriptBlock]::Create([Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($encoded.ToString()))))
+`
+
+// loaderInput returns the stdin sent to stdinLoader to run psCmd
+func loaderInput(psCmd string) string {
+	return base64.StdEncoding.EncodeToString([]byte(psCmd)) + "\r\n\r\n"
+}
+
+// writeInput sends input to a command in chunks that fit in a WinRM
+// request once encoded
+func writeInput(w io.Writer, input string) error {
+	for len(input) > 0 {
+		n := len(input)
+		if n > maxInputChunk {
+			n = maxInputChunk
+		}
+		if written, err := w.Write([]byte(input[:n])); err != nil || written != n {
+			return fmt.Errorf("Error sending script input: %v", err)
+		}
+		input = input[n:]
+	}
+	return nil
+}
+
 func powershell(psCmd string) string {
-	wideCmd := ""
-	for _, b := range []byte(psCmd) {
-		wideCmd += string(b) + "\x00"
+	return fmt.Sprintf("powershell.exe -EncodedCommand %s", encodePowerShell(psCmd))
+}
+
+// encodePowerShell encodes a script as UTF-16LE for the -EncodedCommand
+// argument
+func encodePowerShell(psCmd string) string {
+	wideCmd := utf16.Encode([]rune(psCmd))
+	input := make([]byte, 2*len(wideCmd))
+	for i, u := range wideCmd {
+		binary.LittleEndian.PutUint16(input[2*i:], u)
 	}
-	input := []uint8(wideCmd)
-	encodedCmd := base64.StdEncoding.EncodeToString(input)
-	return fmt.Sprintf("powershell.exe -EncodedCommand %s", encodedCmd)
+	return base64.StdEncoding.EncodeToString(input)
 }
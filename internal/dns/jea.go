@@ -0,0 +1,335 @@
+package dns
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// psrpResourceURIPrefix is prepended to ConfigurationName when it is not a
+// resource URI
+const psrpResourceURIPrefix = "http://schemas.microsoft.com/powershell/"
+
+// psCommand is a command of a pipeline sent to the runspace pool, script is
+// set when name is the text of a script rather than a command
+type psCommand struct {
+	name   string
+	script bool
+	args   []psArgument
+}
+
+// psArgument is a parameter of a command, name is empty for a positional
+// argument and value is true for a switch
+type psArgument struct {
+	name  string
+	value interface{}
+}
+
+// restricted returns if scripts run on a session configuration such as a
+// JEA endpoint, which only runs commands and does not accept script text
+func (c *Client) restricted() bool {
+	return c.ConfigurationName != ""
+}
+
+// resourceURI returns the resource URI of the session configuration
+// runspace pools are opened in
+func (c *Client) resourceURI() string {
+	switch {
+	case c.ConfigurationName == "":
+		return psrpResourceURI
+	case strings.Contains(c.ConfigurationName, "://"):
+		return c.ConfigurationName
+	default:
+		return psrpResourceURIPrefix + c.ConfigurationName
+	}
+}
+
+// pipelines returns the pipelines running pscript, the script itself unless
+// the client is restricted, when each of its statements is sent as a
+// pipeline of commands
+func (c *Client) pipelines(pscript string) ([][]psCommand, error) {
+	if !c.restricted() {
+		return [][]psCommand{{{name: pscript, script: true}}}, nil
+	}
+	statements, err := parseStatements(pscript)
+	if err != nil {
+		return nil, fmt.Errorf("Script cannot run on configuration %s: %v", c.ConfigurationName, err)
+	}
+	return statements, nil
+}
+
+// psToken is a token of a script read by parseStatements
+type psToken struct {
+	kind byte
+	text string
+	// colon is set on a parameter given its value as -Name:value
+	colon bool
+}
+
+// Token kinds, words are bare arguments and strings single quoted ones
+const (
+	psTokenWord      = 'w'
+	psTokenString    = 's'
+	psTokenParameter = 'p'
+	psTokenPipe      = '|'
+	psTokenComma     = ','
+	psTokenEnd       = ';'
+)
+
+// parseStatements reads a script made only of pipelines of commands with
+// literal arguments, as endpoints in NoLanguage mode accept, into commands.
+// Variables, script blocks, subexpressions and double quoted strings are
+// rejected, @() is taken as an empty array. A parameter followed by a value takes it, so switches cannot be
+// followed by positional arguments
+func parseStatements(script string) ([][]psCommand, error) {
+	tokens, err := tokenize(script)
+	if err != nil {
+		return nil, err
+	}
+
+	var statements [][]psCommand
+	var pipeline []psCommand
+	for i := 0; i < len(tokens); {
+		t := tokens[i]
+		switch {
+		case t.kind == psTokenEnd:
+			if len(pipeline) > 0 {
+				statements = append(statements, pipeline)
+				pipeline = nil
+			}
+			i++
+			continue
+		case t.kind == psTokenPipe && len(pipeline) > 0 && i+1 < len(tokens) && tokens[i+1].kind == psTokenWord:
+			i++
+			continue
+		case t.kind != psTokenWord || (len(pipeline) > 0 && tokens[i-1].kind != psTokenPipe):
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+
+		cmd := psCommand{name: t.text}
+		for i++; i < len(tokens) && tokens[i].kind != psTokenPipe && tokens[i].kind != psTokenEnd; {
+			arg := psArgument{}
+			if tokens[i].kind == psTokenParameter {
+				arg.name = tokens[i].text
+				arg.value = true
+				i++
+				if !tokens[i-1].colon && (i == len(tokens) || (tokens[i].kind != psTokenWord && tokens[i].kind != psTokenString)) {
+					cmd.args = append(cmd.args, arg)
+					continue
+				}
+			}
+			if arg.value, i, err = parseValue(tokens, i); err != nil {
+				return nil, err
+			}
+			cmd.args = append(cmd.args, arg)
+		}
+		pipeline = append(pipeline, cmd)
+	}
+	if len(pipeline) > 0 {
+		statements = append(statements, pipeline)
+	}
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("no commands")
+	}
+	return statements, nil
+}
+
+// parseValue reads the value of an argument starting at tokens[i], values
+// separated by commas are an array, and returns the index after it
+func parseValue(tokens []psToken, i int) (interface{}, int, error) {
+	var values []interface{}
+	for {
+		if i == len(tokens) || (tokens[i].kind != psTokenWord && tokens[i].kind != psTokenString) {
+			return nil, i, fmt.Errorf("missing value")
+		}
+		values = append(values, literalValue(tokens[i]))
+		i++
+		if i == len(tokens) || tokens[i].kind != psTokenComma {
+			break
+		}
+		i++
+	}
+	if len(values) == 1 {
+		return values[0], i, nil
+	}
+	return values, i, nil
+}
+
+// literalValue converts a token to the value PowerShell would bind
+func literalValue(t psToken) interface{} {
+	if t.kind == psTokenString {
+		return t.text
+	}
+	switch strings.ToLower(t.text) {
+	case "$true":
+		return true
+	case "$false":
+		return false
+	case "$null":
+		return nil
+	case "@()":
+		return []interface{}{}
+	}
+	if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(t.text, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return f
+	}
+	return t.text
+}
+
+// tokenize splits script into tokens, failing on anything that is not a
+// literal
+func tokenize(script string) ([]psToken, error) {
+	var tokens []psToken
+	r := []rune(script)
+	for i := 0; i < len(r); {
+		switch c := r[i]; {
+		case c == '\n' || c == ';':
+			tokens = append(tokens, psToken{kind: psTokenEnd, text: string(c)})
+			i++
+		case unicode.IsSpace(c):
+			i++
+		case c == '|' || c == ',':
+			tokens = append(tokens, psToken{kind: byte(c), text: string(c)})
+			i++
+		case c == '\'':
+			var s strings.Builder
+			for i++; ; i++ {
+				if i == len(r) {
+					return nil, fmt.Errorf("unterminated string")
+				}
+				if r[i] == '\'' {
+					if i+1 < len(r) && r[i+1] == '\'' {
+						i++
+					} else {
+						break
+					}
+				}
+				s.WriteRune(r[i])
+			}
+			tokens = append(tokens, psToken{kind: psTokenString, text: s.String()})
+			i++
+		case c == '-' && i+1 < len(r) && unicode.IsLetter(r[i+1]):
+			start := i + 1
+			for i = start; i < len(r) && (unicode.IsLetter(r[i]) || unicode.IsDigit(r[i]) || r[i] == '_'); i++ {
+			}
+			t := psToken{kind: psTokenParameter, text: string(r[start:i])}
+			if i < len(r) && r[i] == ':' {
+				t.colon = true
+				i++
+			}
+			tokens = append(tokens, t)
+		default:
+			start := i
+			for ; i < len(r) && !unicode.IsSpace(r[i]) && !strings.ContainsRune("|,;'", r[i]); i++ {
+			}
+			word := string(r[start:i])
+			switch strings.ToLower(word) {
+			case "$true", "$false", "$null", "@()":
+			default:
+				if strings.ContainsAny(word, "$(){}[]@\"`&#=<>") {
+					return nil, fmt.Errorf("%q is not a literal", word)
+				}
+			}
+			tokens = append(tokens, psToken{kind: psTokenWord, text: word})
+		}
+	}
+	return tokens, nil
+}
+
+// timeSpan formats seconds as a TimeSpan literal, e.g. '0.00:10:00', so
+// scripts do not need New-TimeSpan
+func timeSpan(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second))
+	days := d / (24 * time.Hour)
+	d -= days * 24 * time.Hour
+	s := fmt.Sprintf("'%d.%02d:%02d:%02d", days, d/time.Hour, d%time.Hour/time.Minute, d%time.Minute/time.Second)
+	if frac := d % time.Second; frac != 0 {
+		s += fmt.Sprintf(".%07d", frac/100)
+	}
+	return s + "'"
+}
+
+// psSeconds returns the seconds of a deserialised TimeSpan, an xs:duration
+// such as PT10M
+func psSeconds(v interface{}) float64 {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(s, "P") {
+		return 0
+	}
+	var seconds, n float64
+	var number strings.Builder
+	inTime := false
+	for _, c := range s[1:] {
+		switch {
+		case c == 'T':
+			inTime = true
+			continue
+		case unicode.IsDigit(c) || c == '.':
+			number.WriteRune(c)
+			continue
+		}
+		n, _ = strconv.ParseFloat(number.String(), 64)
+		number.Reset()
+		switch {
+		case c == 'D':
+			seconds += n * 86400
+		case c == 'H':
+			seconds += n * 3600
+		case c == 'M' && inTime:
+			seconds += n * 60
+		case c == 'S':
+			seconds += n
+		}
+	}
+	return seconds
+}
+
+// psNumber returns a deserialised number as a float64
+func psNumber(v interface{}) float64 {
+	switch v := v.(type) {
+	case int64:
+		return float64(v)
+	case uint64:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// psStrings returns the text of each item of a deserialised list, a value
+// that is not a list is a list of one
+func psStrings(v interface{}) []string {
+	items := []interface{}{v}
+	if o, ok := v.(*PSObject); ok {
+		if list, ok := o.Value.([]interface{}); ok {
+			items = list
+		}
+	}
+	var result []string
+	for _, item := range items {
+		if item != nil {
+			result = append(result, formatPSValue(item))
+		}
+	}
+	return result
+}
+
+// psObjects returns the items of a deserialised list
+func psObjects(v interface{}) []interface{} {
+	if o, ok := v.(*PSObject); ok {
+		if list, ok := o.Value.([]interface{}); ok {
+			return list
+		}
+	}
+	if v == nil {
+		return nil
+	}
+	return []interface{}{v}
+}
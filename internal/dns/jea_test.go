@@ -0,0 +1,175 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientConfigurationName(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return "", ""
+	})
+	server.configuration = "http://schemas.microsoft.com/powershell/DnsOperators"
+	defer server.Close()
+
+	for _, name := range []string{"DnsOperators", server.configuration} {
+		c := server.config()
+		c.ConfigurationName = name
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if _, err := client.ExecutePowerShellScript("Get-DnsServerZone -Name 'test.local'"); err != nil {
+			t.Fatalf("Error running command on %s: %s", name, err)
+		}
+		client.Close()
+	}
+
+	c := server.config()
+	c.ConfigurationName = "DnsOperators"
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer client.Close()
+	before := len(server.Scripts())
+	if err := client.ClearServerCache("www.test.local"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := client.ExecutePowerShellScript("\nAdd-DnsServerRecursionScope -Name 'it''s' -EnableRecursion $true -Forwarder '10.0.0.1', 10.0.0.2\nSet-DnsServerCache -LockingPercent 90\n"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	expected := []string{
+		"Get-DnsServerResourceRecord -ZoneName '..Cache' -Name 'www.test.local' -ErrorAction 'SilentlyContinue' | Remove-DnsServerResourceRecord -ZoneName '..Cache' -Force:$true",
+		"Add-DnsServerRecursionScope -Name 'it''s' -EnableRecursion:$true -Forwarder '10.0.0.1','10.0.0.2'",
+		"Set-DnsServerCache -LockingPercent 90",
+	}
+	if scripts := server.Scripts()[before:]; strings.Join(scripts, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("Expected commands %q, got %q", expected, scripts)
+	}
+
+	before = len(server.Scripts())
+	for _, script := range []string{"$s = Get-DnsServerSetting -All", "Get-DnsServerZone | ?{ $_.IsDsIntegrated }", "Set-DnsServerCache -MaxTTL (New-TimeSpan -Seconds 60)"} {
+		if _, err := client.ExecutePowerShellScript(script); err == nil || !strings.Contains(err.Error(), "Script cannot run on configuration DnsOperators") {
+			t.Fatalf("Expected %q to be refused, got %v", script, err)
+		}
+	}
+	if _, err := client.UpdateServerSettings(ServerSettings{}); err == nil || !strings.Contains(err.Error(), "cannot be changed on configuration DnsOperators") {
+		t.Fatalf("Expected server settings error, got %v", err)
+	}
+	if scripts := server.Scripts()[before:]; len(scripts) != 0 {
+		t.Fatalf("Expected no scripts to be sent, got %q", scripts)
+	}
+
+	c.Backend = "powershell"
+	if _, err := testConfigure(c); err == nil || !strings.Contains(err.Error(), "requires the psrp backend") {
+		t.Fatalf("Expected backend error, got %v", err)
+	}
+
+	// a missing endpoint is an error rather than a reason to fall back to
+	// powershell.exe, which would not be restricted
+	c.Backend = "auto"
+	c.ConfigurationName = "Missing"
+	client, err = testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer client.Close()
+	if _, err := client.ExecutePowerShellScript("Get-DnsServerZone"); err == nil || !strings.Contains(err.Error(), "runspace pool") {
+		t.Fatalf("Expected runspace pool error, got %v", err)
+	}
+}
+
+func TestClientConfigurationName_Records(t *testing.T) {
+	const recordTemplate = `<Obj RefId="0"><TN RefId="0"><T>Microsoft.Management.Infrastructure.CimInstance#root/Microsoft/Windows/DNS/DnsServerResourceRecord</T></TN>` +
+		`<Props><S N="HostName">%s</S><S N="RecordType">A</S><Obj N="RecordData" RefId="1"><Props><Obj N="IPv4Address" RefId="2"><ToString>%s</ToString></Obj></Props></Obj>` +
+		`<TS N="TimeToLive">PT%dS</TS></Props></Obj>`
+	const mx = `<Obj RefId="0"><Props><S N="HostName">test99</S><S N="RecordType">MX</S><Obj N="RecordData" RefId="1"><Props><S N="MailExchange">mail.test.local.</S></Props></Obj><TS N="TimeToLive">PT1H</TS></Props></Obj>`
+	type record struct {
+		value string
+		ttl   int
+	}
+	var mu sync.Mutex
+	records := map[string][]record{}
+	get := regexp.MustCompile(`^Get-DnsServerResourceRecord(?: -ComputerName '\w+')? -ZoneName 'test.local'(?: -Name '(\w+)')?(?: -ErrorAction 'SilentlyContinue')?$`)
+	add := regexp.MustCompile(`^Add-DnsServerResourceRecord -ZoneName 'test.local' -Name '(\w+)' -A:\$true -IPv4Address '([\d.]+)' -TimeToLive '0\.(\d\d):(\d\d):(\d\d)'$`)
+	remove := regexp.MustCompile(`^Remove-DnsServerResourceRecord -ZoneName 'test.local' -Name '(\w+)' -RRType 'A' -RecordData '([\d.]+)' -Force:\$true$`)
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if m := get.FindStringSubmatch(script); m != nil {
+			objects := []string{mx}
+			for name, rs := range records {
+				if m[1] == "" || m[1] == name {
+					for _, r := range rs {
+						objects = append(objects, fmt.Sprintf(recordTemplate, name, r.value, r.ttl))
+					}
+				}
+			}
+			return strings.Join(objects, "\n"), ""
+		}
+		if m := add.FindStringSubmatch(script); m != nil {
+			h, _ := strconv.Atoi(m[3])
+			min, _ := strconv.Atoi(m[4])
+			s, _ := strconv.Atoi(m[5])
+			records[m[1]] = append(records[m[1]], record{m[2], h*3600 + min*60 + s})
+			return "", ""
+		}
+		if m := remove.FindStringSubmatch(script); m != nil {
+			var kept []record
+			for _, r := range records[m[1]] {
+				if r.value != m[2] {
+					kept = append(kept, r)
+				}
+			}
+			records[m[1]] = kept
+			return "", ""
+		}
+		t.Errorf("Unexpected command: %s", script)
+		return "", ""
+	})
+	server.configuration = "http://schemas.microsoft.com/powershell/DnsOperators"
+	defer server.Close()
+
+	c := server.config()
+	c.ConfigurationName = "DnsOperators"
+	c.ReplicationServers = []string{"dc2"}
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer client.Close()
+	client.ReplicationInterval = 10 * time.Millisecond
+
+	created, err := client.CreateRecord(Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99", TTL: 600})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(created) != 1 || created[0].ID != "test.local|test99|10.0.0.99" || created[0].TTL != 600 {
+		t.Fatalf("Expected the created record, got %v", created)
+	}
+
+	updated, err := client.UpdateRecord(created[0], "10.0.0.100", 300)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if updated.Value != "10.0.0.100" || updated.TTL != 300 {
+		t.Fatalf("Expected the updated record, got %v", updated)
+	}
+	found, err := client.ReadRecords(Record{Dnszone: "test.local", Name: "test99"})
+	if err != nil || len(found) != 1 || found[0].ID != "test.local|test99|10.0.0.100" {
+		t.Fatalf("Expected one record, got %v %v", found, err)
+	}
+
+	if err := client.DeleteRecord(updated); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if client.RecordExist(updated) {
+		t.Fatal("Expected the record to be removed")
+	}
+}
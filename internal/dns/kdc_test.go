@@ -0,0 +1,178 @@
+package dns
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+// testKDC is a Kerberos KDC stand-in, it issues tickets over TCP for any
+// principal in its keytab without requiring pre-authentication
+type testKDC struct {
+	t        *testing.T
+	realm    string
+	keytab   *keytab.Keytab
+	listener net.Listener
+}
+
+func newTestKDC(t *testing.T, realm string, kt *keytab.Keytab) *testKDC {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting KDC: %s", err)
+	}
+
+	kdc := &testKDC{t: t, realm: realm, keytab: kt, listener: l}
+	go kdc.serve()
+	return kdc
+}
+
+func (k *testKDC) Addr() string {
+	return k.listener.Addr().String()
+}
+
+func (k *testKDC) Close() {
+	k.listener.Close()
+}
+
+func (k *testKDC) serve() {
+	for {
+		conn, err := k.listener.Accept()
+		if err != nil {
+			return
+		}
+		go k.handle(conn)
+	}
+}
+
+// handle answers a request framed with its length, RFC 4120 7.2.2
+func (k *testKDC) handle(conn net.Conn) {
+	defer conn.Close()
+
+	l := make([]byte, 4)
+	if _, err := io.ReadFull(conn, l); err != nil {
+		return
+	}
+	req := make([]byte, binary.BigEndian.Uint32(l))
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+
+	var rep []byte
+	var err error
+	switch req[0] {
+	case 0x6a:
+		rep, err = k.asRep(req)
+	case 0x6c:
+		rep, err = k.tgsRep(req)
+	}
+	if err != nil {
+		k.t.Errorf("KDC error: %s", err)
+		return
+	}
+
+	binary.BigEndian.PutUint32(l, uint32(len(rep)))
+	conn.Write(append(l, rep...))
+}
+
+// asRep issues a TGT encrypted with the key of the client
+func (k *testKDC) asRep(b []byte) ([]byte, error) {
+	var req messages.ASReq
+	if err := req.Unmarshal(b); err != nil {
+		return nil, err
+	}
+
+	clientKey, _, err := k.keytab.GetEncryptionKey(req.ReqBody.CName, k.realm, 0, etypeID.AES256_CTS_HMAC_SHA1_96)
+	if err != nil {
+		return nil, err
+	}
+	tkt, encPart, err := k.issue(req.ReqBody.CName, req.ReqBody.SName, req.ReqBody.Nonce, clientKey, keyusage.AS_REP_ENCPART)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := messages.ASRep{
+		KDCRepFields: messages.KDCRepFields{
+			PVNO:    5,
+			MsgType: msgtype.KRB_AS_REP,
+			CRealm:  k.realm,
+			CName:   req.ReqBody.CName,
+			Ticket:  tkt,
+			EncPart: encPart,
+		},
+	}
+	return rep.Marshal()
+}
+
+// tgsRep issues a service ticket encrypted with the session key of the TGT
+func (k *testKDC) tgsRep(b []byte) ([]byte, error) {
+	var req messages.TGSReq
+	if err := req.Unmarshal(b); err != nil {
+		return nil, err
+	}
+	var apReq messages.APReq
+	if err := apReq.Unmarshal(req.PAData[0].PADataValue); err != nil {
+		return nil, err
+	}
+	if err := apReq.Ticket.DecryptEncPart(k.keytab, nil); err != nil {
+		return nil, err
+	}
+
+	cname := apReq.Ticket.DecryptedEncPart.CName
+	tkt, encPart, err := k.issue(cname, req.ReqBody.SName, req.ReqBody.Nonce, apReq.Ticket.DecryptedEncPart.Key, keyusage.TGS_REP_ENCPART_SESSION_KEY)
+	if err != nil {
+		return nil, err
+	}
+
+	rep := messages.TGSRep{
+		KDCRepFields: messages.KDCRepFields{
+			PVNO:    5,
+			MsgType: msgtype.KRB_TGS_REP,
+			CRealm:  k.realm,
+			CName:   cname,
+			Ticket:  tkt,
+			EncPart: encPart,
+		},
+	}
+	return rep.Marshal()
+}
+
+// issue creates a ticket for sname and the encrypted part of the reply
+// holding its session key
+func (k *testKDC) issue(cname, sname types.PrincipalName, nonce int, key types.EncryptionKey, usage uint32) (messages.Ticket, types.EncryptedData, error) {
+	now := time.Now().UTC().Truncate(time.Second)
+	end := now.Add(time.Hour)
+
+	tkt, sessionKey, err := messages.NewTicket(cname, k.realm, sname, k.realm, types.NewKrbFlags(), k.keytab, etypeID.AES256_CTS_HMAC_SHA1_96, 1, now, now, end, end)
+	if err != nil {
+		return tkt, types.EncryptedData{}, err
+	}
+
+	part := messages.EncKDCRepPart{
+		Key:       sessionKey,
+		LastReqs:  []messages.LastReq{{LRValue: now}},
+		Nonce:     nonce,
+		Flags:     types.NewKrbFlags(),
+		AuthTime:  now,
+		StartTime: now,
+		EndTime:   end,
+		RenewTill: end,
+		SRealm:    k.realm,
+		SName:     sname,
+	}
+	b, err := part.Marshal()
+	if err != nil {
+		return tkt, types.EncryptedData{}, err
+	}
+	encPart, err := crypto.GetEncryptedData(b, key, usage, 1)
+	return tkt, encPart, err
+}
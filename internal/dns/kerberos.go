@@ -0,0 +1,296 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/jcmturner/gokrb5/v8/config"
+	"github.com/jcmturner/gokrb5/v8/credentials"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/chksumtype"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/flags"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+const defaultKrb5Conf = "/etc/krb5.conf"
+
+// GSS-API wrap token flags, see RFC 4121 4.2.2
+const (
+	wrapFlagSentByAcceptor byte = 0x01
+	wrapFlagSealed         byte = 0x02
+	wrapFlagAcceptorSubkey byte = 0x04
+)
+
+// kerberosClient creates the Kerberos client used to get service tickets,
+// credentials are taken from the credential cache, the keytab or the
+// password in that order
+func (c *Client) kerberosClient() (*client.Client, error) {
+	path := c.Krb5Conf
+	if path == "" {
+		path = defaultKrb5Conf
+	}
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Kerberos configuration %s: %v", path, err)
+	}
+
+	if c.CCache != "" {
+		ccache, err := credentials.LoadCCache(strings.TrimPrefix(c.CCache, "FILE:"))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Kerberos credential cache: %v", err)
+		}
+		krb, err := client.NewFromCCache(ccache, cfg, client.DisablePAFXFAST(true))
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Kerberos credential cache: %v", err)
+		}
+		return krb, nil
+	}
+
+	username, realm := splitKerberosUser(c.Username)
+	if c.Realm != "" {
+		realm = c.Realm
+	}
+	if realm == "" {
+		realm = cfg.LibDefaults.DefaultRealm
+	}
+	if realm == "" {
+		return nil, errors.New("Kerberos realm is not set and there is no default realm")
+	}
+
+	if c.Keytab != "" {
+		kt, err := keytab.Load(c.Keytab)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading Kerberos keytab: %v", err)
+		}
+		return client.NewWithKeytab(username, realm, kt, cfg, client.DisablePAFXFAST(true)), nil
+	}
+
+	if c.Password == "" {
+		return nil, errors.New("Kerberos authentication requires a password, keytab or credential cache")
+	}
+	return client.NewWithPassword(username, realm, c.Password, cfg, client.DisablePAFXFAST(true)), nil
+}
+
+// splitKerberosUser splits user@realm into the user and realm, realms are
+// upper case by convention
+func splitKerberosUser(username string) (string, string) {
+	if i := strings.LastIndex(username, "@"); i >= 0 {
+		return username[:i], strings.ToUpper(username[i+1:])
+	}
+	return username, ""
+}
+
+// kerberosSession holds the keys of a security context established with
+// the server
+type kerberosSession struct {
+	sessionKey types.EncryptionKey
+	// key wraps messages, it is replaced by the acceptor subkey when the
+	// server sends one
+	key            types.EncryptionKey
+	acceptorSubkey bool
+	sequence       uint64
+	ctime          time.Time
+	cusec          int
+}
+
+// kerberosInitiate creates the SPNEGO token sent to the server, it holds an
+// AP-REQ for the service ticket requesting mutual authentication and a
+// subkey used for message encryption
+func kerberosInitiate(krb *client.Client, tkt messages.Ticket, sessionKey types.EncryptionKey) ([]byte, *kerberosSession, error) {
+	et, err := crypto.GetEtype(sessionKey.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	auth, err := types.NewAuthenticator(krb.Credentials.Domain(), krb.Credentials.CName())
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating authenticator: %v", err)
+	}
+	if err := auth.GenerateSeqNumberAndSubKey(sessionKey.KeyType, et.GetKeyByteSize()); err != nil {
+		return nil, nil, fmt.Errorf("Error creating subkey: %v", err)
+	}
+	auth.Cksum = types.Checksum{
+		CksumType: chksumtype.GSSAPI,
+		Checksum:  gssChecksum(gssapi.ContextFlagMutual | gssapi.ContextFlagSequence | gssapi.ContextFlagConf | gssapi.ContextFlagInteg),
+	}
+
+	apReq, err := messages.NewAPReq(tkt, sessionKey, auth)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating AP-REQ: %v", err)
+	}
+	types.SetFlag(&apReq.APOptions, flags.APOptionMutualRequired)
+	b, err := apReq.Marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error marshalling AP-REQ: %v", err)
+	}
+
+	// wrap the AP-REQ in a GSS-API InitialContextToken, RFC 4121 4.1
+	oid, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+	mech := append(oid, 0x01, 0x00)
+	mech = asn1tools.AddASNAppTag(append(mech, b...), 0)
+
+	token := spnego.SPNEGOToken{
+		Init: true,
+		NegTokenInit: spnego.NegTokenInit{
+			MechTypes:      []asn1.ObjectIdentifier{gssapi.OIDKRB5.OID()},
+			MechTokenBytes: mech,
+		},
+	}
+	tb, err := token.Marshal()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error marshalling SPNEGO token: %v", err)
+	}
+
+	session := &kerberosSession{
+		sessionKey: sessionKey,
+		key:        auth.SubKey,
+		sequence:   uint64(auth.SeqNumber),
+		ctime:      auth.CTime.Truncate(time.Second),
+		cusec:      auth.Cusec,
+	}
+	return tb, session, nil
+}
+
+// gssChecksum creates the authenticator checksum carrying the context
+// flags, RFC 4121 4.1.1
+func gssChecksum(flags int) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[0:4], 16)
+	binary.LittleEndian.PutUint32(b[20:24], uint32(flags))
+	return b
+}
+
+// acceptReply verifies the AP-REP returned by the server authenticates
+// it, and keeps the acceptor subkey when there is one
+func (s *kerberosSession) acceptReply(token []byte) error {
+	var resp spnego.SPNEGOToken
+	if err := resp.Unmarshal(token); err != nil {
+		return fmt.Errorf("Invalid SPNEGO response: %v", err)
+	}
+	if !resp.Resp {
+		return errors.New("Expected SPNEGO response token")
+	}
+	if resp.NegTokenResp.State() == spnego.NegStateReject {
+		return errors.New("Server rejected the Kerberos ticket")
+	}
+
+	var mech spnego.KRB5Token
+	if err := mech.Unmarshal(resp.NegTokenResp.ResponseToken); err != nil {
+		return fmt.Errorf("Invalid Kerberos response: %v", err)
+	}
+	if mech.IsKRBError() {
+		return mech.KRBError
+	}
+	if !mech.IsAPRep() {
+		return errors.New("Server did not return an AP-REP")
+	}
+
+	b, err := crypto.DecryptEncPart(mech.APRep.EncPart, s.sessionKey, keyusage.AP_REP_ENCPART)
+	if err != nil {
+		return fmt.Errorf("Error decrypting AP-REP: %v", err)
+	}
+	var part messages.EncAPRepPart
+	if err := part.Unmarshal(b); err != nil {
+		return fmt.Errorf("Invalid AP-REP: %v", err)
+	}
+	if !part.CTime.Equal(s.ctime) || part.Cusec != s.cusec {
+		return errors.New("Server failed mutual authentication")
+	}
+
+	if len(part.Subkey.KeyValue) > 0 {
+		s.key = part.Subkey
+		s.acceptorSubkey = true
+	}
+	return nil
+}
+
+// seal encrypts message in a GSS-API wrap token, the token is rotated so the
+// signature holds the token header, the encrypted header copy, the checksum
+// and the confounder leaving the data the same length as message, RFC 4121
+// 4.2.4 and MS-KILE 3.4.5.4
+func (s *kerberosSession) seal(message []byte) ([]byte, []byte, error) {
+	et, err := crypto.GetEtype(s.key.KeyType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s.key.KeyType == etypeID.RC4_HMAC {
+		return nil, nil, errors.New("Message encryption requires an AES session key")
+	}
+
+	header := make([]byte, 16)
+	header[0], header[1] = 0x05, 0x04
+	header[2] = wrapFlagSealed
+	if s.acceptorSubkey {
+		header[2] |= wrapFlagAcceptorSubkey
+	}
+	header[3] = 0xff
+	binary.BigEndian.PutUint64(header[8:16], s.sequence)
+	s.sequence++
+
+	plain := make([]byte, 0, len(message)+len(header))
+	plain = append(plain, message...)
+	plain = append(plain, header...)
+	ed, err := crypto.GetEncryptedData(plain, s.key, keyusage.GSSAPI_INITIATOR_SEAL, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rrc := len(header) + et.GetHMACBitLength()/8
+	binary.BigEndian.PutUint16(header[6:8], uint16(rrc))
+	cipher := ed.Cipher
+	rotated := append(append([]byte{}, cipher[len(cipher)-rrc:]...), cipher[:len(cipher)-rrc]...)
+
+	split := len(rotated) - len(message)
+	return append(header, rotated[:split]...), rotated[split:], nil
+}
+
+// unseal decrypts a wrap token returned by the server
+func (s *kerberosSession) unseal(signature, data []byte) ([]byte, error) {
+	token := append(append([]byte{}, signature...), data...)
+	if len(token) < 16 || token[0] != 0x05 || token[1] != 0x04 {
+		return nil, errors.New("Invalid wrap token")
+	}
+	if token[2]&wrapFlagSentByAcceptor == 0 || token[2]&wrapFlagSealed == 0 {
+		return nil, errors.New("Wrap token is not sealed by the server")
+	}
+
+	ec := int(binary.BigEndian.Uint16(token[4:6]))
+	cipher := token[16:]
+	if len(cipher) == 0 {
+		return nil, errors.New("Wrap token is empty")
+	}
+	rrc := int(binary.BigEndian.Uint16(token[6:8])) % len(cipher)
+	cipher = append(append([]byte{}, cipher[rrc:]...), cipher[:rrc]...)
+
+	plain, err := crypto.DecryptMessage(cipher, s.key, keyusage.GSSAPI_ACCEPTOR_SEAL)
+	if err != nil {
+		return nil, err
+	}
+	if len(plain) < ec+16 {
+		return nil, errors.New("Wrap token is too short")
+	}
+
+	return plain[:len(plain)-ec-16], nil
+}
+
+// kerberosError is returned when the server rejects the ticket
+type kerberosError struct {
+	status int
+	scheme string
+}
+
+func (e kerberosError) Error() string {
+	return fmt.Sprintf("Kerberos authentication failed: http error %d, server offered %q", e.status, e.scheme)
+}
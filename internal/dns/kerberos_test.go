@@ -0,0 +1,301 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jcmturner/gofork/encoding/asn1"
+	"github.com/jcmturner/gokrb5/v8/asn1tools"
+	"github.com/jcmturner/gokrb5/v8/crypto"
+	"github.com/jcmturner/gokrb5/v8/gssapi"
+	"github.com/jcmturner/gokrb5/v8/iana/asnAppTag"
+	"github.com/jcmturner/gokrb5/v8/iana/etypeID"
+	"github.com/jcmturner/gokrb5/v8/iana/keyusage"
+	"github.com/jcmturner/gokrb5/v8/iana/msgtype"
+	"github.com/jcmturner/gokrb5/v8/keytab"
+	"github.com/jcmturner/gokrb5/v8/messages"
+	"github.com/jcmturner/gokrb5/v8/spnego"
+	"github.com/jcmturner/gokrb5/v8/types"
+)
+
+func TestClientKerberos(t *testing.T) {
+	kt := keytab.New()
+	for _, principal := range []string{"user", "krbtgt/TEST.LOCAL", "HTTP/127.0.0.1"} {
+		if err := kt.AddEntry(principal, "TEST.LOCAL", "pass", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("Error creating keytab: %s", err)
+		}
+	}
+	kdc := newTestKDC(t, "TEST.LOCAL", kt)
+	defer kdc.Close()
+
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	krb5Conf := filepath.Join(dir, "krb5.conf")
+	if err := ioutil.WriteFile(krb5Conf, []byte(fmt.Sprintf(testKrb5Conf, kdc.Addr())), 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	userKeytab := keytab.New()
+	userKeytab.AddEntry("user", "TEST.LOCAL", "pass", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96)
+	b, _ := userKeytab.Marshal()
+	keytabPath := filepath.Join(dir, "user.keytab")
+	if err := ioutil.WriteFile(keytabPath, b, 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	cases := []struct {
+		name     string
+		https    bool
+		keytab   string
+		password string
+	}{
+		{"keytab over HTTP", false, keytabPath, ""},
+		{"password over HTTPS", true, "", "pass"},
+	}
+
+	for _, tc := range cases {
+		handler := testKerberosHandler(t, kt, "user")
+		server := httptest.NewServer(handler)
+		if tc.https {
+			server = httptest.NewTLSServer(handler)
+		}
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+
+		c := Client{
+			ServerName: u.Hostname(),
+			Port:       port,
+			HTTPS:      tc.https,
+			Insecure:   true,
+			Username:   "user",
+			Password:   tc.password,
+			AuthType:   "kerberos",
+			Krb5Conf:   krb5Conf,
+			Keytab:     tc.keytab,
+		}
+
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+
+		if _, err := client.Client.CreateShell(); err != nil {
+			t.Fatalf("Error authenticating with %s: %s", tc.name, err)
+		}
+		server.Close()
+	}
+}
+
+func TestClientKerberos_KeepsConnection(t *testing.T) {
+	kt := keytab.New()
+	for _, principal := range []string{"user", "krbtgt/TEST.LOCAL", "HTTP/127.0.0.1"} {
+		if err := kt.AddEntry(principal, "TEST.LOCAL", "pass", time.Now(), 1, etypeID.AES256_CTS_HMAC_SHA1_96); err != nil {
+			t.Fatalf("Error creating keytab: %s", err)
+		}
+	}
+	kdc := newTestKDC(t, "TEST.LOCAL", kt)
+	defer kdc.Close()
+
+	dir, err := ioutil.TempDir("", "krb5")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	krb5Conf := filepath.Join(dir, "krb5.conf")
+	if err := ioutil.WriteFile(krb5Conf, []byte(fmt.Sprintf(testKrb5Conf, kdc.Addr())), 0600); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	for _, https := range []bool{false, true} {
+		var handshakes testHandshakes
+		server := handshakes.newServer(testKerberosHandler(t, kt, "user"), https, func(r *http.Request) bool {
+			return r.Header.Get("Authorization") != ""
+		})
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+
+		client, err := testConfigure(Client{
+			ServerName: u.Hostname(),
+			Port:       port,
+			HTTPS:      https,
+			Insecure:   true,
+			Username:   "user",
+			Password:   "pass",
+			AuthType:   "kerberos",
+			Krb5Conf:   krb5Conf,
+		})
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		handshakes.check(t, client, https)
+		client.Close()
+		server.Close()
+	}
+}
+
+func TestClientKerberos_MissingKeytab(t *testing.T) {
+	c := Client{
+		ServerName: "dc.test.local",
+		Username:   "user",
+		AuthType:   "kerberos",
+		Realm:      "TEST.LOCAL",
+		Krb5Conf:   "/dev/null",
+		Keytab:     "does-not-exist.keytab",
+	}
+
+	if _, err := testConfigure(c); err == nil {
+		t.Fatal("Expected error reading missing keytab")
+	}
+}
+
+// testKerberosHandler emulates a WinRM listener using Kerberos, it accepts
+// tickets for the service in kt and requires messages sent over HTTP to be
+// encrypted
+func testKerberosHandler(t *testing.T, kt *keytab.Keytab, user string) http.Handler {
+	var acceptorKey types.EncryptionKey
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authorization := r.Header.Get("Authorization"); authorization != "" {
+			token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Negotiate "))
+			var init spnego.SPNEGOToken
+			var mech spnego.KRB5Token
+			if err := init.Unmarshal(token); err != nil || !init.Init {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if err := mech.Unmarshal(init.NegTokenInit.MechTokenBytes); err != nil || !mech.IsAPReq() {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			apReq := mech.APReq
+			if err := apReq.Ticket.DecryptEncPart(kt, nil); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			sessionKey := apReq.Ticket.DecryptedEncPart.Key
+			if err := apReq.DecryptAuthenticator(sessionKey); err != nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if name := apReq.Authenticator.CName.PrincipalNameString(); name != user {
+				t.Errorf("Expected %s, got %s", user, name)
+			}
+
+			acceptorKey = types.EncryptionKey{KeyType: etypeID.AES256_CTS_HMAC_SHA1_96, KeyValue: make([]byte, 32)}
+			rand.Read(acceptorKey.KeyValue)
+			part, _ := asn1.Marshal(messages.EncAPRepPart{
+				CTime:          apReq.Authenticator.CTime,
+				Cusec:          apReq.Authenticator.Cusec,
+				Subkey:         acceptorKey,
+				SequenceNumber: 1,
+			})
+			encPart, _ := crypto.GetEncryptedData(asn1tools.AddASNAppTag(part, asnAppTag.EncAPRepPart), sessionKey, keyusage.AP_REP_ENCPART, 0)
+			apRep, _ := asn1.Marshal(messages.APRep{PVNO: 5, MsgType: msgtype.KRB_AP_REP, EncPart: encPart})
+			oid, _ := asn1.Marshal(gssapi.OIDKRB5.OID())
+			resp := spnego.SPNEGOToken{
+				Resp: true,
+				NegTokenResp: spnego.NegTokenResp{
+					NegState:      asn1.Enumerated(spnego.NegStateAcceptCompleted),
+					SupportedMech: gssapi.OIDKRB5.OID(),
+					ResponseToken: asn1tools.AddASNAppTag(append(append(oid, 0x02, 0x00), asn1tools.AddASNAppTag(apRep, asnAppTag.APREP)...), 0),
+				},
+			}
+			b, _ := resp.Marshal()
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(b))
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case len(body) == 0:
+			w.WriteHeader(http.StatusOK)
+		case strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/encrypted"):
+			message, err := testKerberosUnseal(acceptorKey, body)
+			if err != nil || !strings.Contains(string(message), "Envelope") {
+				t.Errorf("Error decrypting message: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", `multipart/encrypted;protocol="application/HTTP-SPNEGO-session-encrypted";boundary="Encrypted Boundary"`)
+			w.Write(testKerberosSeal(acceptorKey, []byte(testOpenShellResponse)))
+		default:
+			if r.TLS == nil {
+				t.Error("Message sent unencrypted over HTTP")
+			}
+			w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+			w.Write([]byte(testOpenShellResponse))
+		}
+	})
+}
+
+// testKerberosUnseal decrypts a multipart/encrypted message sealed by the
+// client
+func testKerberosUnseal(key types.EncryptionKey, body []byte) ([]byte, error) {
+	const stream = "\tContent-Type: application/octet-stream\r\n"
+	i := bytes.Index(body, []byte(stream))
+	if i < 0 {
+		return nil, fmt.Errorf("No encrypted stream")
+	}
+	body = bytes.TrimSuffix(body[i+len(stream):], []byte("--Encrypted Boundary--\r\n"))
+	token := body[4:]
+	rrc := int(binary.BigEndian.Uint16(token[6:8]))
+	cipher := append(append([]byte{}, token[16+rrc:]...), token[16:16+rrc]...)
+
+	plain, err := crypto.DecryptMessage(cipher, key, keyusage.GSSAPI_INITIATOR_SEAL)
+	if err != nil {
+		return nil, err
+	}
+	return plain[:len(plain)-16], nil
+}
+
+// testKerberosSeal encrypts message as the server does
+func testKerberosSeal(key types.EncryptionKey, message []byte) []byte {
+	header := []byte{0x05, 0x04, 0x07, 0xff, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}
+	encrypted, _ := crypto.GetEncryptedData(append(append([]byte{}, message...), header...), key, keyusage.GSSAPI_ACCEPTOR_SEAL, 0)
+	cipher := encrypted.Cipher
+	binary.BigEndian.PutUint16(header[6:8], 28)
+	token := append(header, cipher[len(cipher)-28:]...)
+	token = append(token, cipher[:len(cipher)-28]...)
+	signature := len(token) - len(message)
+
+	b := bytes.Buffer{}
+	b.WriteString("--Encrypted Boundary\r\n")
+	b.WriteString("\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n")
+	b.WriteString(fmt.Sprintf("\tOriginalContent: type=application/soap+xml;charset=UTF-8;Length=%d\r\n", len(message)))
+	b.WriteString("--Encrypted Boundary\r\n")
+	b.WriteString("\tContent-Type: application/octet-stream\r\n")
+	binary.Write(&b, binary.LittleEndian, uint32(signature))
+	b.Write(token)
+	b.WriteString("--Encrypted Boundary--\r\n")
+	return b.Bytes()
+}
+
+const testKrb5Conf = `[libdefaults]
+  default_realm = TEST.LOCAL
+  dns_lookup_kdc = false
+  udp_preference_limit = 1
+  default_tkt_enctypes = aes256-cts-hmac-sha1-96
+  default_tgs_enctypes = aes256-cts-hmac-sha1-96
+  permitted_enctypes = aes256-cts-hmac-sha1-96
+
+[realms]
+  TEST.LOCAL = {
+    kdc = %s
+  }
+`
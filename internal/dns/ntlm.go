@@ -0,0 +1,252 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	ntlmssp "github.com/Azure/go-ntlmssp"
+	"golang.org/x/crypto/md4"
+)
+
+// NTLM negotiate flags, see MS-NLMP 2.2.2.5. go-ntlmssp does not ask for
+// the flags needed to seal messages and does not support key exchange
+const (
+	ntlmNegotiateUnicode            uint32 = 0x00000001
+	ntlmNegotiateSign               uint32 = 0x00000010
+	ntlmNegotiateSeal               uint32 = 0x00000020
+	ntlmNegotiateExtendedSessionSec uint32 = 0x00080000
+	ntlmNegotiate128                uint32 = 0x20000000
+	ntlmNegotiateKeyExch            uint32 = 0x40000000
+	ntlmNegotiate56                 uint32 = 0x80000000
+	ntlmSealingFlags                       = ntlmNegotiateSign | ntlmNegotiateSeal | ntlmNegotiateExtendedSessionSec | ntlmNegotiate128 | ntlmNegotiate56
+)
+
+// ntlmSession holds the keys used to seal messages, see MS-NLMP 3.4
+type ntlmSession struct {
+	flags              uint32
+	exportedSessionKey []byte
+	clientSigningKey   []byte
+	serverSigningKey   []byte
+	clientSealing      *rc4.Cipher
+	serverSealing      *rc4.Cipher
+	clientSequence     uint32
+	serverSequence     uint32
+}
+
+// splitNTLMUser splits DOMAIN\user into the user and its domain,
+// user@domain is returned as is without a domain so the server resolves the
+// UPN
+func splitNTLMUser(username string) (string, string) {
+	if i := strings.Index(username, `\`); i >= 0 {
+		return username[i+1:], username[:i]
+	}
+	return username, ""
+}
+
+// ntlmChallengeTarget returns challenge naming domain as its target, as
+// go-ntlmssp calculates the response for the domain the server names, which
+// is not the domain of a user from a trusted domain
+func ntlmChallengeTarget(challenge []byte, domain string) ([]byte, error) {
+	if len(challenge) < 48 {
+		return nil, errors.New("Invalid NTLM challenge message")
+	}
+	target := []byte(domain)
+	if binary.LittleEndian.Uint32(challenge[20:24])&ntlmNegotiateUnicode != 0 {
+		target = toUTF16(domain)
+	}
+	replaced := append(append([]byte{}, challenge...), target...)
+	binary.LittleEndian.PutUint16(replaced[12:], uint16(len(target)))
+	binary.LittleEndian.PutUint16(replaced[14:], uint16(len(target)))
+	binary.LittleEndian.PutUint32(replaced[16:], uint32(len(challenge)))
+	return replaced, nil
+}
+
+// ntlmNegotiateMessage returns the NEGOTIATE message of go-ntlmssp, asking
+// for the flags needed to seal messages when seal is set
+func ntlmNegotiateMessage(seal bool) []byte {
+	message := ntlmssp.NewNegotiateMessage()
+	if seal {
+		flags := binary.LittleEndian.Uint32(message[12:16])
+		binary.LittleEndian.PutUint32(message[12:16], flags|ntlmSealingFlags)
+	}
+	return message
+}
+
+// ntlmAuthenticate answers the server's challenge with the AUTHENTICATE
+// message of go-ntlmssp and returns the session established by it, the
+// user is authenticated in its own domain when one is given
+func ntlmAuthenticate(challenge []byte, username, password string) ([]byte, ntlmSession, error) {
+	user, domain := splitNTLMUser(username)
+	if domain != "" {
+		var err error
+		if challenge, err = ntlmChallengeTarget(challenge, domain); err != nil {
+			return nil, ntlmSession{}, err
+		}
+	}
+	auth, err := ntlmssp.ProcessChallenge(challenge, user, password)
+	if err != nil {
+		return nil, ntlmSession{}, fmt.Errorf("Error answering NTLM challenge: %v", err)
+	}
+	session, err := newNTLMSession(auth, password)
+	if err != nil {
+		return nil, ntlmSession{}, err
+	}
+	return auth, session, nil
+}
+
+// newNTLMSession derives the keys sealing messages from an AUTHENTICATE
+// message, without key exchange the exported session key is the session
+// base key, MS-NLMP 3.3.2 and 3.4.5
+func newNTLMSession(auth []byte, password string) (ntlmSession, error) {
+	field := func(offset int) ([]byte, error) {
+		l := int(binary.LittleEndian.Uint16(auth[offset:]))
+		start := int(binary.LittleEndian.Uint32(auth[offset+4:]))
+		if start+l > len(auth) {
+			return nil, errors.New("Invalid NTLM authenticate message")
+		}
+		return auth[start : start+l], nil
+	}
+	if len(auth) < 64 {
+		return ntlmSession{}, errors.New("Invalid NTLM authenticate message")
+	}
+	ntResponse, err := field(20)
+	if err != nil {
+		return ntlmSession{}, err
+	}
+	domain, err := field(28)
+	if err != nil {
+		return ntlmSession{}, err
+	}
+	user, err := field(36)
+	if err != nil {
+		return ntlmSession{}, err
+	}
+	if len(ntResponse) < 16 {
+		return ntlmSession{}, errors.New("Invalid NTLM response")
+	}
+
+	ntowf := ntowfv2(password, fromUTF16(user), fromUTF16(domain))
+	session := ntlmSession{
+		flags:              binary.LittleEndian.Uint32(auth[60:64]),
+		exportedSessionKey: hmacMD5(ntowf, ntResponse[:16]),
+	}
+	session.deriveKeys()
+	return session, nil
+}
+
+// deriveKeys derives the signing and sealing keys for both directions from
+// the exported session key, MS-NLMP 3.4.5.2 and 3.4.5.3
+func (s *ntlmSession) deriveKeys() {
+	s.clientSigningKey = ntlmDeriveKey(s.exportedSessionKey, "session key to client-to-server signing key magic constant\x00")
+	s.serverSigningKey = ntlmDeriveKey(s.exportedSessionKey, "session key to server-to-client signing key magic constant\x00")
+
+	sealKey := s.exportedSessionKey
+	switch {
+	case s.flags&ntlmNegotiate128 != 0:
+	case s.flags&ntlmNegotiate56 != 0:
+		sealKey = sealKey[:7]
+	default:
+		sealKey = sealKey[:5]
+	}
+	s.clientSealing, _ = rc4.NewCipher(ntlmDeriveKey(sealKey, "session key to client-to-server sealing key magic constant\x00"))
+	s.serverSealing, _ = rc4.NewCipher(ntlmDeriveKey(sealKey, "session key to server-to-client sealing key magic constant\x00"))
+}
+
+func ntlmDeriveKey(key []byte, magic string) []byte {
+	hash := md5.New()
+	hash.Write(key)
+	hash.Write([]byte(magic))
+	return hash.Sum(nil)
+}
+
+// seal encrypts message and returns it with its signature, MS-NLMP 3.4.3
+func (s *ntlmSession) seal(message []byte) ([]byte, []byte, error) {
+	if s.flags&ntlmNegotiateSeal == 0 || s.flags&ntlmNegotiateExtendedSessionSec == 0 {
+		return nil, nil, errors.New("Server does not support NTLM message encryption")
+	}
+
+	sealed := make([]byte, len(message))
+	s.clientSealing.XORKeyStream(sealed, message)
+	signature := ntlmMessageSignature(s.clientSealing, s.clientSigningKey, s.clientSequence, message, s.flags)
+	s.clientSequence++
+
+	return signature, sealed, nil
+}
+
+// unseal decrypts a message from the server and verifies its signature
+func (s *ntlmSession) unseal(signature, data []byte) ([]byte, error) {
+	message := make([]byte, len(data))
+	s.serverSealing.XORKeyStream(message, data)
+	expected := ntlmMessageSignature(s.serverSealing, s.serverSigningKey, s.serverSequence, message, s.flags)
+	s.serverSequence++
+
+	if !hmac.Equal(signature, expected) {
+		return nil, errors.New("Invalid NTLM message signature")
+	}
+	return message, nil
+}
+
+// ntlmMessageSignature creates the signature of message using extended
+// session security, MS-NLMP 3.4.4.2
+func ntlmMessageSignature(sealing *rc4.Cipher, signingKey []byte, sequence uint32, message []byte, flags uint32) []byte {
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, sequence)
+	checksum := hmacMD5(signingKey, seq, message)[:8]
+	if flags&ntlmNegotiateKeyExch != 0 {
+		sealing.XORKeyStream(checksum, checksum)
+	}
+
+	signature := []byte{1, 0, 0, 0}
+	signature = append(signature, checksum...)
+	return append(signature, seq...)
+}
+
+// ntowfv2 computes the NTLMv2 one way function of the password
+func ntowfv2(password, user, domain string) []byte {
+	hash := md4.New()
+	hash.Write(toUTF16(password))
+	return hmacMD5(hash.Sum(nil), toUTF16(strings.ToUpper(user)+domain))
+}
+
+func hmacMD5(key []byte, data ...[]byte) []byte {
+	mac := hmac.New(md5.New, key)
+	for _, d := range data {
+		mac.Write(d)
+	}
+	return mac.Sum(nil)
+}
+
+// toUTF16 encodes s as UTF-16LE
+func toUTF16(s string) []byte {
+	encoded := utf16.Encode([]rune(s))
+	b := make([]byte, len(encoded)*2)
+	for i, v := range encoded {
+		binary.LittleEndian.PutUint16(b[i*2:], v)
+	}
+	return b
+}
+
+// fromUTF16 decodes UTF-16LE b
+func fromUTF16(b []byte) string {
+	decoded := make([]uint16, len(b)/2)
+	for i := range decoded {
+		decoded[i] = binary.LittleEndian.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(decoded))
+}
+
+// ntlmError is returned when the server rejects the credentials
+type ntlmError struct {
+	status int
+	scheme string
+}
+
+func (e ntlmError) Error() string {
+	return fmt.Sprintf("NTLM authentication failed: http error %d, server offered %q", e.status, e.scheme)
+}
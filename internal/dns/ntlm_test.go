@@ -0,0 +1,361 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rc4"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/md4"
+)
+
+func TestClientNTLM(t *testing.T) {
+	cases := []struct {
+		username string
+		user     string
+		domain   string
+		https    bool
+	}{
+		{`TEST\user`, "user", "TEST", false},
+		{"user@test.local", "user@test.local", "TEST", false},
+		{`TEST\user`, "user", "TEST", true},
+		// a user of a trusted domain
+		{`PARTNER\user`, "user", "PARTNER", false},
+	}
+
+	for _, tc := range cases {
+		handler := testNTLMHandler(t, tc.user, tc.domain, "TEST", "pass")
+		server := httptest.NewServer(handler)
+		if tc.https {
+			server = httptest.NewTLSServer(handler)
+		}
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+
+		c := Client{
+			ServerName: u.Hostname(),
+			Port:       port,
+			HTTPS:      tc.https,
+			Insecure:   true,
+			Username:   tc.username,
+			Password:   "pass",
+			AuthType:   "ntlm",
+		}
+
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+
+		if _, err := client.Client.CreateShell(); err != nil {
+			t.Fatalf("Error authenticating as %s: %s", tc.username, err)
+		}
+		server.Close()
+	}
+}
+
+func TestClientNTLM_KeepsConnection(t *testing.T) {
+	for _, https := range []bool{false, true} {
+		var handshakes testHandshakes
+		server := handshakes.newServer(testNTLMHandler(t, "user", "TEST", "TEST", "pass"), https, func(r *http.Request) bool {
+			token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+			return len(token) >= 12 && binary.LittleEndian.Uint32(token[8:12]) == 1
+		})
+		u, _ := url.Parse(server.URL)
+		port, _ := strconv.Atoi(u.Port())
+
+		client, err := testConfigure(Client{
+			ServerName: u.Hostname(),
+			Port:       port,
+			HTTPS:      https,
+			Insecure:   true,
+			Username:   `TEST\user`,
+			Password:   "pass",
+			AuthType:   "ntlm",
+		})
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		handshakes.check(t, client, https)
+		client.Close()
+		server.Close()
+	}
+}
+
+// testHandshakes counts the handshakes and connections of a WinRM listener
+// stand-in, after drop the next request not authenticating is refused as
+// the server does once it lost the session of the connection
+type testHandshakes struct {
+	mu          sync.Mutex
+	handshakes  int
+	connections int
+	dropped     bool
+}
+
+// newServer starts a server running handler, start returns if a request
+// starts a handshake
+func (h *testHandshakes) newServer(handler http.Handler, https bool, start func(r *http.Request) bool) *httptest.Server {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.mu.Lock()
+		if start(r) {
+			h.handshakes++
+		}
+		refuse := h.dropped && r.Header.Get("Authorization") == ""
+		if refuse {
+			h.dropped = false
+		}
+		h.mu.Unlock()
+
+		if refuse {
+			ioutil.ReadAll(r.Body)
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			h.mu.Lock()
+			h.connections++
+			h.mu.Unlock()
+		}
+	}
+	if https {
+		server.StartTLS()
+	} else {
+		server.Start()
+	}
+	return server
+}
+
+func (h *testHandshakes) counts() (int, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.handshakes, h.connections
+}
+
+// check sends requests with client, expecting them to share one
+// authenticated connection until the server loses its session
+func (h *testHandshakes) check(t *testing.T, client *Client, https bool) {
+	for i := 0; i < 3; i++ {
+		if _, err := client.Client.CreateShell(); err != nil {
+			t.Fatalf("https %t: Error: %s", https, err)
+		}
+	}
+	if handshakes, connections := h.counts(); handshakes != 1 || connections != 1 {
+		t.Errorf("https %t: expected 1 handshake on 1 connection, got %d on %d", https, handshakes, connections)
+	}
+
+	h.mu.Lock()
+	h.dropped = true
+	h.mu.Unlock()
+	if _, err := client.Client.CreateShell(); err != nil {
+		t.Fatalf("https %t: Expected to authenticate again, got %s", https, err)
+	}
+	if handshakes, _ := h.counts(); handshakes != 2 {
+		t.Errorf("https %t: expected 2 handshakes after the session was lost, got %d", https, handshakes)
+	}
+}
+
+// testNTLMHandler emulates a WinRM listener using NTLM, it verifies the
+// NTLMv2 response was calculated for the expected user and domain and
+// requires messages sent over HTTP to be sealed. The challenge names target,
+// the domain of the server, and accepts the flags the client asks for like a
+// Windows server, and connections stay authenticated once the handshake
+// completed
+func testNTLMHandler(t *testing.T, user, domain, target, password string) http.Handler {
+	serverChallenge := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	var session testNTLMSession
+	authenticated := map[string]bool{}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/encrypted") {
+			message, err := session.unseal(body)
+			if err != nil || !strings.Contains(string(message), "Envelope") {
+				t.Errorf("Error decrypting message: %v", err)
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Header().Set("Content-Type", `multipart/encrypted;protocol="application/HTTP-SPNEGO-session-encrypted";boundary="Encrypted Boundary"`)
+			w.Write(session.seal([]byte(testOpenShellResponse)))
+			return
+		}
+
+		token, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(r.Header.Get("Authorization"), "Negotiate "))
+		if len(token) < 12 && authenticated[r.RemoteAddr] && r.TLS != nil {
+			w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+			w.Write([]byte(testOpenShellResponse))
+			return
+		}
+		if len(token) < 12 {
+			w.Header().Set("WWW-Authenticate", "Negotiate")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch binary.LittleEndian.Uint32(token[8:12]) {
+		case 1:
+			const targetInfo = 0x00800000
+			flags := binary.LittleEndian.Uint32(token[12:16])&0xe2088235 | targetInfo
+			targetName := testToUTF16(target)
+			challenge := bytes.Buffer{}
+			challenge.WriteString("NTLMSSP\x00")
+			binary.Write(&challenge, binary.LittleEndian, []uint32{2, uint32(len(targetName))<<16 | uint32(len(targetName)), 52, flags})
+			challenge.Write(serverChallenge)
+			challenge.Write(make([]byte, 8))
+			binary.Write(&challenge, binary.LittleEndian, []uint32{0x00040004, 48})
+			challenge.Write([]byte{0, 0, 0, 0})
+			challenge.Write(targetName)
+			w.Header().Set("WWW-Authenticate", "Negotiate "+base64.StdEncoding.EncodeToString(challenge.Bytes()))
+			w.WriteHeader(http.StatusUnauthorized)
+		case 3:
+			field := func(offset int) []byte {
+				l := int(binary.LittleEndian.Uint16(token[offset:]))
+				start := int(binary.LittleEndian.Uint32(token[offset+4:]))
+				return token[start : start+l]
+			}
+			nt, gotDomain, gotUser := field(20), testFromUTF16(field(28)), testFromUTF16(field(36))
+			if gotUser != user || gotDomain != domain {
+				t.Errorf("Expected %s and %s, got %s and %s", user, domain, gotUser, gotDomain)
+			}
+
+			hash := md4.New()
+			hash.Write(testToUTF16(password))
+			ntowf := hmac.New(md5.New, hash.Sum(nil))
+			ntowf.Write(testToUTF16(strings.ToUpper(gotUser) + gotDomain))
+			proof := hmac.New(md5.New, ntowf.Sum(nil))
+			proof.Write(serverChallenge)
+			proof.Write(nt[16:])
+			if !bytes.Equal(proof.Sum(nil), nt[:16]) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+
+			sessionBaseKey := hmac.New(md5.New, ntowf.Sum(nil))
+			sessionBaseKey.Write(proof.Sum(nil))
+			session = newTestNTLMSession(sessionBaseKey.Sum(nil), field(52), binary.LittleEndian.Uint32(token[60:64]))
+			authenticated[r.RemoteAddr] = true
+
+			switch {
+			case len(body) == 0:
+				w.WriteHeader(http.StatusOK)
+			case r.TLS == nil:
+				t.Error("Message sent unencrypted over HTTP")
+				w.WriteHeader(http.StatusBadRequest)
+			default:
+				w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+				w.Write([]byte(testOpenShellResponse))
+			}
+		}
+	})
+}
+
+// testNTLMSession holds the server side keys of an NTLM session
+type testNTLMSession struct {
+	clientSigningKey []byte
+	serverSigningKey []byte
+	clientSealing    *rc4.Cipher
+	serverSealing    *rc4.Cipher
+	sequence         uint32
+	keyExch          bool
+}
+
+func newTestNTLMSession(sessionBaseKey, encryptedSessionKey []byte, flags uint32) testNTLMSession {
+	const keyExch = 0x40000000
+	exportedSessionKey := sessionBaseKey
+	if flags&keyExch != 0 {
+		exportedSessionKey = make([]byte, 16)
+		cipher, _ := rc4.NewCipher(sessionBaseKey)
+		cipher.XORKeyStream(exportedSessionKey, encryptedSessionKey)
+	}
+
+	key := func(magic string) []byte {
+		hash := md5.New()
+		hash.Write(exportedSessionKey)
+		hash.Write([]byte(magic + " key magic constant\x00"))
+		return hash.Sum(nil)
+	}
+	clientSealing, _ := rc4.NewCipher(key("session key to client-to-server sealing"))
+	serverSealing, _ := rc4.NewCipher(key("session key to server-to-client sealing"))
+
+	return testNTLMSession{
+		clientSigningKey: key("session key to client-to-server signing"),
+		serverSigningKey: key("session key to server-to-client signing"),
+		clientSealing:    clientSealing,
+		serverSealing:    serverSealing,
+		keyExch:          flags&keyExch != 0,
+	}
+}
+
+// unseal decrypts a multipart/encrypted message sealed by the client and
+// verifies its signature
+func (s *testNTLMSession) unseal(body []byte) ([]byte, error) {
+	const stream = "\tContent-Type: application/octet-stream\r\n"
+	i := bytes.Index(body, []byte(stream))
+	if i < 0 {
+		return nil, fmt.Errorf("No encrypted stream")
+	}
+	body = bytes.TrimSuffix(body[i+len(stream):], []byte("--Encrypted Boundary--\r\n"))
+	signature, sealed := body[4:20], body[20:]
+
+	message := make([]byte, len(sealed))
+	s.clientSealing.XORKeyStream(message, sealed)
+
+	mac := hmac.New(md5.New, s.clientSigningKey)
+	mac.Write(signature[12:16])
+	mac.Write(message)
+	checksum := mac.Sum(nil)[:8]
+	if s.keyExch {
+		s.clientSealing.XORKeyStream(checksum, checksum)
+	}
+	if !bytes.Equal(checksum, signature[4:12]) {
+		return nil, fmt.Errorf("Invalid signature")
+	}
+	return message, nil
+}
+
+// seal encrypts message as the server does
+func (s *testNTLMSession) seal(message []byte) []byte {
+	sealed := make([]byte, len(message))
+	s.serverSealing.XORKeyStream(sealed, message)
+
+	seq := make([]byte, 4)
+	binary.LittleEndian.PutUint32(seq, s.sequence)
+	s.sequence++
+	mac := hmac.New(md5.New, s.serverSigningKey)
+	mac.Write(seq)
+	mac.Write(message)
+	checksum := mac.Sum(nil)[:8]
+	if s.keyExch {
+		s.serverSealing.XORKeyStream(checksum, checksum)
+	}
+
+	b := bytes.Buffer{}
+	b.WriteString("--Encrypted Boundary\r\n")
+	b.WriteString("\tContent-Type: application/HTTP-SPNEGO-session-encrypted\r\n")
+	b.WriteString(fmt.Sprintf("\tOriginalContent: type=application/soap+xml;charset=UTF-8;Length=%d\r\n", len(message)))
+	b.WriteString("--Encrypted Boundary\r\n")
+	b.WriteString("\tContent-Type: application/octet-stream\r\n")
+	binary.Write(&b, binary.LittleEndian, uint32(16))
+	b.Write([]byte{1, 0, 0, 0})
+	b.Write(checksum)
+	b.Write(seq)
+	b.Write(sealed)
+	b.WriteString("--Encrypted Boundary--\r\n")
+	return b.Bytes()
+}
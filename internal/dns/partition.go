@@ -0,0 +1,222 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// DirectoryPartition holds an application directory partition, the servers
+// enlisted in it and the zones replicated through it
+type DirectoryPartition struct {
+	Name    string
+	Replica []string
+	Zones   []string
+}
+
+// partitionZone is used to fill templates changing the replication of a zone
+type partitionZone struct {
+	Partition string
+	Zone      string
+	Server    string
+}
+
+// ReadDirectoryPartition retrieves a directory partition from the DNS server,
+// enlisted servers are returned by their short name
+func (c *Client) ReadDirectoryPartition(name string) (DirectoryPartition, error) {
+	const tmplpscript = `
+$p = Get-DnsServerDirectoryPartition -Name '{{ .Name }}' -ErrorAction SilentlyContinue
+if ($p) {
+	New-Object PSObject -Property @{
+		Name = $p.DirectoryPartitionName
+		Replica = @($p.Replica | %{ ($_ -split ',')[1] -replace '^CN=', '' })
+		Zones = @(Get-DnsServerZone | ?{ $_.DirectoryPartitionName -eq $p.DirectoryPartitionName } | %{ $_.ZoneName })
+	} | ConvertTo-Json
+}
+`
+	partition := DirectoryPartition{Name: name}
+	if c.restricted() {
+		return c.readDirectoryPartitionObjects(name)
+	}
+
+	pscript, err := tmplExec(partition, tmplpscript)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Creating template: %v", err)
+	}
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	if output.stdout == "" {
+		return DirectoryPartition{}, notFoundError{"Directory partition", name}
+	}
+	if err := json.Unmarshal([]byte(output.stdout), &partition); err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Unmarshalling response: %v", err)
+	}
+
+	return partition, nil
+}
+
+// readDirectoryPartitionObjects reads a directory partition on a restricted
+// endpoint, where the partition and zones are returned as objects and the
+// servers and zones are picked out here
+func (c *Client) readDirectoryPartitionObjects(name string) (DirectoryPartition, error) {
+	const tmplpscript = `
+Get-DnsServerDirectoryPartition -Name '{{ .Name }}' -ErrorAction SilentlyContinue
+`
+	const pscriptZones = `
+Get-DnsServerZone
+`
+	pscript, err := tmplExec(DirectoryPartition{Name: name}, tmplpscript)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Creating template: %v", err)
+	}
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	if len(output.objects) == 0 {
+		return DirectoryPartition{}, notFoundError{"Directory partition", name}
+	}
+	partition := DirectoryPartition{Name: formatPSValue(psProperty(output.objects[0], "DirectoryPartitionName"))}
+	for _, replica := range psStrings(psProperty(output.objects[0], "Replica")) {
+		// replicas are the DN of the NTDS settings of a server, e.g.
+		// CN=NTDS Settings,CN=DC1,CN=Servers,...
+		if parts := strings.Split(replica, ","); len(parts) > 1 {
+			partition.Replica = append(partition.Replica, strings.TrimPrefix(parts[1], "CN="))
+		}
+	}
+
+	output, err = c.ExecutePowerShellScript(pscriptZones)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	for _, zone := range output.objects {
+		if strings.EqualFold(formatPSValue(psProperty(zone, "DirectoryPartitionName")), partition.Name) {
+			partition.Zones = append(partition.Zones, formatPSValue(psProperty(zone, "ZoneName")))
+		}
+	}
+	return partition, nil
+}
+
+// CreateDirectoryPartition adds a new directory partition, the server
+// creating it is enlisted automatically
+func (c *Client) CreateDirectoryPartition(name string) (DirectoryPartition, error) {
+	const tmplpscript = `
+Add-DnsServerDirectoryPartition -Name '{{ .Name }}'
+`
+	exists, err := c.DirectoryPartitionExist(name)
+	if err != nil {
+		return DirectoryPartition{}, err
+	}
+	if exists {
+		return DirectoryPartition{}, fmt.Errorf("Directory partition already exists: %s", name)
+	}
+
+	pscript, err := tmplExec(DirectoryPartition{Name: name}, tmplpscript)
+	if err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if err := c.executeChange(pscript, func() (bool, error) { return c.DirectoryPartitionExist(name) }); err != nil {
+		return DirectoryPartition{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	var partition DirectoryPartition
+	err = c.readAfterChange(func() error {
+		partition, err = c.ReadDirectoryPartition(name)
+		return err
+	})
+	return partition, err
+}
+
+// DeleteDirectoryPartition removes a directory partition from all servers
+func (c *Client) DeleteDirectoryPartition(name string) error {
+	const tmplpscript = `
+Remove-DnsServerDirectoryPartition -Name '{{ .Name }}' -Force
+`
+	exists, err := c.DirectoryPartitionExist(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFoundError{"Directory partition", name}
+	}
+
+	pscript, err := tmplExec(DirectoryPartition{Name: name}, tmplpscript)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// EnlistDirectoryPartition registers server with the directory partition
+func (c *Client) EnlistDirectoryPartition(name, server string) error {
+	const tmplpscript = `
+Register-DnsServerDirectoryPartition -Name '{{ .Partition }}' -ComputerName '{{ .Server }}'
+`
+	pscript, err := tmplExec(partitionZone{Partition: name, Server: server}, tmplpscript)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// UnenlistDirectoryPartition unregisters server from the directory partition
+func (c *Client) UnenlistDirectoryPartition(name, server string) error {
+	const tmplpscript = `
+Unregister-DnsServerDirectoryPartition -Name '{{ .Partition }}' -ComputerName '{{ .Server }}' -Force
+`
+	pscript, err := tmplExec(partitionZone{Partition: name, Server: server}, tmplpscript)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// SetZoneDirectoryPartition changes an AD integrated zone to replicate
+// through the directory partition, when name is empty the zone is returned
+// to domain wide replication
+func (c *Client) SetZoneDirectoryPartition(zone, name string) error {
+	const tmplscriptCustom = `
+Set-DnsServerPrimaryZone -Name '{{ .Zone }}' -ReplicationScope Custom -DirectoryPartitionName '{{ .Partition }}'
+`
+	const tmplscriptDomain = `
+Set-DnsServerPrimaryZone -Name '{{ .Zone }}' -ReplicationScope Domain
+`
+	tmpl := tmplscriptCustom
+	if name == "" {
+		tmpl = tmplscriptDomain
+	}
+
+	pscript, err := tmplExec(partitionZone{Partition: name, Zone: zone}, tmpl)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// DirectoryPartitionExist returns if the directory partition exists, an
+// error reading it is returned rather than taken to mean it does not exist
+func (c *Client) DirectoryPartitionExist(name string) (bool, error) {
+	_, err := c.ReadDirectoryPartition(name)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
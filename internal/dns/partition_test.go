@@ -0,0 +1,34 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientDirectoryPartitionExist(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		if strings.Contains(script, "'dns.test.local'") {
+			return `{"Name":"dns.test.local","Replica":["DC1"],"Zones":["test.local"]}`, ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	client, err := testConfigure(server.config())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	if exists, err := client.DirectoryPartitionExist("dns.test.local"); !exists || err != nil {
+		t.Fatalf("Expected the partition to exist, got %t and %v", exists, err)
+	}
+	if exists, err := client.DirectoryPartitionExist("missing.test.local"); exists || err != nil {
+		t.Fatalf("Expected the partition not to exist, got %t and %v", exists, err)
+	}
+	// a partition that cannot be read is not taken to be gone, zones
+	// replicate through it
+	server.Fail(1)
+	if exists, err := client.DirectoryPartitionExist("dns.test.local"); exists || err == nil {
+		t.Fatalf("Expected the failed request to be an error, got %t and %v", exists, err)
+	}
+}
@@ -0,0 +1,248 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// PolicyCriteria is a single criteria of a policy, Criteria holds the
+// operator and values as used by the DnsServer cmdlets e.g. EQ,A,AAAA;NE,MX
+type PolicyCriteria struct {
+	CriteriaType string
+	Criteria     string
+}
+
+// PolicyScope is a scope a policy answers from and its weight
+type PolicyScope struct {
+	ScopeName string
+	Weight    int
+}
+
+// QueryPolicy holds a query resolution policy, a policy with an empty
+// ZoneName is a server level policy
+type QueryPolicy struct {
+	Name            string
+	ZoneName        string
+	Action          string
+	Condition       string
+	ProcessingOrder int
+	IsEnabled       bool
+	Criteria        []PolicyCriteria
+	Content         []PolicyScope
+	// ApplyOnRecursion policies answer from RecursionScope rather than
+	// from zone scopes
+	ApplyOnRecursion bool
+	RecursionScope   string
+}
+
+// tmplAddQueryPolicy is the template used to add a query resolution policy
+const tmplAddQueryPolicy = `
+Add-DnsServerQueryResolutionPolicy -Name '{{ .Name }}'{{ if .ZoneName }} -ZoneName '{{ .ZoneName }}'{{ end }} -Action {{ .Action }} -Condition {{ .Condition }}{{ if .ProcessingOrder }} -ProcessingOrder {{ .ProcessingOrder }}{{ end }}{{ if not .IsEnabled }} -Disable{{ end }}{{ range .Criteria }} -{{ .CriteriaType }} '{{ .Criteria }}'{{ end }}{{ if .ApplyOnRecursion }} -ApplyOnRecursion{{ if .RecursionScope }} -RecursionScope '{{ .RecursionScope }}'{{ end }}{{ else if .Content }} -ZoneScope '{{ .ZoneScope }}'{{ end }}
+`
+
+// ZoneScope returns the weighted zone scopes in the format used by the
+// DnsServer cmdlets e.g. scope1,2;scope2,1
+func (p QueryPolicy) ZoneScope() string {
+	var scopes []string
+	for _, v := range p.Content {
+		scopes = append(scopes, fmt.Sprintf("%s,%d", v.ScopeName, v.Weight))
+	}
+	return strings.Join(scopes, ";")
+}
+
+// ReadQueryPolicy retrieves a query resolution policy from the DNS server
+func (c *Client) ReadQueryPolicy(zone, name string) (QueryPolicy, error) {
+	const tmplpscript = `
+$p = Get-DnsServerQueryResolutionPolicy -Name '{{ .Name }}'{{ if .ZoneName }} -ZoneName '{{ .ZoneName }}'{{ end }} -ErrorAction SilentlyContinue
+if ($p) {
+	New-Object PSObject -Property @{
+		Name = $p.Name
+		Action = $p.Action.ToString()
+		Condition = $p.Condition.ToString()
+		ProcessingOrder = $p.ProcessingOrder
+		IsEnabled = $p.IsEnabled
+		Criteria = @($p.Criteria | %{ New-Object PSObject -Property @{ CriteriaType = $_.CriteriaType.ToString(); Criteria = $_.Criteria } })
+		Content = @($p.Content | %{ New-Object PSObject -Property @{ ScopeName = $_.ScopeName; Weight = $_.Weight } })
+		ApplyOnRecursion = $p.AppliesOn.ToString() -eq 'Recursion'
+	} | ConvertTo-Json -Depth 4
+}
+`
+	// restricted endpoints return the policy as an object
+	const tmplpscriptRestricted = `
+Get-DnsServerQueryResolutionPolicy -Name '{{ .Name }}'{{ if .ZoneName }} -ZoneName '{{ .ZoneName }}'{{ end }} -ErrorAction SilentlyContinue
+`
+	policy := QueryPolicy{
+		Name:     name,
+		ZoneName: zone,
+	}
+	tmpl := tmplpscript
+	if c.restricted() {
+		tmpl = tmplpscriptRestricted
+	}
+
+	pscript, err := tmplExec(policy, tmpl)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Creating template: %v", err)
+	}
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	switch {
+	case c.restricted():
+		if len(output.objects) == 0 {
+			return QueryPolicy{}, notFoundError{"Policy", name}
+		}
+		policy = queryPolicyObject(output.objects[0], zone)
+	case output.stdout == "":
+		return QueryPolicy{}, notFoundError{"Policy", name}
+	default:
+		if err := json.Unmarshal([]byte(output.stdout), &policy); err != nil {
+			return QueryPolicy{}, fmt.Errorf("Unmarshalling response: %v", err)
+		}
+	}
+	if policy.ApplyOnRecursion && len(policy.Content) > 0 {
+		policy.RecursionScope = policy.Content[0].ScopeName
+		policy.Content = nil
+	}
+
+	return policy, nil
+}
+
+// queryPolicyObject converts a policy written by
+// Get-DnsServerQueryResolutionPolicy
+func queryPolicyObject(o interface{}, zone string) QueryPolicy {
+	policy := QueryPolicy{
+		Name:             formatPSValue(psProperty(o, "Name")),
+		ZoneName:         zone,
+		Action:           formatPSValue(psProperty(o, "Action")),
+		Condition:        formatPSValue(psProperty(o, "Condition")),
+		ProcessingOrder:  int(psNumber(psProperty(o, "ProcessingOrder"))),
+		IsEnabled:        psProperty(o, "IsEnabled") == true,
+		ApplyOnRecursion: formatPSValue(psProperty(o, "AppliesOn")) == "Recursion",
+	}
+	for _, criteria := range psObjects(psProperty(o, "Criteria")) {
+		policy.Criteria = append(policy.Criteria, PolicyCriteria{
+			CriteriaType: formatPSValue(psProperty(criteria, "CriteriaType")),
+			Criteria:     formatPSValue(psProperty(criteria, "Criteria")),
+		})
+	}
+	for _, content := range psObjects(psProperty(o, "Content")) {
+		policy.Content = append(policy.Content, PolicyScope{
+			ScopeName: formatPSValue(psProperty(content, "ScopeName")),
+			Weight:    int(psNumber(psProperty(content, "Weight"))),
+		})
+	}
+	return policy
+}
+
+// CreateQueryPolicy adds a new query resolution policy to the DNS server
+func (c *Client) CreateQueryPolicy(policy QueryPolicy) (QueryPolicy, error) {
+	exists, err := c.QueryPolicyExist(policy.ZoneName, policy.Name)
+	if err != nil {
+		return QueryPolicy{}, err
+	}
+	if exists {
+		return QueryPolicy{}, fmt.Errorf("Policy already exists: %s", policy.Name)
+	}
+
+	pscript, err := tmplExec(policy, tmplAddQueryPolicy)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if err := c.executeChange(pscript, func() (bool, error) { return c.QueryPolicyExist(policy.ZoneName, policy.Name) }); err != nil {
+		return QueryPolicy{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return c.readQueryPolicyAfterChange(policy.ZoneName, policy.Name)
+}
+
+// UpdateQueryPolicy replaces an existing query resolution policy, the policy
+// is removed and added again so criteria can be removed as well as changed.
+// The policy read before removing it is added back when adding the new one
+// fails, so a bad criterion or scope does not leave it deleted
+func (c *Client) UpdateQueryPolicy(policy QueryPolicy) (QueryPolicy, error) {
+	const tmplpscript = `
+Remove-DnsServerQueryResolutionPolicy -Name '{{ .Name }}'{{ if .ZoneName }} -ZoneName '{{ .ZoneName }}'{{ end }} -Force
+`
+	previous, err := c.ReadQueryPolicy(policy.ZoneName, policy.Name)
+	if err != nil {
+		return QueryPolicy{}, err
+	}
+
+	remove, err := tmplExec(policy, tmplpscript)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Creating template: %v", err)
+	}
+	add, err := tmplExec(policy, tmplAddQueryPolicy)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Creating template: %v", err)
+	}
+	restore, err := tmplExec(previous, tmplAddQueryPolicy)
+	if err != nil {
+		return QueryPolicy{}, fmt.Errorf("Creating template: %v", err)
+	}
+
+	exists := func() (bool, error) { return c.QueryPolicyExist(policy.ZoneName, policy.Name) }
+	removed := func() (bool, error) {
+		found, err := exists()
+		return !found, err
+	}
+	if err := c.executeChange(remove, removed); err != nil {
+		return QueryPolicy{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+	if err := c.executeChange(add, exists); err != nil {
+		if restoreErr := c.executeChange(restore, exists); restoreErr != nil {
+			return QueryPolicy{}, fmt.Errorf("Executing PowerShell script: %v, adding back the previous policy failed: %v", err, restoreErr)
+		}
+		return QueryPolicy{}, fmt.Errorf("Executing PowerShell script: %v, the previous policy was added back", err)
+	}
+
+	return c.readQueryPolicyAfterChange(policy.ZoneName, policy.Name)
+}
+
+// readQueryPolicyAfterChange reads a policy that was just added
+func (c *Client) readQueryPolicyAfterChange(zone, name string) (QueryPolicy, error) {
+	var policy QueryPolicy
+	err := c.readAfterChange(func() error {
+		var err error
+		policy, err = c.ReadQueryPolicy(zone, name)
+		return err
+	})
+	return policy, err
+}
+
+// DeleteQueryPolicy removes a query resolution policy from the DNS server
+func (c *Client) DeleteQueryPolicy(zone, name string) error {
+	const tmplpscript = `
+Remove-DnsServerQueryResolutionPolicy -Name '{{ .Name }}'{{ if .ZoneName }} -ZoneName '{{ .ZoneName }}'{{ end }} -Force
+`
+	exists, err := c.QueryPolicyExist(zone, name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFoundError{"Policy", name}
+	}
+
+	pscript, err := tmplExec(QueryPolicy{Name: name, ZoneName: zone}, tmplpscript)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// QueryPolicyExist returns if the query resolution policy exists, an error
+// reading it is returned rather than taken to mean it does not exist
+func (c *Client) QueryPolicyExist(zone, name string) (bool, error) {
+	_, err := c.ReadQueryPolicy(zone, name)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,116 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientQueryPolicyExist(t *testing.T) {
+	const policy = `{"Name":"blocked","Action":"DENY","Condition":"AND","ProcessingOrder":1,"IsEnabled":true,"Criteria":[],"Content":[],"ApplyOnRecursion":false}`
+	server := newTestWinRM(t, func(script string) (string, string) {
+		switch {
+		case strings.Contains(script, "'blocked'"):
+			return policy, ""
+		case strings.Contains(script, "'denied'"):
+			return "", "+ FullyQualifiedErrorId : WIN32 5,Get-DnsServerQueryResolutionPolicy"
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	client, err := testConfigure(server.config())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	cases := []struct {
+		name   string
+		exists bool
+		err    bool
+	}{
+		{"blocked", true, false},
+		{"missing", false, false},
+		{"denied", false, true},
+	}
+	for _, tc := range cases {
+		exists, err := client.QueryPolicyExist("", tc.name)
+		if exists != tc.exists || (err != nil) != tc.err {
+			t.Errorf("%s: expected %t and error %t, got %t and %v", tc.name, tc.exists, tc.err, exists, err)
+		}
+	}
+
+	// a policy that cannot be read is not taken to be gone
+	server.Fail(1)
+	if exists, err := client.QueryPolicyExist("", "blocked"); exists || err == nil || IsNotFound(err) {
+		t.Fatalf("Expected the failed request to be an error, got %t and %v", exists, err)
+	}
+	if _, err := client.ReadQueryPolicy("", "missing"); !IsNotFound(err) {
+		t.Fatalf("Expected a not found error, got %v", err)
+	}
+	if err := client.DeleteQueryPolicy("", "missing"); !IsNotFound(err) {
+		t.Fatalf("Expected a not found error deleting the missing policy, got %v", err)
+	}
+}
+
+func TestClientUpdateQueryPolicy_Restore(t *testing.T) {
+	const policyTemplate = `{"Name":"blocked","Action":"DENY","Condition":"AND","ProcessingOrder":1,"IsEnabled":true,"Criteria":[{"CriteriaType":"Fqdn","Criteria":"%s"}],"Content":[],"ApplyOnRecursion":false}`
+	fqdn := regexp.MustCompile(`-Fqdn '([^']*)'`)
+	var mu sync.Mutex
+	criteria := "EQ,*.bad.test"
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(script, "Remove-DnsServerQueryResolutionPolicy"):
+			criteria = ""
+		case strings.Contains(script, "Add-DnsServerQueryResolutionPolicy"):
+			m := fqdn.FindStringSubmatch(script)
+			if strings.Contains(m[1], "invalid") {
+				return "", "+ FullyQualifiedErrorId : WIN32 87,Add-DnsServerQueryResolutionPolicy"
+			}
+			criteria = m[1]
+		case criteria != "":
+			return fmt.Sprintf(policyTemplate, criteria), ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	client, err := testConfigure(server.config())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	update := func(criteria string) (QueryPolicy, error) {
+		return client.UpdateQueryPolicy(QueryPolicy{
+			Name:            "blocked",
+			Action:          "DENY",
+			Condition:       "AND",
+			ProcessingOrder: 1,
+			IsEnabled:       true,
+			Criteria:        []PolicyCriteria{{CriteriaType: "Fqdn", Criteria: criteria}},
+		})
+	}
+
+	policy, err := update("EQ,*.worse.test")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if policy.Criteria[0].Criteria != "EQ,*.worse.test" {
+		t.Fatalf("Expected the updated policy, got %v", policy)
+	}
+
+	// the policy is added back when the new one is refused
+	if _, err := update("EQ,invalid"); err == nil || !strings.Contains(err.Error(), "the previous policy was added back") {
+		t.Fatalf("Expected the update to fail, got %v", err)
+	}
+	policy, err = client.ReadQueryPolicy("", "blocked")
+	if err != nil {
+		t.Fatalf("Expected the previous policy to be added back, got %s", err)
+	}
+	if policy.Criteria[0].Criteria != "EQ,*.worse.test" {
+		t.Fatalf("Expected the previous policy, got %v", policy)
+	}
+}
@@ -0,0 +1,139 @@
+package dns
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/masterzen/simplexml/dom"
+	"github.com/masterzen/winrm/soap"
+)
+
+var nsWSManIdentity = dom.Namespace{Prefix: "wsmid", Uri: "http://schemas.dmtf.org/wbem/wsman/identity/1/wsmanidentity.xsd"}
+
+var identifyProductVersion = regexp.MustCompile(`ProductVersion>([^<]*)<`)
+
+// probeScript lists the cmdlet the records are managed with, its output is
+// empty when the DnsServer module is not installed
+const probeScript = `Get-Command -Name Get-DnsServerResourceRecord -ErrorAction SilentlyContinue`
+
+// Probe checks the server can be used before any script runs, returning an
+// error naming the step that failed: resolving its name, connecting to it,
+// authenticating or finding the DnsServer module. With several servers it
+// succeeds when one of them can be used
+func (c *Client) Probe() error {
+	if c.servers == nil {
+		return c.probe()
+	}
+
+	var failures []string
+	for _, client := range c.servers.clients {
+		err := client.probe()
+		if err == nil {
+			return nil
+		}
+		log.Printf("[WARN] Server %s cannot be used: %v", client.ServerName, err)
+		failures = append(failures, err.Error())
+	}
+	return fmt.Errorf("None of the servers can be used:\n%s", strings.Join(failures, "\n"))
+}
+
+// probe checks the server the client is configured for
+func (c *Client) probe() error {
+	timeout := c.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if err := c.probeConnection(timeout); err != nil {
+		return err
+	}
+
+	if c.sshConn != nil {
+		if _, err := c.sshConn.connect(); err != nil {
+			if strings.Contains(err.Error(), "unable to authenticate") {
+				return fmt.Errorf("SSH server %s rejected the credentials of %s: %v", c.ServerName, c.Username, err)
+			}
+			return err
+		}
+	} else if err := c.identify(); err != nil {
+		return err
+	}
+
+	output, err := c.executeOnServer(probeScript)
+	if err != nil {
+		return fmt.Errorf("Error running PowerShell on %s: %v", c.ServerName, err)
+	}
+	if strings.TrimSpace(output.stdout) == "" && len(output.objects) == 0 {
+		return fmt.Errorf("The DnsServer PowerShell module is not available on %s, install the DNS Server role or the DNS Server Tools feature", c.ServerName)
+	}
+	return nil
+}
+
+// probeConnection resolves the server's name, unless a proxy or bastion
+// connects to it, and opens a connection to its port
+func (c *Client) probeConnection(timeout time.Duration) error {
+	host, port, _ := net.SplitHostPort(c.address)
+	service := "WinRM"
+	if c.sshConn != nil {
+		service = "sshd"
+	}
+
+	dial := c.dial
+	if dial == nil {
+		if net.ParseIP(host) == nil {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			if _, err := net.DefaultResolver.LookupHost(ctx, host); err != nil {
+				if dnsErr, ok := err.(*net.DNSError); ok && dnsErr.IsNotFound {
+					return fmt.Errorf("Cannot resolve %s, the name does not exist: %v", host, err)
+				}
+				return fmt.Errorf("Cannot resolve %s: %v", host, err)
+			}
+		}
+		dial = (&net.Dialer{Timeout: timeout}).Dial
+	}
+
+	conn, err := dial("tcp", c.address)
+	if err != nil {
+		msg := err.Error()
+		switch {
+		case strings.Contains(msg, "connection refused"):
+			return fmt.Errorf("%s refused the connection to port %s, check %s is listening on it: %v", host, port, service, err)
+		case strings.Contains(msg, "i/o timeout") || strings.Contains(msg, "timed out"):
+			return fmt.Errorf("Timed out connecting to port %s of %s, check a firewall allows it: %v", port, host, err)
+		default:
+			return fmt.Errorf("Error connecting to %s: %v", c.address, err)
+		}
+	}
+	conn.Close()
+	return nil
+}
+
+// identify sends an authenticated WS-Management Identify request, which
+// checks the TLS certificate and credentials without starting a shell
+func (c *Client) identify() error {
+	message := soap.NewMessage()
+	message.Header().Build()
+	message.CreateBodyElement("Identify", nsWSManIdentity)
+
+	response, err := c.post(message)
+	switch err.(type) {
+	case nil:
+	case basicError, ntlmError, kerberosError:
+		return fmt.Errorf("WinRM on %s rejected the credentials of %s: %v", c.ServerName, c.Username, err)
+	default:
+		if isTLSError(err) {
+			return c.tlsError(err)
+		}
+		return fmt.Errorf("WinRM on %s did not answer an Identify request: %v", c.ServerName, err)
+	}
+
+	if m := identifyProductVersion.FindStringSubmatch(response); m != nil {
+		log.Printf("[DEBUG] WinRM on %s is %s", c.ServerName, m[1])
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package dns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestClientProbe(t *testing.T) {
+	module := true
+	var mu sync.Mutex
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if !module {
+			return "", ""
+		}
+		return "CommandType Name                        Version Source\r\nFunction    Get-DnsServerResourceRecord 2.0.0.0 DnsServer\r\n", ""
+	})
+	defer server.Close()
+	tlsServer := httptest.NewTLSServer(server)
+	defer tlsServer.Close()
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	cases := []struct {
+		name   string
+		module bool
+		config func(c *Client)
+		err    string
+	}{
+		{"powershell", true, func(c *Client) {}, ""},
+		{"psrp", true, func(c *Client) {
+			c.Backend = "psrp"
+		}, ""},
+		{"module missing", false, func(c *Client) {}, "The DnsServer PowerShell module is not available on 127.0.0.1"},
+		{"module missing with psrp", false, func(c *Client) {
+			c.Backend = "psrp"
+		}, "The DnsServer PowerShell module is not available"},
+		{"wrong password", true, func(c *Client) {
+			c.Password = "wrong"
+		}, `WinRM on 127.0.0.1 rejected the credentials of user: Authentication failed: http error 401, server offered "Negotiate, Basic realm=\"WSMAN\""`},
+		{"refused", true, func(c *Client) {
+			u, _ := url.Parse(closed.URL)
+			c.Port, _ = strconv.Atoi(u.Port())
+		}, "refused the connection to port"},
+		{"unknown name", true, func(c *Client) {
+			c.ServerName = "dc1.missing.invalid"
+		}, "Cannot resolve dc1.missing.invalid"},
+		{"tls", true, func(c *Client) {
+			u, _ := url.Parse(tlsServer.URL)
+			c.Port, _ = strconv.Atoi(u.Port())
+			c.HTTPS = true
+		}, "TLS verification of 127.0.0.1 failed"},
+		{"failover", true, func(c *Client) {
+			u, _ := url.Parse(server.URL)
+			c.Servers = []string{closed.Listener.Addr().String(), u.Host}
+		}, ""},
+		{"no server", true, func(c *Client) {
+			c.Servers = []string{closed.Listener.Addr().String(), "dc1.missing.invalid:5985"}
+		}, "None of the servers can be used"},
+	}
+	for _, tc := range cases {
+		mu.Lock()
+		module = tc.module
+		mu.Unlock()
+		c := server.config()
+		tc.config(&c)
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("%s: Error: %s", tc.name, err)
+		}
+		err = client.Probe()
+		client.Close()
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+}
+
+func TestClientProbe_SSH(t *testing.T) {
+	sshd := newTestSSHD(t, "pass", nil, func(script string) (string, string) {
+		return "Get-DnsServerResourceRecord", ""
+	})
+	defer sshd.Close()
+	knownHosts := testKnownHosts(t, sshd.Addr(), sshd.hostKey)
+	defer os.Remove(knownHosts)
+
+	for _, tc := range []struct {
+		password string
+		err      string
+	}{
+		{"pass", ""},
+		{"wrong", "SSH server 127.0.0.1 rejected the credentials of user"},
+	} {
+		c := sshd.config()
+		c.SSHKnownHosts = knownHosts
+		c.Password = tc.password
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		err = client.Probe()
+		client.Close()
+		if tc.err == "" && err != nil {
+			t.Errorf("Unexpected error: %s", err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("Expected error containing %q, got %v", tc.err, err)
+		}
+	}
+	if scripts := sshd.Scripts(); len(scripts) != 1 || !strings.Contains(scripts[0], "Get-Command -Name Get-DnsServerResourceRecord") {
+		t.Fatalf("Expected the DnsServer module to be checked once, got %q", scripts)
+	}
+}
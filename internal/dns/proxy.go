@@ -0,0 +1,149 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+// dialFunc connects to an address, directly or through a proxy or SSH
+// bastion
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// newDialer returns the function connecting to the WinRM endpoint, nil when
+// neither ProxyURL nor BastionHost is set and connections are made directly
+func (c *Client) newDialer(timeout time.Duration) (dialFunc, error) {
+	if c.ProxyURL == "" && c.BastionHost == "" {
+		return nil, nil
+	}
+
+	direct := &net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}
+	dial := direct.Dial
+	if c.ProxyURL != "" {
+		u, err := url.Parse(c.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy URL: %v", err)
+		}
+		switch u.Scheme {
+		case "http":
+			dial = (&connectDialer{proxy: u, dial: direct.Dial, timeout: timeout}).Dial
+		case "socks5", "socks5h":
+			u.Scheme = "socks5"
+			dialer, err := proxy.FromURL(u, direct)
+			if err != nil {
+				return nil, fmt.Errorf("Invalid proxy URL: %v", err)
+			}
+			dial = dialer.Dial
+		default:
+			return nil, fmt.Errorf("Unsupported proxy scheme: %s", u.Scheme)
+		}
+	}
+
+	if c.BastionHost == "" {
+		return dial, nil
+	}
+	bastion, err := c.newBastion(dial, timeout)
+	if err != nil {
+		return nil, err
+	}
+	c.bastion = bastion
+	return bastion.Dial, nil
+}
+
+// connectDialer opens connections through an HTTP proxy with the CONNECT
+// method, so NTLM and Kerberos keep a connection to the server for the
+// whole handshake
+type connectDialer struct {
+	proxy   *url.URL
+	dial    dialFunc
+	timeout time.Duration
+}
+
+// Dial connects to addr through the proxy
+func (d *connectDialer) Dial(network, addr string) (net.Conn, error) {
+	host := d.proxy.Host
+	if d.proxy.Port() == "" {
+		host = net.JoinHostPort(d.proxy.Hostname(), "80")
+	}
+	conn, err := d.dial(network, host)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if user := d.proxy.User; user != nil {
+		password, _ := user.Password()
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(user.Username()+":"+password)))
+	}
+	if d.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(d.timeout))
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error connecting to proxy %s: %w", host, err)
+	}
+	// the proxy sends nothing after its response until the server does, so
+	// no data is lost by the buffered reader
+	resp, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error connecting to proxy %s: %w", host, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("Proxy %s refused to connect to %s: %s", host, addr, resp.Status)
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// newBastion returns the connection to the bastion configured by the
+// Bastion fields of the client, its SSH server is connected to with dial
+func (c *Client) newBastion(dial dialFunc, timeout time.Duration) (*sshConnection, error) {
+	addr := c.BastionHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+
+	auth, err := sshAuth(c.BastionPassword, c.BastionPrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading bastion private key: %v", err)
+	}
+	if len(auth) == 0 {
+		return nil, fmt.Errorf("A password or private key is required for the bastion host")
+	}
+	config := &ssh.ClientConfig{
+		User:    c.BastionUser,
+		Auth:    auth,
+		Timeout: timeout,
+	}
+	switch {
+	case len(c.BastionHostKey) > 0:
+		key, _, _, _, err := ssh.ParseAuthorizedKey(c.BastionHostKey)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading bastion host key: %v", err)
+		}
+		config.HostKeyCallback = ssh.FixedHostKey(key)
+	case c.BastionInsecure:
+		config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
+	default:
+		if config.HostKeyCallback, err = knownHostsCallback(c.SSHKnownHosts); err != nil {
+			return nil, err
+		}
+	}
+
+	return &sshConnection{name: "bastion", addr: addr, config: config, dial: dial, timeout: timeout}, nil
+}
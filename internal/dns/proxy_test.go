@@ -0,0 +1,331 @@
+package dns
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestClientProxy(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return "", ""
+	})
+	defer server.Close()
+	target := strings.TrimPrefix(server.URL, "http://")
+
+	connect := newTestConnectProxy(t, "Basic "+base64.StdEncoding.EncodeToString([]byte("proxyuser:proxypass")))
+	defer connect.Close()
+	socks := newTestSOCKS5Proxy(t, "proxyuser", "proxypass")
+	defer socks.Close()
+	bastion, hostKey := newTestBastion(t, "sshpass")
+	defer bastion.Close()
+	other, otherKey := newTestBastion(t, "sshpass")
+	defer other.Close()
+	knownHosts := testKnownHosts(t, other.Addr(), otherKey)
+	defer os.Remove(knownHosts)
+
+	cases := []struct {
+		name    string
+		config  func(c *Client)
+		proxies []*testProxy
+		err     string
+	}{
+		{"connect", func(c *Client) {
+			c.ProxyURL = "http://proxyuser:proxypass@" + connect.Addr()
+		}, []*testProxy{connect}, ""},
+		{"connect denied", func(c *Client) {
+			c.ProxyURL = "http://proxyuser:wrong@" + connect.Addr()
+		}, nil, "407 Proxy Authentication Required"},
+		{"socks5", func(c *Client) {
+			c.ProxyURL = "socks5://proxyuser:proxypass@" + socks.Addr()
+		}, []*testProxy{socks}, ""},
+		{"bastion", func(c *Client) {
+			c.BastionHost = bastion.Addr()
+			c.BastionUser = "admin"
+			c.BastionPassword = "sshpass"
+			c.BastionHostKey = ssh.MarshalAuthorizedKey(hostKey)
+		}, []*testProxy{bastion}, ""},
+		{"bastion through socks5", func(c *Client) {
+			c.ProxyURL = "socks5://proxyuser:proxypass@" + socks.Addr()
+			c.BastionHost = other.Addr()
+			c.BastionUser = "admin"
+			c.BastionPassword = "sshpass"
+			c.SSHKnownHosts = knownHosts
+		}, []*testProxy{other}, ""},
+		{"bastion not in known hosts", func(c *Client) {
+			c.BastionHost = bastion.Addr()
+			c.BastionUser = "admin"
+			c.BastionPassword = "sshpass"
+			c.SSHKnownHosts = knownHosts
+		}, nil, "is not in " + knownHosts},
+		{"bastion insecure", func(c *Client) {
+			c.BastionHost = bastion.Addr()
+			c.BastionUser = "admin"
+			c.BastionPassword = "sshpass"
+			c.SSHKnownHosts = knownHosts
+			c.BastionInsecure = true
+		}, []*testProxy{bastion}, ""},
+		{"bastion host key mismatch", func(c *Client) {
+			c.BastionHost = bastion.Addr()
+			c.BastionUser = "admin"
+			c.BastionPassword = "sshpass"
+			c.BastionHostKey = ssh.MarshalAuthorizedKey(otherKey)
+		}, nil, "host key mismatch"},
+		{"unsupported scheme", func(c *Client) {
+			c.ProxyURL = "ftp://" + connect.Addr()
+		}, nil, "Unsupported proxy scheme: ftp"},
+	}
+	for _, tc := range cases {
+		c := server.config()
+		tc.config(&c)
+		client, err := testConfigure(c)
+		if err == nil {
+			_, err = client.ExecutePowerShellScript("Get-DnsServerZone")
+			client.Close()
+		}
+		if tc.err == "" && err != nil {
+			t.Fatalf("%s: Error: %s", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Fatalf("%s: Expected error %q, got %v", tc.name, tc.err, err)
+		}
+		for _, p := range tc.proxies {
+			if targets := p.Targets(); len(targets) == 0 || targets[len(targets)-1] != target {
+				t.Fatalf("%s: Expected connection to %s, got %v", tc.name, target, targets)
+			}
+		}
+	}
+
+	if targets := socks.Targets(); targets[len(targets)-1] != other.Addr() {
+		t.Fatalf("Expected bastion to be reached through the proxy, got %v", targets)
+	}
+}
+
+// testProxy is an HTTP CONNECT proxy, SOCKS5 proxy or SSH bastion stand-in,
+// it records the addresses it connected to
+type testProxy struct {
+	t        *testing.T
+	listener net.Listener
+	handle   func(conn net.Conn)
+
+	mu      sync.Mutex
+	targets []string
+}
+
+func newTestProxy(t *testing.T) *testProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting proxy: %s", err)
+	}
+	return &testProxy{t: t, listener: l}
+}
+
+// newTestConnectProxy answers CONNECT requests sending authorization as the
+// Proxy-Authorization header, any header is accepted when it is empty
+func newTestConnectProxy(t *testing.T, authorization string) *testProxy {
+	p := newTestProxy(t)
+	p.handle = func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != "CONNECT" {
+			conn.Close()
+			return
+		}
+		if authorization != "" && req.Header.Get("Proxy-Authorization") != authorization {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			conn.Close()
+			return
+		}
+		target, err := p.dial(req.Host)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			conn.Close()
+			return
+		}
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		testPipe(conn, target)
+	}
+	go p.serve()
+	return p
+}
+
+// newTestSOCKS5Proxy answers SOCKS5 CONNECT requests, with username and
+// password authentication when username is set
+func newTestSOCKS5Proxy(t *testing.T, username, password string) *testProxy {
+	p := newTestProxy(t)
+	p.handle = func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		buf := make([]byte, 2)
+		if _, err := io.ReadFull(r, buf); err != nil || buf[0] != 5 {
+			conn.Close()
+			return
+		}
+		io.ReadFull(r, make([]byte, buf[1]))
+		if username == "" {
+			conn.Write([]byte{5, 0})
+		} else {
+			conn.Write([]byte{5, 2})
+			// RFC 1929 username and password
+			io.ReadFull(r, buf)
+			user := make([]byte, buf[1])
+			io.ReadFull(r, user)
+			io.ReadFull(r, buf[:1])
+			pass := make([]byte, buf[0])
+			io.ReadFull(r, pass)
+			if string(user) != username || string(pass) != password {
+				conn.Write([]byte{1, 1})
+				conn.Close()
+				return
+			}
+			conn.Write([]byte{1, 0})
+		}
+
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil || header[1] != 1 {
+			conn.Close()
+			return
+		}
+		var host string
+		switch header[3] {
+		case 1:
+			ip := make([]byte, 4)
+			io.ReadFull(r, ip)
+			host = net.IP(ip).String()
+		case 3:
+			io.ReadFull(r, buf[:1])
+			name := make([]byte, buf[0])
+			io.ReadFull(r, name)
+			host = string(name)
+		default:
+			conn.Close()
+			return
+		}
+		io.ReadFull(r, buf)
+		target, err := p.dial(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf)))))
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			conn.Close()
+			return
+		}
+		conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+		testPipe(conn, target)
+	}
+	go p.serve()
+	return p
+}
+
+// newTestBastion is an SSH server accepting password, forwarding
+// direct-tcpip channels, it returns its host key
+func newTestBastion(t *testing.T, password string) (*testProxy, ssh.PublicKey) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if string(pass) != password {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	p := newTestProxy(t)
+	p.handle = func(conn net.Conn) {
+		_, chans, reqs, err := ssh.NewServerConn(conn, config)
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		for newChannel := range chans {
+			var forward struct {
+				Host       string
+				Port       uint32
+				OriginHost string
+				OriginPort uint32
+			}
+			if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &forward) != nil {
+				newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+				continue
+			}
+			target, err := p.dial(net.JoinHostPort(forward.Host, strconv.Itoa(int(forward.Port))))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				target.Close()
+				continue
+			}
+			go ssh.DiscardRequests(requests)
+			go testPipe(channel, target)
+		}
+	}
+	go p.serve()
+	return p, signer.PublicKey()
+}
+
+func (p *testProxy) Addr() string {
+	return p.listener.Addr().String()
+}
+
+// Targets returns the addresses connected to
+func (p *testProxy) Targets() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string{}, p.targets...)
+}
+
+func (p *testProxy) Close() {
+	p.listener.Close()
+}
+
+func (p *testProxy) serve() {
+	for {
+		conn, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		go p.handle(conn)
+	}
+}
+
+func (p *testProxy) dial(addr string) (net.Conn, error) {
+	p.mu.Lock()
+	p.targets = append(p.targets, addr)
+	p.mu.Unlock()
+	return net.Dial("tcp", addr)
+}
+
+// testPipe copies between a and b until either is closed
+func testPipe(a, b io.ReadWriteCloser) {
+	done := make(chan struct{}, 2)
+	copy := func(dst io.Writer, src io.Reader) {
+		io.Copy(dst, src)
+		done <- struct{}{}
+	}
+	go copy(a, b)
+	go copy(b, a)
+	<-done
+	a.Close()
+	b.Close()
+}
@@ -0,0 +1,767 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/masterzen/simplexml/dom"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+// psrpResourceURI is the WS-Management plugin hosting PowerShell remoting
+const psrpResourceURI = "http://schemas.microsoft.com/powershell/Microsoft.PowerShell"
+
+const (
+	psrpProtocolVersion = "2.3"
+	// psrpMaxFragment is the largest message blob sent in one fragment
+	psrpMaxFragment = 32 * 1024
+	// psrpDestinationServer addresses messages to the server
+	psrpDestinationServer = 2
+)
+
+// PSRP message types used by the backend
+const (
+	psrpSessionCapability = 0x00010002
+	psrpInitRunspacePool  = 0x00010004
+	psrpRunspacePoolState = 0x00021005
+	psrpCreatePipeline    = 0x00021006
+	psrpPipelineOutput    = 0x00041004
+	psrpErrorRecord       = 0x00041005
+	psrpPipelineState     = 0x00041006
+)
+
+// Runspace pool and pipeline states
+const (
+	psrpRunspacePoolOpened = 2
+	psrpRunspacePoolClosed = 3
+	psrpRunspacePoolBroken = 5
+	psrpPipelineStopped    = 3
+	psrpPipelineCompleted  = 4
+	psrpPipelineFailed     = 5
+)
+
+const (
+	wsmanActionCreate  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Create"
+	wsmanActionDelete  = "http://schemas.xmlsoap.org/ws/2004/09/transfer/Delete"
+	wsmanActionCommand = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Command"
+	wsmanActionReceive = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Receive"
+	wsmanActionSend    = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Send"
+	wsmanActionSignal  = "http://schemas.microsoft.com/wbem/wsman/1/windows/shell/Signal"
+	wsmanSignalStop    = "powershell/signal/crtl_c"
+	wsmanAnonymous     = "http://schemas.xmlsoap.org/ws/2004/08/addressing/role/anonymous"
+)
+
+var nsPowerShell = dom.Namespace{Prefix: "ps", Uri: "http://schemas.microsoft.com/powershell"}
+
+const psrpSessionCapabilityXML = `<Obj RefId="0"><MS>` +
+	`<Version N="protocolversion">` + psrpProtocolVersion + `</Version>` +
+	`<Version N="PSVersion">2.0</Version>` +
+	`<Version N="SerializationVersion">1.1.0.1</Version>` +
+	`</MS></Obj>`
+
+const psrpHostInfoXML = `<MS><B N="_isHostNull">true</B><B N="_isHostUINull">true</B>` +
+	`<B N="_isHostRawUINull">true</B><B N="_useRunspaceHost">true</B></MS>`
+
+const psrpApartmentStateXML = `<TN RefId="0"><T>System.Threading.ApartmentState</T><T>System.Enum</T>` +
+	`<T>System.ValueType</T><T>System.Object</T></TN><ToString>Unknown</ToString><I32>2</I32>`
+
+// psrpInitRunspacePoolXML is the INIT_RUNSPACEPOOL message, formatted with
+// the maximum number of runspaces
+const psrpInitRunspacePoolXML = `<Obj RefId="0"><MS>` +
+	`<I32 N="MinRunspaces">1</I32><I32 N="MaxRunspaces">%d</I32>` +
+	`<Obj N="PSThreadOptions" RefId="1"><TN RefId="1"><T>System.Management.Automation.Runspaces.PSThreadOptions</T>` +
+	`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>Default</ToString><I32>0</I32></Obj>` +
+	`<Obj N="ApartmentState" RefId="2">` + psrpApartmentStateXML + `</Obj>` +
+	`<Obj N="HostInfo" RefId="3">` + psrpHostInfoXML + `</Obj>` +
+	`<Nil N="ApplicationArguments" />` +
+	`</MS></Obj>`
+
+func initRunspacePoolXML(runspaces int) string {
+	if runspaces < 1 {
+		runspaces = 1
+	}
+	return fmt.Sprintf(psrpInitRunspacePoolXML, runspaces)
+}
+
+// psrpMergeResults are the stream redirections of a command, none are
+// merged so errors are returned as error records
+var psrpMergeResults = []string{
+	"MergeMyResult", "MergeToResult", "MergePreviousResults", "MergeError",
+	"MergeWarning", "MergeVerbose", "MergeDebug", "MergeInformation",
+}
+
+// createPipelineXML returns the CREATE_PIPELINE message running a pipeline
+// of commands
+func createPipelineXML(pipeline []psCommand) string {
+	var b strings.Builder
+	b.WriteString(`<Obj RefId="0"><MS>`)
+	b.WriteString(`<B N="NoInput">true</B>`)
+	b.WriteString(`<Obj N="ApartmentState" RefId="1">` + psrpApartmentStateXML + `</Obj>`)
+	b.WriteString(`<Obj N="RemoteStreamOptions" RefId="2"><TN RefId="1"><T>System.Management.Automation.RemoteStreamOptions</T>` +
+		`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>0</ToString><I32>0</I32></Obj>`)
+	b.WriteString(`<B N="AddToHistory">false</B>`)
+	b.WriteString(`<Obj N="HostInfo" RefId="3">` + psrpHostInfoXML + `</Obj>`)
+	b.WriteString(`<Obj N="PowerShell" RefId="4"><MS>`)
+	b.WriteString(`<Obj N="Cmds" RefId="5"><TN RefId="2"><T>System.Collections.Generic.List` + "`" +
+		`1[[System.Management.Automation.PSObject, System.Management.Automation, Version=1.0.0.0, Culture=neutral, PublicKeyToken=31bf3856ad364e35]]</T>` +
+		`<T>System.Object</T></TN><LST>`)
+	w := &pipelineWriter{b: &b, refID: 6}
+	for _, cmd := range pipeline {
+		w.writeCommand(cmd)
+	}
+	b.WriteString(`</LST></Obj>`)
+	b.WriteString(`<B N="IsNested">false</B><Nil N="History" /><B N="RedirectShellErrorOutputPipe">true</B>`)
+	b.WriteString(`</MS></Obj>`)
+	b.WriteString(`<B N="IsNested">false</B>`)
+	b.WriteString(`</MS></Obj>`)
+	return b.String()
+}
+
+// pipelineWriter writes the commands of a CREATE_PIPELINE message, object
+// references and the type names written once are numbered as it goes
+type pipelineWriter struct {
+	b     *strings.Builder
+	refID int
+	// resultTypes and array are set once the type names of
+	// PipelineResultTypes and object arrays were written
+	resultTypes bool
+	array       bool
+}
+
+func (w *pipelineWriter) nextRefID() int {
+	w.refID++
+	return w.refID - 1
+}
+
+func (w *pipelineWriter) writeCommand(cmd psCommand) {
+	w.b.WriteString(fmt.Sprintf(`<Obj RefId="%d"><MS>`, w.nextRefID()))
+	w.b.WriteString(`<S N="Cmd">` + encodeCLIXMLString(cmd.name) + `</S>`)
+	w.b.WriteString(fmt.Sprintf(`<B N="IsScript">%t</B><Nil N="UseLocalScope" />`, cmd.script))
+	for _, name := range psrpMergeResults {
+		if !w.resultTypes {
+			w.resultTypes = true
+			w.b.WriteString(fmt.Sprintf(`<Obj N="%s" RefId="%d"><TN RefId="3"><T>System.Management.Automation.Runspaces.PipelineResultTypes</T>`+
+				`<T>System.Enum</T><T>System.ValueType</T><T>System.Object</T></TN><ToString>None</ToString><I32>0</I32></Obj>`, name, w.nextRefID()))
+			continue
+		}
+		w.b.WriteString(fmt.Sprintf(`<Obj N="%s" RefId="%d"><TNRef RefId="3" /><ToString>None</ToString><I32>0</I32></Obj>`, name, w.nextRefID()))
+	}
+	w.b.WriteString(fmt.Sprintf(`<Obj N="Args" RefId="%d"><TNRef RefId="2" /><LST>`, w.nextRefID()))
+	for _, arg := range cmd.args {
+		w.b.WriteString(fmt.Sprintf(`<Obj RefId="%d"><MS>`, w.nextRefID()))
+		if arg.name == "" {
+			w.b.WriteString(`<Nil N="N" />`)
+		} else {
+			w.b.WriteString(`<S N="N">` + encodeCLIXMLString(arg.name) + `</S>`)
+		}
+		w.writeValue(` N="V"`, arg.value)
+		w.b.WriteString(`</MS></Obj>`)
+	}
+	w.b.WriteString(`</LST></Obj>`)
+	w.b.WriteString(`</MS></Obj>`)
+}
+
+// writeValue writes an argument value, attr is the N attribute of a
+// property or empty for a list item
+func (w *pipelineWriter) writeValue(attr string, v interface{}) {
+	switch v := v.(type) {
+	case nil:
+		w.b.WriteString(`<Nil` + attr + ` />`)
+	case bool:
+		w.b.WriteString(fmt.Sprintf(`<B%s>%t</B>`, attr, v))
+	case int64:
+		if v >= math.MinInt32 && v <= math.MaxInt32 {
+			w.b.WriteString(fmt.Sprintf(`<I32%s>%d</I32>`, attr, v))
+		} else {
+			w.b.WriteString(fmt.Sprintf(`<I64%s>%d</I64>`, attr, v))
+		}
+	case float64:
+		w.b.WriteString(fmt.Sprintf(`<Db%s>%s</Db>`, attr, strconv.FormatFloat(v, 'g', -1, 64)))
+	case []interface{}:
+		w.b.WriteString(fmt.Sprintf(`<Obj%s RefId="%d">`, attr, w.nextRefID()))
+		if !w.array {
+			w.array = true
+			w.b.WriteString(`<TN RefId="4"><T>System.Object[]</T><T>System.Array</T><T>System.Object</T></TN>`)
+		} else {
+			w.b.WriteString(`<TNRef RefId="4" />`)
+		}
+		w.b.WriteString(`<LST>`)
+		for _, item := range v {
+			w.writeValue("", item)
+		}
+		w.b.WriteString(`</LST></Obj>`)
+	default:
+		w.b.WriteString(fmt.Sprintf(`<S%s>%s</S>`, attr, encodeCLIXMLString(fmt.Sprint(v))))
+	}
+}
+
+// psrpOpenError is returned when the runspace pool could not be opened
+type psrpOpenError struct {
+	err error
+}
+
+func (e psrpOpenError) Error() string {
+	return fmt.Sprintf("Error opening PowerShell runspace pool: %v", e.err)
+}
+
+func (e psrpOpenError) Unwrap() error {
+	return e.err
+}
+
+// refused returns if the server answered that it cannot open the pool, as
+// opposed to not being reachable
+func (e psrpOpenError) refused() bool {
+	var httpErr httpError
+	if errors.As(e.err, &httpErr) {
+		// errors without a WS-Management fault are from a proxy or load
+		// balancer failing to reach the WinRM service
+		return httpErr.fault != "" || httpErr.status == http.StatusUnauthorized
+	}
+	// the request failed before an answer, *url.Error is a net.Error
+	var netErr net.Error
+	if errors.As(e.err, &netErr) {
+		return false
+	}
+	msg := e.err.Error()
+	for _, s := range []string{"unknown error", "http response error", "http error 502", "http error 503", "http error 504"} {
+		if strings.HasPrefix(msg, s) {
+			return false
+		}
+	}
+	return true
+}
+
+// runspacePool runs scripts in a PowerShell runspace pool opened with the
+// PowerShell Remoting Protocol, it is shared by the copies of a client and
+// runs pipelines concurrently up to the number of runspaces
+type runspacePool struct {
+	mu sync.Mutex
+	// client the pool was configured for, used to close it
+	client    *Client
+	runspaces int
+	shell     *psrpShell
+	idle      *time.Timer
+	// disabled is set once the backend fell back to powershell.exe
+	disabled bool
+}
+
+// psrpShell is a runspace pool opened on the server
+type psrpShell struct {
+	id       string
+	shellID  string
+	objectID uint64
+	// active is the number of pipelines running, a broken pool is closed
+	// once none are left
+	active int
+	broken bool
+}
+
+// psrpResult is the output of a pipeline
+type psrpResult struct {
+	objects []interface{}
+	errors  []interface{}
+}
+
+// run executes pscript in the runspace pool, opening it when needed, and
+// returns its output as text along with the objects and error records. A
+// pool is replaced after an error other than a timeout as its state is
+// unknown. When the script is run as several pipelines those after one
+// writing an error are not run
+func (p *runspacePool) run(c *Client, pscript string) (string, string, *psrpResult, error) {
+	pipelines, err := c.pipelines(pscript)
+	if err != nil {
+		return "", "", nil, err
+	}
+
+	p.mu.Lock()
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	if p.shell == nil {
+		shell, err := openPSRPShell(c, p.runspaces)
+		if err != nil {
+			p.mu.Unlock()
+			if err == errSessionTimeout {
+				return "", "", nil, fmt.Errorf("Timeout waiting for script to complete on %s", c.ServerName)
+			}
+			return "", "", nil, psrpOpenError{err}
+		}
+		p.shell = shell
+	}
+	shell := p.shell
+	shell.active++
+	p.mu.Unlock()
+
+	result := &psrpResult{}
+	for _, pipeline := range pipelines {
+		var r *psrpResult
+		if r, err = shell.invoke(c, pipeline); err != nil {
+			break
+		}
+		result.objects = append(result.objects, r.objects...)
+		result.errors = append(result.errors, r.errors...)
+		if len(r.errors) > 0 {
+			break
+		}
+	}
+
+	p.mu.Lock()
+	shell.active--
+	if err != nil && err != errSessionTimeout {
+		shell.broken = true
+		if p.shell == shell {
+			p.shell = nil
+		}
+	}
+	if shell.active == 0 {
+		if shell.broken {
+			shell.close(p.client)
+		} else {
+			p.idle = time.AfterFunc(sessionIdleTimeout, func() {
+				p.mu.Lock()
+				defer p.mu.Unlock()
+				if p.shell == shell && shell.active == 0 {
+					shell.close(p.client)
+					p.shell = nil
+				}
+			})
+		}
+	}
+	p.mu.Unlock()
+
+	if err != nil {
+		if err == errSessionTimeout {
+			err = fmt.Errorf("Timeout waiting for script to complete on %s", c.ServerName)
+		}
+		return "", "", nil, err
+	}
+
+	var stdout, stderr strings.Builder
+	for _, o := range result.objects {
+		stdout.WriteString(formatPSValue(o) + "\r\n")
+	}
+	for _, e := range result.errors {
+		stderr.WriteString(formatErrorRecord(e))
+	}
+	return stdout.String(), stderr.String(), result, nil
+}
+
+// isDisabled returns if scripts fall back to powershell.exe
+func (p *runspacePool) isDisabled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.disabled
+}
+
+func (p *runspacePool) disable() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.disabled = true
+}
+
+// close removes the runspace pool from the server
+func (p *runspacePool) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.idle != nil {
+		p.idle.Stop()
+	}
+	if p.shell != nil {
+		p.shell.close(p.client)
+		p.shell = nil
+	}
+}
+
+// close removes the runspace pool from the server
+func (s *psrpShell) close(c *Client) {
+	message := newPSRPMessage(c, wsmanActionDelete, s.shellID)
+	message.NewBody()
+	c.post(message)
+}
+
+// openPSRPShell creates a runspace pool with the given number of runspaces
+// and waits for the server to open it
+func openPSRPShell(c *Client, runspaces int) (*psrpShell, error) {
+	s := &psrpShell{id: newUUID()}
+	message := newPSRPMessage(c, wsmanActionCreate, "", soap.NewHeaderOption("protocolversion", psrpProtocolVersion))
+	shell := message.CreateBodyElement("Shell", soap.NS_WIN_SHELL)
+	shell.SetAttr("ShellId", s.id)
+	message.CreateElement(shell, "InputStreams", soap.NS_WIN_SHELL).SetContent("stdin pr")
+	message.CreateElement(shell, "OutputStreams", soap.NS_WIN_SHELL).SetContent("stdout")
+	var creation []byte
+	for _, fragment := range append(s.fragments(psrpSessionCapability, "", psrpSessionCapabilityXML),
+		s.fragments(psrpInitRunspacePool, "", initRunspacePoolXML(runspaces))...) {
+		creation = append(creation, fragment...)
+	}
+	message.CreateElement(shell, "creationXml", nsPowerShell).SetContent(base64.StdEncoding.EncodeToString(creation))
+
+	response, err := c.post(message)
+	if err != nil {
+		return nil, err
+	}
+	if s.shellID, err = winrm.ParseOpenShellResponse(response); err != nil {
+		return nil, err
+	}
+	if err := s.waitOpened(c); err != nil {
+		s.close(c)
+		return nil, err
+	}
+	return s, nil
+}
+
+// waitOpened waits for the runspace pool state to change to opened
+func (s *psrpShell) waitOpened(c *Client) error {
+	defragmenter := psrpDefragmenter{}
+	for {
+		messages, _, err := s.receive(c, "", &defragmenter)
+		if err != nil {
+			return err
+		}
+		for _, m := range messages {
+			if m.kind != psrpRunspacePoolState {
+				continue
+			}
+			state, err := m.decode()
+			if err != nil {
+				return err
+			}
+			switch psProperty(state, "RunspaceState") {
+			case int64(psrpRunspacePoolOpened):
+				return nil
+			case int64(psrpRunspacePoolBroken), int64(psrpRunspacePoolClosed):
+				return errors.New(strings.TrimSpace(formatErrorRecord(psProperty(state, "ExceptionAsErrorRecord"))))
+			}
+		}
+	}
+}
+
+// invoke runs a pipeline and collects its output until it completes,
+// fragments of long scripts not fitting in the command request are sent as
+// input of the pipeline
+func (s *psrpShell) invoke(c *Client, pipeline []psCommand) (*psrpResult, error) {
+	pipelineID := newUUID()
+	message := newPSRPMessage(c, wsmanActionCommand, s.shellID)
+	commandLine := message.CreateBodyElement("CommandLine", soap.NS_WIN_SHELL)
+	commandLine.SetAttr("CommandId", pipelineID)
+	message.CreateElement(commandLine, "Command", soap.NS_WIN_SHELL)
+	fragments := s.fragments(psrpCreatePipeline, pipelineID, createPipelineXML(pipeline))
+	message.CreateElement(commandLine, "Arguments", soap.NS_WIN_SHELL).SetContent(base64.StdEncoding.EncodeToString(fragments[0]))
+
+	response, err := c.post(message)
+	if err != nil {
+		return nil, err
+	}
+	commandID, err := winrm.ParseExecuteCommandResponse(response)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, fragment := range fragments[1:] {
+		message := newPSRPMessage(c, wsmanActionSend, s.shellID)
+		send := message.CreateBodyElement("Send", soap.NS_WIN_SHELL)
+		stream := message.CreateElement(send, "Stream", soap.NS_WIN_SHELL)
+		stream.SetAttr("Name", "stdin")
+		stream.SetAttr("CommandId", commandID)
+		stream.SetContent(base64.StdEncoding.EncodeToString(fragment))
+		if _, err := c.post(message); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &psrpResult{}
+	defragmenter := psrpDefragmenter{}
+	for {
+		messages, done, err := s.receive(c, commandID, &defragmenter)
+		if err != nil {
+			if err == errSessionTimeout {
+				s.signal(c, commandID, wsmanSignalStop)
+			}
+			return nil, err
+		}
+
+		for _, m := range messages {
+			switch m.kind {
+			case psrpPipelineOutput:
+				v, err := m.decode()
+				if err != nil {
+					return nil, err
+				}
+				result.objects = append(result.objects, v)
+			case psrpErrorRecord:
+				v, err := m.decode()
+				if err != nil {
+					return nil, err
+				}
+				result.errors = append(result.errors, v)
+			case psrpPipelineState:
+				state, err := m.decode()
+				if err != nil {
+					return nil, err
+				}
+				switch psProperty(state, "PipelineState") {
+				case int64(psrpPipelineFailed):
+					result.errors = append(result.errors, psProperty(state, "ExceptionAsErrorRecord"))
+					done = true
+				case int64(psrpPipelineStopped):
+					return nil, errors.New("PowerShell pipeline was stopped")
+				case int64(psrpPipelineCompleted):
+					done = true
+				}
+			}
+		}
+		if done {
+			return result, nil
+		}
+	}
+}
+
+// receive waits for the output of the runspace pool, or of a pipeline when
+// commandID is set, until the deadline of the client
+func (s *psrpShell) receive(c *Client, commandID string, defragmenter *psrpDefragmenter) ([]psrpMessage, bool, error) {
+	for {
+		client := c
+		if !c.deadline.IsZero() {
+			remaining := time.Until(c.deadline)
+			if remaining <= 0 {
+				return nil, false, errSessionTimeout
+			}
+			client = c.WithTimeout(remaining)
+		}
+
+		message := newPSRPMessage(client, wsmanActionReceive, s.shellID, soap.NewHeaderOption("WSMAN_CMDSHELL_OPTION_KEEPALIVE", "TRUE"))
+		receive := message.CreateBodyElement("Receive", soap.NS_WIN_SHELL)
+		stream := message.CreateElement(receive, "DesiredStream", soap.NS_WIN_SHELL)
+		if commandID != "" {
+			stream.SetAttr("CommandId", commandID)
+		}
+		stream.SetContent("stdout")
+
+		response, err := client.post(message)
+		if err != nil {
+			// the server holds the request for the operation timeout
+			// waiting for output, ask again when none was written
+			if strings.Contains(err.Error(), "OperationTimeout") {
+				continue
+			}
+			return nil, false, err
+		}
+
+		var r psrpReceiveResponse
+		if err := xml.Unmarshal([]byte(response), &r); err != nil {
+			return nil, false, fmt.Errorf("Invalid receive response: %v", err)
+		}
+		var messages []psrpMessage
+		for _, stream := range r.Streams {
+			data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(stream.Content))
+			if err != nil {
+				return nil, false, fmt.Errorf("Invalid receive response: %v", err)
+			}
+			m, err := defragmenter.add(data)
+			if err != nil {
+				return nil, false, err
+			}
+			messages = append(messages, m...)
+		}
+		return messages, strings.HasSuffix(r.CommandState.State, "CommandState/Done"), nil
+	}
+}
+
+// signal sends code to a pipeline, failures are ignored as the pipeline is
+// abandoned afterwards
+func (s *psrpShell) signal(c *Client, commandID, code string) {
+	message := newPSRPMessage(c, wsmanActionSignal, s.shellID)
+	signal := message.CreateBodyElement("Signal", soap.NS_WIN_SHELL)
+	signal.SetAttr("CommandId", commandID)
+	message.CreateElement(signal, "Code", soap.NS_WIN_SHELL).SetContent(code)
+	c.post(message)
+}
+
+// fragments returns a PSRP message split into fragments
+func (s *psrpShell) fragments(messageType uint32, pipelineID, data string) [][]byte {
+	message := make([]byte, 40, 43+len(data))
+	binary.LittleEndian.PutUint32(message[0:], psrpDestinationServer)
+	binary.LittleEndian.PutUint32(message[4:], messageType)
+	copy(message[8:24], guidBytes(s.id))
+	copy(message[24:40], guidBytes(pipelineID))
+	message = append(message, 0xEF, 0xBB, 0xBF)
+	message = append(message, data...)
+
+	objectID := atomic.AddUint64(&s.objectID, 1)
+	var out [][]byte
+	for fragmentID := uint64(0); len(message) > 0; fragmentID++ {
+		n := len(message)
+		if n > psrpMaxFragment {
+			n = psrpMaxFragment
+		}
+		header := make([]byte, 21)
+		binary.BigEndian.PutUint64(header[0:], objectID)
+		binary.BigEndian.PutUint64(header[8:], fragmentID)
+		if fragmentID == 0 {
+			header[16] |= 1
+		}
+		if n == len(message) {
+			header[16] |= 2
+		}
+		binary.BigEndian.PutUint32(header[17:], uint32(n))
+		out = append(out, append(header, message[:n]...))
+		message = message[n:]
+	}
+	return out
+}
+
+// psrpReceiveResponse is the body of a Receive response
+type psrpReceiveResponse struct {
+	Streams []struct {
+		Content string `xml:",chardata"`
+	} `xml:"Body>ReceiveResponse>Stream"`
+	CommandState struct {
+		State string `xml:"State,attr"`
+	} `xml:"Body>ReceiveResponse>CommandState"`
+}
+
+// psrpMessage is a message received from the server
+type psrpMessage struct {
+	kind uint32
+	data []byte
+}
+
+// decode deserialises the object held by the message
+func (m psrpMessage) decode() (interface{}, error) {
+	return decodeCLIXML(m.data)
+}
+
+// psrpDefragmenter joins the fragments of received messages
+type psrpDefragmenter struct {
+	partial map[uint64][]byte
+}
+
+// add reads the fragments in data and returns the messages they complete
+func (d *psrpDefragmenter) add(data []byte) ([]psrpMessage, error) {
+	if d.partial == nil {
+		d.partial = map[uint64][]byte{}
+	}
+
+	var messages []psrpMessage
+	for len(data) > 0 {
+		if len(data) < 21 {
+			return nil, errors.New("Invalid PSRP fragment")
+		}
+		objectID := binary.BigEndian.Uint64(data[0:])
+		flags := data[16]
+		length := int(binary.BigEndian.Uint32(data[17:]))
+		if len(data) < 21+length {
+			return nil, errors.New("Invalid PSRP fragment")
+		}
+		if flags&1 != 0 {
+			d.partial[objectID] = nil
+		}
+		d.partial[objectID] = append(d.partial[objectID], data[21:21+length]...)
+		data = data[21+length:]
+
+		if flags&2 == 0 {
+			continue
+		}
+		message := d.partial[objectID]
+		delete(d.partial, objectID)
+		if len(message) < 40 {
+			return nil, errors.New("Invalid PSRP message")
+		}
+		body := message[40:]
+		if len(body) >= 3 && body[0] == 0xEF && body[1] == 0xBB && body[2] == 0xBF {
+			body = body[3:]
+		}
+		messages = append(messages, psrpMessage{kind: binary.LittleEndian.Uint32(message[4:]), data: body})
+	}
+	return messages, nil
+}
+
+// formatErrorRecord renders an error record as PowerShell writes it to
+// stderr, so errors read the same as with the powershell.exe backend
+func formatErrorRecord(v interface{}) string {
+	record, ok := v.(*PSObject)
+	if !ok {
+		return formatPSValue(v) + "\r\n"
+	}
+
+	message := record.ToString
+	if details, ok := psProperty(record, "ErrorDetails_Message").(string); ok && details != "" {
+		message = details
+	} else if exception, ok := psProperty(psProperty(record, "Exception"), "Message").(string); ok && exception != "" {
+		message = exception
+	}
+	return fmt.Sprintf("%s\r\n    + CategoryInfo          : %s\r\n    + FullyQualifiedErrorId : %s\r\n",
+		message, formatPSValue(psProperty(record, "ErrorCategory_Message")), formatPSValue(psProperty(record, "FullyQualifiedErrorId")))
+}
+
+// psProperty returns a property of a deserialised object, or nil
+func psProperty(v interface{}, name string) interface{} {
+	if o, ok := v.(*PSObject); ok {
+		return o.Properties[name]
+	}
+	return nil
+}
+
+// newPSRPMessage starts a request to the PowerShell plugin
+func newPSRPMessage(c *Client, action, shellID string, options ...*soap.HeaderOption) *soap.SoapMessage {
+	message := soap.NewMessage()
+	header := message.Header().
+		To(c.url).
+		ReplyTo(wsmanAnonymous).
+		MaxEnvelopeSize(c.Client.Parameters.EnvelopeSize).
+		Id("uuid:" + newUUID()).
+		Locale(c.Client.Parameters.Locale).
+		Timeout(c.Client.Parameters.Timeout).
+		Action(action).
+		ResourceURI(c.resourceURI())
+	if shellID != "" {
+		header.ShellId(shellID)
+	}
+	for _, option := range options {
+		header.AddOption(option)
+	}
+	header.Build()
+	return message
+}
+
+// post sends a request with the transport of the client
+func (c *Client) post(message *soap.SoapMessage) (string, error) {
+	defer message.Free()
+	return c.transport.Post(c.Client, message)
+}
+
+// newUUID returns a random UUID
+func newUUID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return strings.ToUpper(fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]))
+}
+
+// guidBytes returns id in the byte order of a .NET Guid, an empty id is
+// the nil GUID
+func guidBytes(id string) []byte {
+	b, err := hex.DecodeString(strings.Replace(id, "-", "", -1))
+	if err != nil || len(b) != 16 {
+		return make([]byte, 16)
+	}
+	b[0], b[1], b[2], b[3] = b[3], b[2], b[1], b[0]
+	b[4], b[5] = b[5], b[4]
+	b[6], b[7] = b[7], b[6]
+	return b
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestClientPSRP(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		if strings.Contains(script, "fail") {
+			return "", "Record not found WIN32 9714"
+		}
+		return script, ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	c.Backend = "psrp"
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	scripts := []string{"one", "two", "$a = 'x_x0041_' -and \"<b>\" & $c\r\n\x01"}
+	for _, script := range scripts {
+		output, err := client.ExecutePowerShellScript(script)
+		if err != nil {
+			t.Fatalf("Error running %s: %s", script, err)
+		}
+		if objects := output.Objects(); len(objects) != 1 || objects[0] != script {
+			t.Fatalf("Expected output %q, got %#v", script, objects)
+		}
+	}
+	if shells, closed := server.Shells(); shells != 1 || closed != 0 {
+		t.Fatalf("Expected scripts to run in one runspace pool, created %d and closed %d", shells, closed)
+	}
+	if ran := server.Scripts(); len(ran) != 3 || ran[2] != scripts[2] {
+		t.Fatalf("Unexpected scripts: %q", ran)
+	}
+
+	_, err = client.ExecutePowerShellScript("fail")
+	if err == nil || !strings.Contains(err.Error(), "Record not found WIN32 9714") || !strings.Contains(err.Error(), "FullyQualifiedErrorId : PSRPTest") {
+		t.Fatalf("Expected error record, got %v", err)
+	}
+
+	client.Close()
+	if _, closed := server.Shells(); closed != 1 {
+		t.Fatal("Expected the runspace pool to be closed")
+	}
+}
+
+func TestClientPSRP_Fallback(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return "ok\r\n", ""
+	})
+	server.noPSRP = true
+	defer server.Close()
+
+	c := server.config()
+	c.Backend = "psrp"
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if _, err := client.ExecutePowerShellScript("one"); err == nil || !strings.Contains(err.Error(), "runspace pool") {
+		t.Fatalf("Expected runspace pool error, got %v", err)
+	}
+
+	c.Backend = "auto"
+	client, err = testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	for _, script := range []string{"two", "three"} {
+		if _, err := client.ExecutePowerShellScript(script); err != nil {
+			t.Fatalf("Error running %s: %s", script, err)
+		}
+	}
+	if scripts := server.Scripts(); len(scripts) != 2 || !strings.Contains(scripts[1], "three") {
+		t.Fatalf("Expected scripts to run with powershell.exe, got %q", scripts)
+	}
+}
+
+func TestPSRPOpenErrorRefused(t *testing.T) {
+	cases := []struct {
+		name    string
+		err     error
+		refused bool
+	}{
+		{"fault", newHTTPError(500, []byte(testResourceNotFoundFault)), true},
+		{"gateway", newHTTPError(502, []byte("Ungültiges Gateway")), false},
+		{"proxy page", httpError{status: 403, message: "http response error: 403 - invalid content type"}, false},
+		{"unreachable", fmt.Errorf("unknown error %w", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}), false},
+	}
+	for _, tc := range cases {
+		if refused := (psrpOpenError{tc.err}).refused(); refused != tc.refused {
+			t.Errorf("%s: expected refused %t, got %t", tc.name, tc.refused, refused)
+		}
+	}
+}
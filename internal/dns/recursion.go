@@ -0,0 +1,160 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// RecursionScope holds a recursion scope and the forwarders it uses
+type RecursionScope struct {
+	Name            string
+	Forwarder       []string
+	EnableRecursion bool
+}
+
+// ReadRecursionScope retrieves a recursion scope from the DNS server
+func (c *Client) ReadRecursionScope(name string) (RecursionScope, error) {
+	const tmplpscript = `
+$s = Get-DnsServerRecursionScope -Name '{{ .Name }}' -ErrorAction SilentlyContinue
+if ($s) {
+	New-Object PSObject -Property @{
+		Name = $s.Name
+		Forwarder = @($s.Forwarder | %{ $_.ToString() })
+		EnableRecursion = $s.EnableRecursion
+	} | ConvertTo-Json
+}
+`
+	// restricted endpoints return the scope as an object
+	const tmplpscriptRestricted = `
+Get-DnsServerRecursionScope -Name '{{ .Name }}' -ErrorAction SilentlyContinue
+`
+	scope := RecursionScope{Name: name}
+	tmpl := tmplpscript
+	if c.restricted() {
+		tmpl = tmplpscriptRestricted
+	}
+
+	pscript, err := tmplExec(scope, tmpl)
+	if err != nil {
+		return RecursionScope{}, fmt.Errorf("Creating template: %v", err)
+	}
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return RecursionScope{}, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+	if c.restricted() {
+		if len(output.objects) == 0 {
+			return RecursionScope{}, notFoundError{"Recursion scope", name}
+		}
+		o := output.objects[0]
+		return RecursionScope{
+			Name:            formatPSValue(psProperty(o, "Name")),
+			Forwarder:       psStrings(psProperty(o, "Forwarder")),
+			EnableRecursion: psProperty(o, "EnableRecursion") == true,
+		}, nil
+	}
+	if output.stdout == "" {
+		return RecursionScope{}, notFoundError{"Recursion scope", name}
+	}
+	if err := json.Unmarshal([]byte(output.stdout), &scope); err != nil {
+		return RecursionScope{}, fmt.Errorf("Unmarshalling response: %v", err)
+	}
+
+	return scope, nil
+}
+
+// CreateRecursionScope adds a new recursion scope to the DNS server
+func (c *Client) CreateRecursionScope(scope RecursionScope) (RecursionScope, error) {
+	const tmplpscript = `
+Add-DnsServerRecursionScope -Name '{{ .Name }}' -EnableRecursion ${{ .EnableRecursion }}{{ if .Forwarder }} -Forwarder {{ range $i, $f := .Forwarder }}{{ if $i }},{{ end }}'{{ $f }}'{{ end }}{{ end }}
+`
+	exists, err := c.RecursionScopeExist(scope.Name)
+	if err != nil {
+		return RecursionScope{}, err
+	}
+	if exists {
+		return RecursionScope{}, fmt.Errorf("Recursion scope already exists: %s", scope.Name)
+	}
+
+	pscript, err := tmplExec(scope, tmplpscript)
+	if err != nil {
+		return RecursionScope{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if err := c.executeChange(pscript, func() (bool, error) { return c.RecursionScopeExist(scope.Name) }); err != nil {
+		return RecursionScope{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return c.readRecursionScopeAfterChange(scope.Name)
+}
+
+// UpdateRecursionScope changes the forwarders and recursion setting of an
+// existing recursion scope, an empty list of forwarders clears them as
+// leaving out -Forwarder would keep the ones set
+func (c *Client) UpdateRecursionScope(scope RecursionScope) (RecursionScope, error) {
+	const tmplpscript = `
+Set-DnsServerRecursionScope -Name '{{ .Name }}' -EnableRecursion ${{ .EnableRecursion }} -Forwarder {{ if .Forwarder }}{{ range $i, $f := .Forwarder }}{{ if $i }},{{ end }}'{{ $f }}'{{ end }}{{ else }}@(){{ end }}
+`
+	exists, err := c.RecursionScopeExist(scope.Name)
+	if err != nil {
+		return RecursionScope{}, err
+	}
+	if !exists {
+		return RecursionScope{}, notFoundError{"Recursion scope", scope.Name}
+	}
+
+	pscript, err := tmplExec(scope, tmplpscript)
+	if err != nil {
+		return RecursionScope{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return RecursionScope{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return c.readRecursionScopeAfterChange(scope.Name)
+}
+
+// readRecursionScopeAfterChange reads a recursion scope that was just added
+// or changed
+func (c *Client) readRecursionScopeAfterChange(name string) (RecursionScope, error) {
+	var scope RecursionScope
+	err := c.readAfterChange(func() error {
+		var err error
+		scope, err = c.ReadRecursionScope(name)
+		return err
+	})
+	return scope, err
+}
+
+// DeleteRecursionScope removes a recursion scope from the DNS server
+func (c *Client) DeleteRecursionScope(name string) error {
+	const tmplpscript = `
+Remove-DnsServerRecursionScope -Name '{{ .Name }}' -Force
+`
+	exists, err := c.RecursionScopeExist(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return notFoundError{"Recursion scope", name}
+	}
+
+	pscript, err := tmplExec(RecursionScope{Name: name}, tmplpscript)
+	if err != nil {
+		return fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return nil
+}
+
+// RecursionScopeExist returns if the recursion scope exists, an error
+// reading it is returned rather than taken to mean it does not exist
+func (c *Client) RecursionScopeExist(name string) (bool, error) {
+	_, err := c.ReadRecursionScope(name)
+	if IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
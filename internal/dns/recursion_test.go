@@ -0,0 +1,87 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientRecursionScopeExist(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		if strings.Contains(script, "'internal'") {
+			return `{"Name":"internal","Forwarder":["10.0.0.1"],"EnableRecursion":true}`, ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	client, err := testConfigure(server.config())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	if exists, err := client.RecursionScopeExist("internal"); !exists || err != nil {
+		t.Fatalf("Expected the scope to exist, got %t and %v", exists, err)
+	}
+	if exists, err := client.RecursionScopeExist("missing"); exists || err != nil {
+		t.Fatalf("Expected the scope not to exist, got %t and %v", exists, err)
+	}
+	if err := client.DeleteRecursionScope("missing"); !IsNotFound(err) {
+		t.Fatalf("Expected a not found error deleting the missing scope, got %v", err)
+	}
+	server.Fail(1)
+	if exists, err := client.RecursionScopeExist("internal"); exists || err == nil {
+		t.Fatalf("Expected the failed request to be an error, got %t and %v", exists, err)
+	}
+}
+
+func TestClientUpdateRecursionScope_ClearForwarders(t *testing.T) {
+	for _, configuration := range []string{"", "DnsOperators"} {
+		server := newTestWinRM(t, func(script string) (string, string) {
+			switch {
+			case strings.HasPrefix(script, "Get-DnsServerRecursionScope"):
+				// restricted endpoints return the scope as an object
+				return `<Obj RefId="0"><Props><S N="Name">internal</S><B N="EnableRecursion">true</B></Props></Obj>`, ""
+			case strings.Contains(script, "Get-DnsServerRecursionScope"):
+				return `{"Name":"internal","Forwarder":[],"EnableRecursion":true}`, ""
+			}
+			return "", ""
+		})
+		if configuration != "" {
+			server.configuration = "http://schemas.microsoft.com/powershell/" + configuration
+		}
+
+		c := server.config()
+		c.ConfigurationName = configuration
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		for _, forwarders := range [][]string{{"10.0.0.1", "10.0.0.2"}, nil} {
+			if _, err := client.UpdateRecursionScope(RecursionScope{Name: "internal", Forwarder: forwarders, EnableRecursion: true}); err != nil {
+				t.Fatalf("%q: Error: %s", configuration, err)
+			}
+		}
+
+		var sets []string
+		for _, script := range server.Scripts() {
+			if strings.Contains(script, "Set-DnsServerRecursionScope") {
+				sets = append(sets, strings.TrimSpace(script))
+			}
+		}
+		expected := []string{
+			"Set-DnsServerRecursionScope -Name 'internal' -EnableRecursion $true -Forwarder '10.0.0.1','10.0.0.2'",
+			"Set-DnsServerRecursionScope -Name 'internal' -EnableRecursion $true -Forwarder @()",
+		}
+		if configuration != "" {
+			expected = []string{
+				"Set-DnsServerRecursionScope -Name 'internal' -EnableRecursion:$true -Forwarder '10.0.0.1','10.0.0.2'",
+				"Set-DnsServerRecursionScope -Name 'internal' -EnableRecursion:$true -Forwarder @()",
+			}
+		}
+		if strings.Join(sets, "\n") != strings.Join(expected, "\n") {
+			t.Errorf("%q: expected %q, got %q", configuration, expected, sets)
+		}
+		client.Close()
+		server.Close()
+	}
+}
@@ -0,0 +1,130 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+const (
+	// defaultReplicationTimeout and defaultReplicationInterval are used
+	// when waiting for replication without a timeout or interval set
+	defaultReplicationTimeout  = 5 * time.Minute
+	defaultReplicationInterval = 10 * time.Second
+)
+
+// replicationCheck is the data for the script checking servers for a record
+type replicationCheck struct {
+	Record
+	Servers []string
+	Present bool
+}
+
+// waitForReplication polls ReplicationServers until rec is present on all of
+// them, or gone when present is false. The change has been applied already,
+// so the servers still lagging once ReplicationTimeout passes are logged as a
+// warning instead of failing it
+func (c *Client) waitForReplication(rec Record, present bool) {
+	if len(c.ReplicationServers) == 0 {
+		return
+	}
+	timeout := c.ReplicationTimeout
+	if timeout <= 0 {
+		timeout = defaultReplicationTimeout
+	}
+	interval := c.ReplicationInterval
+	if interval <= 0 {
+		interval = defaultReplicationInterval
+	}
+	deadline := time.Now().Add(timeout)
+	if !c.deadline.IsZero() && c.deadline.Before(deadline) {
+		deadline = c.deadline
+	}
+
+	check := replicationCheck{Record: rec, Servers: c.ReplicationServers, Present: present}
+	check.Value = strings.TrimSuffix(rec.Value, ".")
+	start := time.Now()
+	for {
+		replicated, err := c.replicatedServers(check)
+		if err != nil {
+			log.Printf("[WARN] Cannot check the replication of %s.%s: %v", rec.Name, rec.Dnszone, err)
+			return
+		}
+		var lagging []string
+		for _, server := range check.Servers {
+			if !replicated[strings.ToLower(server)] {
+				lagging = append(lagging, server)
+			}
+		}
+		if len(lagging) == 0 {
+			log.Printf("[DEBUG] %s.%s replicated to all servers after %s", rec.Name, rec.Dnszone, time.Since(start))
+			return
+		}
+
+		if time.Now().Add(interval).After(deadline) {
+			change := "Record"
+			if !present {
+				change = "Removal of record"
+			}
+			log.Printf("[WARN] %s %s.%s was not replicated to %s after %s", change, rec.Name, rec.Dnszone,
+				strings.Join(lagging, ", "), time.Since(start).Round(time.Second))
+			return
+		}
+		log.Printf("[DEBUG] Waiting for %s.%s to replicate to %s", rec.Name, rec.Dnszone, strings.Join(lagging, ", "))
+		check.Servers = lagging
+		time.Sleep(interval)
+	}
+}
+
+// replicatedServers returns the lower case names of the servers of check
+// that have the record, or no longer have it when check.Present is false
+func (c *Client) replicatedServers(check replicationCheck) (map[string]bool, error) {
+	const tmplpscript = `
+foreach ($server in @({{ range $i, $s := .Servers }}{{ if $i }}, {{ end }}'{{ $s }}'{{ end }})) {
+	$record = Get-DnsServerResourceRecord -ComputerName $server -ZoneName {{ .Dnszone }} -Name {{ .Name }} -ErrorAction SilentlyContinue | ?{$_.HostName -eq '{{ .Name }}'{{ if eq .Type "CNAME" }} -and "$($_.RecordData.HostNameAlias)".TrimEnd('.') -eq '{{ .Value }}'{{ else }} -and "$($_.RecordData.IPv4Address)" -eq '{{ .Value }}'{{ end }}}
+	if ({{ if .Present }}$record{{ else }}-not $record{{ end }}) { $server }
+}
+`
+	// restricted endpoints cannot loop over the servers or filter the
+	// records, each server is asked for the records of the name instead
+	const tmplpscriptRestricted = `
+Get-DnsServerResourceRecord -ComputerName '{{ index .Servers 0 }}' -ZoneName {{ .Dnszone }} -Name {{ .Name }} -ErrorAction SilentlyContinue
+`
+	replicated := map[string]bool{}
+	if !c.restricted() {
+		pscript, err := tmplExec(check, tmplpscript)
+		if err != nil {
+			return nil, fmt.Errorf("Creating template: %v", err)
+		}
+		output, err := c.ExecutePowerShellScript(pscript)
+		if err != nil {
+			return nil, fmt.Errorf("Checking replication: %v", err)
+		}
+		for _, server := range strings.Fields(output.stdout) {
+			replicated[strings.ToLower(server)] = true
+		}
+		return replicated, nil
+	}
+
+	for _, server := range check.Servers {
+		pscript, err := tmplExec(replicationCheck{Record: check.Record, Servers: []string{server}}, tmplpscriptRestricted)
+		if err != nil {
+			return nil, fmt.Errorf("Creating template: %v", err)
+		}
+		output, err := c.ExecutePowerShellScript(pscript)
+		if err != nil {
+			return nil, fmt.Errorf("Checking replication: %v", err)
+		}
+		found := false
+		for _, r := range recordObjects(output.objects, check.Dnszone) {
+			if strings.EqualFold(r.Name, check.Name) && r.Type == check.Type && strings.EqualFold(strings.TrimSuffix(r.Value, "."), check.Value) {
+				found = true
+			}
+		}
+		if found == check.Present {
+			replicated[strings.ToLower(server)] = true
+		}
+	}
+	return replicated, nil
+}
@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientWaitForReplication(t *testing.T) {
+	const record = `{"HostName":"test99","RecordType":"A","RecordData":{"CimInstanceProperties":"IPv4Address = \"10.0.0.99\""},"TimeToLive":{"TotalSeconds":600}}`
+	var mu sync.Mutex
+	created := false
+	polls := 0
+	// polls after which each server has the record
+	replicatedAfter := map[string]int{"dc2": 1, "dc3": 3}
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case strings.Contains(script, "-ComputerName $server"):
+			polls++
+			var replicated []string
+			for _, s := range regexp.MustCompile(`'(dc\d)'`).FindAllStringSubmatch(script, -1) {
+				if polls > replicatedAfter[s[1]] {
+					replicated = append(replicated, s[1])
+				}
+			}
+			return strings.Join(replicated, "\r\n"), ""
+		case strings.Contains(script, "Add-DnsServerResourceRecord"):
+			created = true
+		case created:
+			return record, ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	cases := []struct {
+		timeout time.Duration
+		polls   int
+		warning string
+	}{
+		{time.Second, 4, ""},
+		{25 * time.Millisecond, 0, "[WARN] Record test99.test.local was not replicated to dc3 after"},
+	}
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	for _, tc := range cases {
+		logged.Reset()
+		created, polls = false, 0
+
+		c := server.config()
+		c.ReplicationServers = []string{"dc2", "dc3"}
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		client.ReplicationTimeout = tc.timeout
+		client.ReplicationInterval = 10 * time.Millisecond
+
+		// a record lagging behind is a warning, the record has been created
+		records, err := client.CreateRecord(Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99", TTL: 600})
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if tc.warning != "" && !strings.Contains(logged.String(), tc.warning) {
+			t.Fatalf("Expected warning %q, got %q", tc.warning, logged.String())
+		}
+		if len(records) != 1 || records[0].ID != "test.local|test99|10.0.0.99" {
+			t.Fatalf("Expected the created record, got %v", records)
+		}
+		mu.Lock()
+		if tc.polls > 0 && polls != tc.polls {
+			t.Errorf("Expected %d checks, ran %d", tc.polls, polls)
+		}
+		mu.Unlock()
+	}
+}
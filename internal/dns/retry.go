@@ -0,0 +1,205 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// defaultRetryBackoff is the wait before the first retry, it doubles
+	// after each attempt up to maxRetryBackoff
+	defaultRetryBackoff = time.Second
+	maxRetryBackoff     = 30 * time.Second
+
+	// maxNotFoundRetries bounds the retries of a read following a create or
+	// update while the object is not found yet
+	maxNotFoundRetries = 3
+)
+
+// transientWin32Errors are the codes of DNS server errors seen while Active
+// Directory replicates a change made on another domain controller
+var transientWin32Errors = []int{
+	9601, // DNS_ERROR_ZONE_DOES_NOT_EXIST
+	1722, // RPC_S_SERVER_UNAVAILABLE
+	8206, // ERROR_DS_BUSY
+	9002, // DNS_ERROR_RCODE_SERVER_FAILURE
+}
+
+// transientErrors are parts of error messages for failures that are
+// expected to succeed when tried again, they are matched when the error
+// carries no code, as with the powershell.exe backend, or comes from a
+// library wrapping it as text
+var transientErrors = []string{
+	// WinRM service and connection failures
+	"http error 50",
+	"http response error: 50",
+	"connection reset",
+	"connection refused",
+	"broken pipe",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"Client.Timeout exceeded",
+	"PowerShell session ended",
+	// DNS server errors seen while Active Directory replicates a change made
+	// on another domain controller
+	"WIN32 9601", // DNS_ERROR_ZONE_DOES_NOT_EXIST
+	"WIN32 1722", // RPC_S_SERVER_UNAVAILABLE
+	"WIN32 8206", // ERROR_DS_BUSY
+	"WIN32 9002", // DNS_ERROR_RCODE_SERVER_FAILURE
+}
+
+// notFoundErrors are parts of the errors the DnsServer cmdlets fail with
+// when the object asked for does not exist
+var notFoundErrors = []string{
+	"WIN32 9714", // DNS_ERROR_NAME_DOES_NOT_EXIST
+	"ObjectNotFound",
+	// records read by ReadRecords and ReadRecord
+	"No Record found",
+	"Record not found",
+}
+
+// notFoundError is returned when the DNS server does not have the object
+// read, such as a policy or recursion scope
+type notFoundError struct {
+	kind string
+	name string
+}
+
+func (e notFoundError) Error() string {
+	return fmt.Sprintf("%s not found: %s", e.kind, e.name)
+}
+
+// IsNotFound returns if err is from reading an object that does not exist,
+// rather than from failing to read it
+func IsNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	if _, ok := err.(notFoundError); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, s := range notFoundErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isTransientError returns if err is worth retrying, TLS verification
+// failures and running out of time are permanent
+func isTransientError(err error) bool {
+	if err == nil || isTLSError(err) || isTimeoutError(err) {
+		return false
+	}
+	if isEOF(err) || isConnectionReset(err) || errors.Is(err, syscall.ECONNREFUSED) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var httpErr httpError
+	if errors.As(err, &httpErr) && httpErr.status >= 500 {
+		return true
+	}
+	var scriptErr scriptError
+	if errors.As(err, &scriptErr) {
+		for _, code := range transientWin32Errors {
+			if scriptErr.hasWin32Error(code) {
+				return true
+			}
+		}
+	}
+
+	msg := err.Error()
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// isEOF returns if err is from the server closing the connection before
+// answering
+func isEOF(err error) bool {
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// isConnectionReset returns if err is from the server or a device on the
+// way dropping the connection
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// retry runs operation until it succeeds, fails with a permanent error or
+// has been retried MaxRetries times, the wait between attempts doubles after
+// each transient error and never runs past the deadline set by WithTimeout
+func (c *Client) retry(operation func() error) error {
+	return c.retryIf(operation, isTransientError)
+}
+
+// readAfterChange runs read, which follows creating or updating an object,
+// and retries it up to maxNotFoundRetries times while the object is not
+// found, the server answering may not have the change yet. Transient errors
+// are retried by the read itself
+func (c *Client) readAfterChange(read func() error) error {
+	notFound := 0
+	return c.retryIf(read, func(err error) bool {
+		notFound++
+		return IsNotFound(err) && notFound <= maxNotFoundRetries
+	})
+}
+
+// retryIf is retry with transient deciding which errors are retried
+func (c *Client) retryIf(operation func() error, transient func(error) bool) error {
+	backoff := c.RetryBackoff
+	if backoff <= 0 {
+		backoff = defaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := operation()
+		if err == nil || attempt >= c.MaxRetries || !transient(err) {
+			return err
+		}
+		if !c.deadline.IsZero() && time.Now().Add(backoff).After(c.deadline) {
+			return err
+		}
+
+		log.Printf("[WARN] Retrying in %s after transient error from %s: %v", backoff, c.name(), err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxRetryBackoff {
+			backoff = maxRetryBackoff
+		}
+	}
+}
+
+// executeChange runs a script that cannot safely run twice, applied is
+// checked before each retry so a change made by an attempt whose response
+// was lost is not repeated
+func (c *Client) executeChange(pscript string, applied func() (bool, error)) error {
+	first := true
+	return c.retry(func() error {
+		if !first {
+			done, err := applied()
+			if err != nil {
+				return err
+			}
+			if done {
+				return nil
+			}
+		}
+		first = false
+		_, err := c.execute(pscript)
+		return err
+	})
+}
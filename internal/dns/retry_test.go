@@ -0,0 +1,204 @@
+package dns
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClientRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		failures int
+		stderr   string
+		retries  int
+		calls    int
+		err      bool
+	}{
+		{"zone not replicated yet", 2, "+ FullyQualifiedErrorId : WIN32 9601,Add-DnsServerResourceRecord", 3, 3, false},
+		{"retries exhausted", 5, "+ FullyQualifiedErrorId : WIN32 9601,Add-DnsServerResourceRecord", 3, 4, true},
+		{"retries disabled", 1, "+ FullyQualifiedErrorId : WIN32 9601,Add-DnsServerResourceRecord", 0, 1, true},
+		{"permanent error", 1, "+ FullyQualifiedErrorId : WIN32 9714,Get-DnsServerResourceRecord", 3, 1, true},
+	}
+
+	for _, tc := range cases {
+		calls := 0
+		server := newTestWinRM(t, func(script string) (string, string) {
+			calls++
+			if calls <= tc.failures {
+				return "", tc.stderr
+			}
+			return "ok", ""
+		})
+
+		c := server.config()
+		c.MaxRetries = tc.retries
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		client.RetryBackoff = time.Millisecond
+
+		_, err = client.ExecutePowerShellScript("Get-DnsServerZone")
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+		}
+		if calls != tc.calls {
+			t.Errorf("%s: expected %d attempts, got %d", tc.name, tc.calls, calls)
+		}
+		server.Close()
+	}
+}
+
+func TestClientRetry_ServerError(t *testing.T) {
+	server := newTestWinRM(t, func(script string) (string, string) {
+		return "ok", ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	c.MaxRetries = 3
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	client.RetryBackoff = time.Millisecond
+
+	server.Fail(2)
+	if _, err := client.ExecutePowerShellScript("Get-DnsServerZone"); err != nil {
+		t.Fatalf("Expected request to succeed after server errors, got %s", err)
+	}
+}
+
+func TestClientRetry_CreateRecordNotRepeated(t *testing.T) {
+	const record = `{"HostName":"test99","RecordType":"A","RecordData":{"CimInstanceProperties":"IPv4Address = \"10.0.0.99\""},"TimeToLive":{"TotalSeconds":600}}`
+	created := false
+	adds := 0
+	server := newTestWinRM(t, func(script string) (string, string) {
+		switch {
+		case strings.Contains(script, "Add-DnsServerResourceRecord"):
+			// the record is added but the connection to the domain
+			// controller fails before the command returns
+			adds++
+			created = true
+			return "", "+ FullyQualifiedErrorId : WIN32 1722,Add-DnsServerResourceRecord"
+		case created:
+			return record, ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	c.MaxRetries = 3
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	client.RetryBackoff = time.Millisecond
+
+	records, err := client.CreateRecord(Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99", TTL: 600})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if adds != 1 {
+		t.Fatalf("Expected the record to be added once, added %d times", adds)
+	}
+	if records[0].ID != "test.local|test99|10.0.0.99" {
+		t.Fatalf("Unexpected record: %v", records[0])
+	}
+}
+
+func TestClientCreateRecord_ReadAfterCreate(t *testing.T) {
+	const record = `{"HostName":"test99","RecordType":"A","RecordData":{"CimInstanceProperties":"IPv4Address = \"10.0.0.99\""},"TimeToLive":{"TotalSeconds":600}}`
+	cases := []struct {
+		name   string
+		misses int
+		err    bool
+	}{
+		{"found after the server has the record", 2, false},
+		{"not found after retrying", maxNotFoundRetries + 1, true},
+	}
+
+	for _, tc := range cases {
+		added := false
+		misses := 0
+		server := newTestWinRM(t, func(script string) (string, string) {
+			switch {
+			case strings.Contains(script, "Add-DnsServerResourceRecord"):
+				added = true
+			case added && misses < tc.misses:
+				// the read is answered by a server the record has not
+				// reached yet
+				misses++
+			case added:
+				return record, ""
+			}
+			return "", ""
+		})
+
+		c := server.config()
+		c.MaxRetries = 5
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		client.RetryBackoff = time.Millisecond
+
+		_, err = client.CreateRecord(Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99", TTL: 600})
+		if (err != nil) != tc.err {
+			t.Errorf("%s: expected error %t, got %v", tc.name, tc.err, err)
+		}
+		server.Close()
+	}
+}
+
+func TestIsTransientError_EOF(t *testing.T) {
+	cases := []struct {
+		err       error
+		transient bool
+	}{
+		{fmt.Errorf("Error executing script: %w", fmt.Errorf("unknown error %w", io.EOF)), true},
+		{fmt.Errorf("Error executing script: %w", io.ErrUnexpectedEOF), true},
+		// a script failing with EOF in its message did not lose the
+		// connection
+		{scriptError{stderr: "Cannot find zone EOF.test.local"}, false},
+	}
+	for _, tc := range cases {
+		if transient := isTransientError(tc.err); transient != tc.transient {
+			t.Errorf("%v: expected transient %t, got %t", tc.err, tc.transient, transient)
+		}
+	}
+}
+
+func TestIsTransientError_Typed(t *testing.T) {
+	record := func(id string, hresult int64) *PSObject {
+		return &PSObject{Properties: map[string]interface{}{
+			"FullyQualifiedErrorId": id,
+			"Exception":             &PSObject{Properties: map[string]interface{}{"Message": "Die Zone wurde nicht gefunden.", "HResult": hresult}},
+		}}
+	}
+	cases := []struct {
+		name      string
+		err       error
+		transient bool
+	}{
+		{"error id", scriptError{stderr: "Die Zone wurde nicht gefunden.", records: []interface{}{record("WIN32 9601,Add-DnsServerResourceRecord", -2146233088)}}, true},
+		{"exception hresult", scriptError{stderr: "Der RPC-Server ist nicht verfügbar.", records: []interface{}{record("CimException", -2147023174)}}, true},
+		{"permanent error", scriptError{stderr: "Der Name ist nicht vorhanden.", records: []interface{}{record("WIN32 9714,Get-DnsServerResourceRecord", -2146233088)}}, false},
+		{"service unavailable", fmt.Errorf("Error executing script: %w", newHTTPError(503, []byte("Dienst nicht verfügbar"))), true},
+		{"client error", fmt.Errorf("Error executing script: %w", newHTTPError(400, []byte("Ungültige Anforderung"))), false},
+		{"connection reset", fmt.Errorf("Error executing script: %w", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}), true},
+		{"read timeout", fmt.Errorf("Error executing script: %w", &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}), true},
+	}
+	for _, tc := range cases {
+		if transient := isTransientError(tc.err); transient != tc.transient {
+			t.Errorf("%s: expected transient %t, got %t", tc.name, tc.transient, transient)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultFailbackInterval is how long a server that could not be reached is
+// skipped for when FailbackInterval is not set
+const defaultFailbackInterval = time.Minute
+
+// serverSet runs scripts on the first of several servers that can be
+// reached
+type serverSet struct {
+	mu      sync.Mutex
+	clients []*Client
+	sticky  bool
+	// current is the server that last answered, checked the servers known
+	// to be reachable and failed when each server last could not be reached
+	current int
+	checked []bool
+	failed  []time.Time
+}
+
+// configureServers creates a client for every server in Servers, scripts
+// are run on them in turn while the client itself only limits how many run
+// at once
+func (c *Client) configureServers() error {
+	servers := &serverSet{
+		sticky:  c.StickyServer,
+		checked: make([]bool, len(c.Servers)),
+		failed:  make([]time.Time, len(c.Servers)),
+	}
+
+	for _, server := range c.Servers {
+		client := *c
+		client.Servers = nil
+		client.ServerName = server
+		if host, port, err := net.SplitHostPort(server); err == nil {
+			client.ServerName = host
+			if client.Port, err = strconv.Atoi(port); err != nil {
+				return fmt.Errorf("Invalid port in server %s", server)
+			}
+		}
+		if err := client.ConfigureWinRMClient(); err != nil {
+			return fmt.Errorf("Error configuring server %s: %v", server, err)
+		}
+		client.operations = nil
+		servers.clients = append(servers.clients, &client)
+	}
+
+	c.Client = servers.clients[0].Client
+	if c.MaxConcurrentOperations > 0 {
+		c.operations = make(chan struct{}, c.MaxConcurrentOperations)
+	}
+	c.zones = &zoneCache{zones: map[string]*zoneSnapshot{}}
+	c.servers = servers
+	return nil
+}
+
+// name returns the server named in messages, with Servers set any of them
+// may run a script so they are counted rather than listed
+func (c *Client) name() string {
+	if len(c.Servers) > 0 {
+		return fmt.Sprintf("any of %d servers", len(c.Servers))
+	}
+	return c.ServerName
+}
+
+// execute runs a script on the preferred server, failing over to the next
+// one when it cannot be reached
+func (s *serverSet) execute(c *Client, pscript string) (*Output, error) {
+	interval := c.FailbackInterval
+	if interval <= 0 {
+		interval = defaultFailbackInterval
+	}
+
+	var err error
+	for _, i := range s.order(interval) {
+		client := s.clients[i].withDeadline(c.deadline)
+		if err = s.check(i, client); err == nil {
+			var output *Output
+			output, err = client.executeOnServer(pscript)
+			if isDroppedConnection(err) {
+				// the script may have run, it is left to be retried
+				// once the server was checked again
+				s.mu.Lock()
+				s.checked[i] = false
+				s.mu.Unlock()
+				return nil, err
+			}
+			if !isConnectionError(err) {
+				s.answered(i)
+				return output, err
+			}
+		}
+
+		log.Printf("[WARN] Failing over from %s: %v", client.ServerName, err)
+		s.mu.Lock()
+		s.checked[i] = false
+		s.failed[i] = time.Now()
+		s.mu.Unlock()
+	}
+	return nil, err
+}
+
+// order returns the servers in the order they are tried, starting with the
+// server that last answered when sticky or the first one otherwise, servers
+// that could not be reached within interval are tried last
+func (s *serverSet) order(interval time.Duration) []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := 0
+	if s.sticky {
+		start = s.current
+	}
+	var available, failed []int
+	for n := range s.clients {
+		i := (start + n) % len(s.clients)
+		if time.Since(s.failed[i]) < interval {
+			failed = append(failed, i)
+		} else {
+			available = append(available, i)
+		}
+	}
+	return append(available, failed...)
+}
+
+// check connects to server i unless it answered since it last failed, so
+// scripts are not sent to a server that is down
+func (s *serverSet) check(i int, client *Client) error {
+	s.mu.Lock()
+	checked := s.checked[i]
+	s.mu.Unlock()
+	if checked {
+		return nil
+	}
+
+	timeout := client.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	if !client.deadline.IsZero() && time.Until(client.deadline) < timeout {
+		timeout = time.Until(client.deadline)
+	}
+	dial := (&net.Dialer{Timeout: timeout}).Dial
+	if client.dial != nil {
+		dial = client.dial
+	}
+	conn, err := dial("tcp", client.address)
+	if err != nil {
+		return fmt.Errorf("Error connecting to %s: %w", client.ServerName, err)
+	}
+	conn.Close()
+	return nil
+}
+
+// answered records that server i ran a script
+func (s *serverSet) answered(i int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.checked[i] = true
+	s.failed[i] = time.Time{}
+	s.current = i
+}
+
+func (s *serverSet) close() {
+	for _, client := range s.clients {
+		client.Close()
+	}
+}
+
+// isConnectionError returns if err means the server could not be reached,
+// the script was then not run and can be sent to another server
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "dial tcp") || strings.Contains(msg, "Error connecting to") ||
+		strings.Contains(msg, "TLS handshake timeout")
+}
+
+// isDroppedConnection returns if err means the connection to the server was
+// lost while sending a request
+func isDroppedConnection(err error) bool {
+	if err == nil {
+		return false
+	}
+	if isEOF(err) || isConnectionReset(err) {
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "connection reset") ||
+		strings.Contains(msg, "broken pipe")
+}
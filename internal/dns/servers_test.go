@@ -0,0 +1,92 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClientFailover(t *testing.T) {
+	run := func(script string) (string, string) {
+		return "ok\r\n", ""
+	}
+
+	for _, sticky := range []bool{true, false} {
+		first, second := newTestWinRM(t, run), newTestWinRM(t, run)
+		addr := first.Listener.Addr().String()
+
+		c := Client{
+			Servers:      []string{addr, second.Listener.Addr().String()},
+			StickyServer: sticky,
+			Username:     "user",
+			Password:     "pass",
+			MaxRetries:   1,
+		}
+		client, err := testConfigure(c)
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		if client.ServerName != "" {
+			t.Errorf("sticky %t: expected the servers not to be named as one, got %q", sticky, client.ServerName)
+		}
+		client.FailbackInterval = time.Millisecond
+		client.RetryBackoff = time.Millisecond
+
+		if _, err := client.ExecutePowerShellScript("one"); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		first.Close()
+		if _, err := client.ExecutePowerShellScript("two"); err != nil {
+			t.Fatalf("Expected failing over to the second server, got %s", err)
+		}
+		first = newTestWinRMAt(t, addr, run)
+		time.Sleep(5 * time.Millisecond)
+		if _, err := client.ExecutePowerShellScript("three"); err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+
+		expected := []string{"two"}
+		if sticky {
+			expected = []string{"two", "three"}
+		}
+		if scripts := second.Scripts(); strings.Join(scripts, ",") != strings.Join(expected, ",") {
+			t.Errorf("sticky %t: expected %q to run on the second server, ran %q", sticky, expected, scripts)
+		}
+
+		first.Close()
+		second.Close()
+		_, err = client.WithTimeout(5 * time.Second).ExecutePowerShellScript("four")
+		if err == nil || !strings.Contains(err.Error(), "connection refused") {
+			t.Errorf("sticky %t: expected connection error with every server down, got %v", sticky, err)
+		}
+		client.Close()
+	}
+}
+
+func TestIsConnectionError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	cases := []struct {
+		name       string
+		err        error
+		connection bool
+		dropped    bool
+	}{
+		{"refused", fmt.Errorf("Error executing script: %w", fmt.Errorf("unknown error %w", refused)), true, false},
+		{"unknown host", fmt.Errorf("Error executing script: %w", &net.DNSError{Err: "Host nicht gefunden", Name: "dc1.test.local", IsNotFound: true}), true, false},
+		{"reset", fmt.Errorf("Error executing script: %w", fmt.Errorf("unknown error %w", reset)), false, true},
+		{"script error", scriptError{stderr: "Verbindung getrennt"}, false, false},
+	}
+	for _, tc := range cases {
+		if connection := isConnectionError(tc.err); connection != tc.connection {
+			t.Errorf("%s: expected connection error %t, got %t", tc.name, tc.connection, connection)
+		}
+		if dropped := isDroppedConnection(tc.err); dropped != tc.dropped {
+			t.Errorf("%s: expected dropped connection %t, got %t", tc.name, tc.dropped, dropped)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/masterzen/winrm"
+)
+
+// sessionIdleTimeout is how long a persistent session is kept open without
+// running scripts, the shell is removed from the server once it passes
+const sessionIdleTimeout = time.Minute
+
+// sessionMarker starts the line holding the result of a script run in a
+// persistent session
+const sessionMarker = "#DNS-RESULT#"
+
+// sessionHost runs in the persistent PowerShell process, it reads base64
+// encoded scripts from stdin one per line and writes their output and the
+// errors they raised base64 encoded after sessionMarker
+const sessionHost = `
+$ProgressPreference = 'SilentlyContinue'
+Import-Module DnsServer -ErrorAction SilentlyContinue
+while ($true) {
+	$line = [Console]::In.ReadLine()
+	if ($line -eq $null) { break }
+	$script = [Text.Encoding]::UTF8.GetString([Convert]::FromBase64String($line))
+	$errors = @()
+	$out = try {
+		& ([ScriptBlock]::Create($script)) 2>&1 | %{ if ($_ -is [Management.Automation.ErrorRecord]) { $errors += $_ } else { $_ } } | Out-String -Width 4096
+	} catch {
+		$errors += $_
+	}
+	$err = $errors | Out-String -Width 4096
+	[Console]::Out.WriteLine('` + sessionMarker + ` ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes([string]$out)) + ' ' + [Convert]::ToBase64String([Text.Encoding]::UTF8.GetBytes([string]$err)))
+	[Console]::Out.Flush()
+}
+`
+
+// errSessionTimeout is returned when a script runs past the deadline
+var errSessionTimeout = errors.New("Timeout waiting for script")
+
+// persistentShell holds the sessions shared by the copies of a client, a
+// session runs one script at a time so one is started for every script
+// running concurrently
+type persistentShell struct {
+	mu sync.Mutex
+	// sessions are the sessions not running a script
+	sessions []*shellSession
+}
+
+// run executes pscript in an idle session, starting one when there is none,
+// the session is discarded after an error as its state is unknown
+func (p *persistentShell) run(c *Client, pscript string) (string, string, error) {
+	p.mu.Lock()
+	var session *shellSession
+	if n := len(p.sessions); n > 0 {
+		session = p.sessions[n-1]
+		p.sessions = p.sessions[:n-1]
+		session.idle.Stop()
+	}
+	p.mu.Unlock()
+
+	if session == nil {
+		var err error
+		if session, err = startSession(c.Client); err != nil {
+			return "", "", err
+		}
+	}
+
+	stdout, stderr, err := session.execute(pscript, c.deadline)
+	if err != nil {
+		if err == errSessionTimeout {
+			err = fmt.Errorf("Timeout waiting for script to complete on %s", c.ServerName)
+		}
+		session.close()
+		return "", "", err
+	}
+
+	p.mu.Lock()
+	p.sessions = append(p.sessions, session)
+	session.idle = time.AfterFunc(sessionIdleTimeout, func() {
+		p.remove(session)
+	})
+	p.mu.Unlock()
+	return stdout, stderr, nil
+}
+
+// remove closes an idle session, unless it started running a script
+func (p *persistentShell) remove(session *shellSession) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for i, s := range p.sessions {
+		if s == session {
+			p.sessions = append(p.sessions[:i], p.sessions[i+1:]...)
+			session.close()
+			return
+		}
+	}
+}
+
+// close ends the idle sessions
+func (p *persistentShell) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for _, session := range p.sessions {
+		session.idle.Stop()
+		session.close()
+	}
+	p.sessions = nil
+}
+
+// shellSession is a PowerShell process running sessionHost in a WinRM shell
+type shellSession struct {
+	shell   *winrm.Shell
+	cmd     *winrm.Command
+	idle    *time.Timer
+	results chan string
+	// err is why the process output ended, it is set before results is
+	// closed
+	err error
+}
+
+func startSession(client *winrm.Client) (*shellSession, error) {
+	shell, err := client.CreateShell()
+	if err != nil {
+		return nil, err
+	}
+	cmd, err := shell.Execute("powershell.exe -NoProfile -NonInteractive -EncodedCommand " + encodePowerShell(sessionHost))
+	if err != nil {
+		shell.Close()
+		return nil, err
+	}
+
+	s := &shellSession{
+		shell:   shell,
+		cmd:     cmd,
+		results: make(chan string, 1),
+	}
+	go s.read()
+	go io.Copy(ioutil.Discard, cmd.Stderr)
+	return s, nil
+}
+
+// read passes result lines written by the process to results
+func (s *shellSession) read() {
+	r := bufio.NewReader(s.cmd.Stdout)
+	for {
+		line, err := r.ReadString('\n')
+		if strings.HasPrefix(line, sessionMarker) {
+			s.results <- strings.TrimRight(line, "\r\n")
+		}
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("PowerShell exited")
+			}
+			s.err = err
+			close(s.results)
+			return
+		}
+	}
+}
+
+// execute sends pscript to the process and waits for its result until
+// deadline, a zero deadline waits until the process answers
+func (s *shellSession) execute(pscript string, deadline time.Time) (string, string, error) {
+	line := base64.StdEncoding.EncodeToString([]byte(pscript)) + "\r\n"
+	if err := writeInput(s.cmd.Stdin, line); err != nil {
+		return "", "", fmt.Errorf("PowerShell session ended: %w", err)
+	}
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case result, ok := <-s.results:
+		if !ok {
+			return "", "", fmt.Errorf("PowerShell session ended: %w", s.err)
+		}
+		return decodeSessionResult(result)
+	case <-expired:
+		return "", "", errSessionTimeout
+	}
+}
+
+func (s *shellSession) close() {
+	s.cmd.Close()
+	s.shell.Close()
+}
+
+// decodeSessionResult splits a result line into the output and errors of
+// the script
+func decodeSessionResult(line string) (string, string, error) {
+	fields := strings.Split(line, " ")
+	if len(fields) != 3 {
+		return "", "", errors.New("Invalid PowerShell session output")
+	}
+	stdout, err := base64.StdEncoding.DecodeString(fields[1])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid PowerShell session output: %v", err)
+	}
+	stderr, err := base64.StdEncoding.DecodeString(fields[2])
+	if err != nil {
+		return "", "", fmt.Errorf("Invalid PowerShell session output: %v", err)
+	}
+	return string(stdout), string(stderr), nil
+}
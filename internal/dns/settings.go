@@ -0,0 +1,134 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ServerSettings holds the server wide and EDNS settings of a DNS server,
+// ListeningIPAddress is empty when the server listens on all its addresses
+// and AllIPAddress, which is only read, holds them
+type ServerSettings struct {
+	ListeningIPAddress  []string
+	AllIPAddress        []string
+	RoundRobin          bool
+	LocalNetPriority    bool
+	NameCheckFlag       int
+	StrictFileParsing   bool
+	EDnsCacheTimeout    float64
+	EDnsEnableProbes    bool
+	EDnsEnableReception bool
+}
+
+// ReadServerSettings retrieves server wide and EDNS settings from the DNS server
+func (c *Client) ReadServerSettings() (ServerSettings, error) {
+	const pscript = `
+$s = Get-DnsServerSetting -All
+$e = Get-DnsServerEDns
+New-Object PSObject -Property @{
+	ListeningIPAddress = @($s.ListeningIPAddress | %{ $_.ToString() })
+	AllIPAddress = @($s.AllIPAddress | %{ $_.ToString() })
+	RoundRobin = $s.RoundRobin
+	LocalNetPriority = $s.LocalNetPriority
+	NameCheckFlag = $s.NameCheckFlag
+	StrictFileParsing = $s.StrictFileParsing
+	EDnsCacheTimeout = $e.CacheTimeout.TotalSeconds
+	EDnsEnableProbes = $e.EnableProbes
+	EDnsEnableReception = $e.EnableReception
+} | ConvertTo-Json
+`
+	// restricted endpoints return both objects, which are read here
+	const pscriptRestricted = `
+Get-DnsServerSetting -All
+Get-DnsServerEDns
+`
+	var settings ServerSettings
+
+	if c.restricted() {
+		output, err := c.ExecutePowerShellScript(pscriptRestricted)
+		if err != nil {
+			return ServerSettings{}, fmt.Errorf("Running PowerShell script: %v", err)
+		}
+		if len(output.objects) < 2 {
+			return ServerSettings{}, fmt.Errorf("No server settings returned")
+		}
+		s, e := output.objects[0], output.objects[1]
+		settings = ServerSettings{
+			ListeningIPAddress:  psStrings(psProperty(s, "ListeningIPAddress")),
+			AllIPAddress:        psStrings(psProperty(s, "AllIPAddress")),
+			RoundRobin:          psProperty(s, "RoundRobin") == true,
+			LocalNetPriority:    psProperty(s, "LocalNetPriority") == true,
+			NameCheckFlag:       int(psNumber(psProperty(s, "NameCheckFlag"))),
+			StrictFileParsing:   psProperty(s, "StrictFileParsing") == true,
+			EDnsCacheTimeout:    psSeconds(psProperty(e, "CacheTimeout")),
+			EDnsEnableProbes:    psProperty(e, "EnableProbes") == true,
+			EDnsEnableReception: psProperty(e, "EnableReception") == true,
+		}
+	} else {
+		output, err := c.ExecutePowerShellScript(pscript)
+		if err != nil {
+			return ServerSettings{}, fmt.Errorf("Running PowerShell script: %v", err)
+		}
+		if output.stdout == "" {
+			return ServerSettings{}, fmt.Errorf("No server settings returned")
+		}
+		if err := json.Unmarshal([]byte(output.stdout), &settings); err != nil {
+			return ServerSettings{}, fmt.Errorf("Unmarshalling response: %v", err)
+		}
+	}
+
+	if listensOnAll(settings) {
+		settings.ListeningIPAddress = nil
+	}
+	return settings, nil
+}
+
+// listensOnAll returns if the server listens on every address it has
+func listensOnAll(settings ServerSettings) bool {
+	if len(settings.ListeningIPAddress) != len(settings.AllIPAddress) {
+		return len(settings.ListeningIPAddress) == 0
+	}
+	all := map[string]bool{}
+	for _, addr := range settings.AllIPAddress {
+		all[addr] = true
+	}
+	for _, addr := range settings.ListeningIPAddress {
+		if !all[addr] {
+			return false
+		}
+	}
+	return true
+}
+
+// UpdateServerSettings applies server wide and EDNS settings to the DNS server,
+// the server listens on all its addresses when no listening addresses are
+// given
+func (c *Client) UpdateServerSettings(settings ServerSettings) (ServerSettings, error) {
+	const tmplpscript = `
+$s = Get-DnsServerSetting -All
+{{ if .ListeningIPAddress -}}
+$s.ListeningIPAddress = @({{ range $i, $a := .ListeningIPAddress }}{{ if $i }}, {{ end }}'{{ $a }}'{{ end }})
+{{ else -}}
+$s.ListeningIPAddress = $s.AllIPAddress
+{{ end -}}
+$s.RoundRobin = ${{ .RoundRobin }}
+$s.LocalNetPriority = ${{ .LocalNetPriority }}
+$s.NameCheckFlag = {{ .NameCheckFlag }}
+$s.StrictFileParsing = ${{ .StrictFileParsing }}
+Set-DnsServerSetting -InputObject $s
+Set-DnsServerEDns -CacheTimeout {{ timespan .EDnsCacheTimeout }} -EnableProbes ${{ .EDnsEnableProbes }} -EnableReception ${{ .EDnsEnableReception }}
+`
+	if c.restricted() {
+		return ServerSettings{}, fmt.Errorf("Server settings cannot be changed on configuration %s, Set-DnsServerSetting needs the settings read into a variable", c.ConfigurationName)
+	}
+
+	pscript, err := tmplExec(settings, tmplpscript)
+	if err != nil {
+		return ServerSettings{}, fmt.Errorf("Creating template: %v", err)
+	}
+	if _, err := c.ExecutePowerShellScript(pscript); err != nil {
+		return ServerSettings{}, fmt.Errorf("Executing PowerShell script: %v", err)
+	}
+
+	return c.ReadServerSettings()
+}
@@ -0,0 +1,46 @@
+package dns
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestClientServerSettings_ListeningAddresses(t *testing.T) {
+	const settings = `{"ListeningIPAddress":[%s],"AllIPAddress":["10.0.0.1","10.0.0.2"],"RoundRobin":true,"LocalNetPriority":true,"NameCheckFlag":2,"StrictFileParsing":false,"EDnsCacheTimeout":900,"EDnsEnableProbes":true,"EDnsEnableReception":true}`
+	listening := `"10.0.0.1"`
+	server := newTestWinRM(t, func(script string) (string, string) {
+		switch {
+		case strings.Contains(script, "$s.ListeningIPAddress = $s.AllIPAddress"):
+			listening = `"10.0.0.2","10.0.0.1"`
+		case strings.Contains(script, "$s.ListeningIPAddress = @("):
+			listening = `"10.0.0.1"`
+		case strings.Contains(script, "ConvertTo-Json"):
+			return strings.Replace(settings, "%s", listening, 1), ""
+		}
+		return "", ""
+	})
+	defer server.Close()
+
+	client, err := testConfigure(server.config())
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	read, err := client.ReadServerSettings()
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(read.ListeningIPAddress) != 1 || read.ListeningIPAddress[0] != "10.0.0.1" {
+		t.Fatalf("Expected the server to listen on 10.0.0.1, got %v", read.ListeningIPAddress)
+	}
+
+	// no listening addresses puts the server back to listening on all of
+	// them, which reads as none
+	updated, err := client.UpdateServerSettings(ServerSettings{NameCheckFlag: 2, EDnsCacheTimeout: 900})
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	if len(updated.ListeningIPAddress) != 0 {
+		t.Fatalf("Expected the server to listen on all addresses, got %v", updated.ListeningIPAddress)
+	}
+}
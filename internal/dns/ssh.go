@@ -0,0 +1,294 @@
+package dns
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// defaultSSHShell runs scripts with the ssh transport when SSHShell is not
+// set
+const defaultSSHShell = "powershell"
+
+// sshConnection is a connection to an SSH server, made when first needed and
+// made again once it fails, name is the role of the server in errors
+type sshConnection struct {
+	mu      sync.Mutex
+	name    string
+	addr    string
+	config  *ssh.ClientConfig
+	dial    dialFunc
+	timeout time.Duration
+	client  *ssh.Client
+}
+
+// configureSSH prepares running scripts with SSHShell over an SSH connection
+// to the server instead of WinRM
+func (c *Client) configureSSH() error {
+	switch {
+	case c.restricted():
+		return fmt.Errorf("Configuration %s requires the winrm transport", c.ConfigurationName)
+	case c.Backend == "psrp":
+		return fmt.Errorf("Backend psrp requires the winrm transport")
+	case c.Backend != "" && c.Backend != "auto" && c.Backend != "powershell":
+		return fmt.Errorf("Unsupported backend: %s", c.Backend)
+	case c.AuthType != "" && c.AuthType != "basic":
+		return fmt.Errorf("Authentication type %s is not supported with the ssh transport", c.AuthType)
+	}
+
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	timeout := c.ConnectionTimeout
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	dial, err := c.newDialer(timeout)
+	if err != nil {
+		return err
+	}
+	c.dial = dial
+	if dial == nil {
+		dial = (&net.Dialer{Timeout: timeout, KeepAlive: 30 * time.Second}).Dial
+	}
+
+	auth, err := sshAuth(c.Password, c.SSHPrivateKey)
+	if err != nil {
+		return fmt.Errorf("Error reading SSH private key: %v", err)
+	}
+	if len(auth) == 0 {
+		return fmt.Errorf("A password or private key is required for the ssh transport")
+	}
+	hostKeyCallback, err := c.sshHostKeyCallback()
+	if err != nil {
+		return err
+	}
+
+	c.address = net.JoinHostPort(c.ServerName, strconv.Itoa(port))
+	c.sshConn = &sshConnection{
+		name: "SSH server",
+		addr: c.address,
+		config: &ssh.ClientConfig{
+			User:            c.Username,
+			Auth:            auth,
+			Timeout:         timeout,
+			HostKeyCallback: hostKeyCallback,
+		},
+		dial:    dial,
+		timeout: timeout,
+	}
+	if c.MaxConcurrentOperations > 0 {
+		c.operations = make(chan struct{}, c.MaxConcurrentOperations)
+	}
+	c.zones = &zoneCache{zones: map[string]*zoneSnapshot{}}
+	return nil
+}
+
+// sshAuth returns the methods logging in with password or the PEM encoded
+// private key, the password also answers keyboard-interactive prompts
+func sshAuth(password string, privateKey []byte) ([]ssh.AuthMethod, error) {
+	var auth []ssh.AuthMethod
+	if len(privateKey) > 0 {
+		signer, err := ssh.ParsePrivateKey(privateKey)
+		if err != nil {
+			return nil, err
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if password != "" {
+		auth = append(auth, ssh.Password(password), ssh.KeyboardInteractive(
+			func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+				answers := make([]string, len(questions))
+				for i := range answers {
+					answers[i] = password
+				}
+				return answers, nil
+			}))
+	}
+	return auth, nil
+}
+
+// sshHostKeyCallback checks host keys against SSHKnownHosts, or
+// ~/.ssh/known_hosts when it is not set, unless SSHInsecure is set
+func (c *Client) sshHostKeyCallback() (ssh.HostKeyCallback, error) {
+	if c.SSHInsecure {
+		return ssh.InsecureIgnoreHostKey(), nil
+	}
+	return knownHostsCallback(c.SSHKnownHosts)
+}
+
+// knownHostsCallback checks host keys against the known_hosts file at path,
+// ~/.ssh/known_hosts when path is empty
+func knownHostsCallback(path string) (ssh.HostKeyCallback, error) {
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("Error finding known hosts file: %v", err)
+		}
+		path = filepath.Join(home, ".ssh", "known_hosts")
+	}
+	check, err := knownhosts.New(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading known hosts: %v", err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := check(hostname, remote, key)
+		if keyErr, ok := err.(*knownhosts.KeyError); ok {
+			if len(keyErr.Want) == 0 {
+				return fmt.Errorf("host key of %s is not in %s", hostname, path)
+			}
+			return fmt.Errorf("host key of %s does not match %s", hostname, path)
+		}
+		return err
+	}, nil
+}
+
+// sshCommand returns the command line running pscript with shell
+func sshCommand(shell, pscript string) string {
+	return fmt.Sprintf("%s -NoProfile -NonInteractive -EncodedCommand %s", shell, encodePowerShell(pscript))
+}
+
+// runSSH executes pscript with SSHShell in a new session of the SSH
+// connection, the session is closed when the deadline set by WithTimeout
+// passes
+func (c *Client) runSSH(pscript string) (string, string, int, error) {
+	shell := c.SSHShell
+	if shell == "" {
+		shell = defaultSSHShell
+	}
+	command, input := sshCommand(shell, pscript), ""
+	if len(command) > maxCommandLine {
+		command, input = sshCommand(shell, stdinLoader), loaderInput(pscript)
+	}
+
+	// a connection that dropped since it was last used fails to open the
+	// session, the script has not started so it is sent on a new one
+	var (
+		client  *ssh.Client
+		session *ssh.Session
+		err     error
+	)
+	for attempt := 0; session == nil; attempt++ {
+		if client, err = c.sshConn.connect(); err != nil {
+			return "", "", 1, err
+		}
+		if session, err = client.NewSession(); err != nil {
+			c.sshConn.reset(client)
+			if attempt > 0 {
+				return "", "", 1, fmt.Errorf("Error opening SSH session on %s: %w", c.ServerName, err)
+			}
+		}
+	}
+	defer session.Close()
+
+	var stdout, stderr bytes.Buffer
+	session.Stdin = strings.NewReader(input)
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	if err := session.Start(command); err != nil {
+		return "", "", 1, fmt.Errorf("Error starting %s on %s: %w", shell, c.ServerName, err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if !c.deadline.IsZero() {
+		timer := time.NewTimer(time.Until(c.deadline))
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err = <-done:
+	case <-expired:
+		session.Signal(ssh.SIGKILL)
+		return "", "", 1, fmt.Errorf("Timeout waiting for script to complete on %s", c.ServerName)
+	}
+
+	switch e := err.(type) {
+	case nil:
+		return stdout.String(), stderr.String(), 0, nil
+	case *ssh.ExitError:
+		return stdout.String(), stderr.String(), e.ExitStatus(), nil
+	case *ssh.ExitMissingError:
+		// the connection dropped, the script may or may not have run
+		c.sshConn.reset(client)
+		return "", "", 1, fmt.Errorf("PowerShell session ended on %s before the script completed", c.ServerName)
+	default:
+		return "", "", 1, err
+	}
+}
+
+// Dial connects to addr from the SSH server
+func (s *sshConnection) Dial(network, addr string) (net.Conn, error) {
+	client, err := s.connect()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := client.Dial(network, addr)
+	if err != nil {
+		// the SSH connection may have dropped, it is made again for the
+		// next connection
+		s.reset(client)
+		return nil, fmt.Errorf("Error connecting to %s from %s %s: %w", addr, s.name, s.addr, err)
+	}
+	return conn, nil
+}
+
+// connect returns the SSH connection to the server
+func (s *sshConnection) connect() (*ssh.Client, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		return s.client, nil
+	}
+
+	conn, err := s.dial("tcp", s.addr)
+	if err != nil {
+		return nil, fmt.Errorf("Error connecting to %s %s: %w", s.name, s.addr, err)
+	}
+	if s.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(s.timeout))
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("Error connecting to %s %s: %w", s.name, s.addr, err)
+	}
+	conn.SetDeadline(time.Time{})
+	s.client = ssh.NewClient(sshConn, chans, reqs)
+	return s.client, nil
+}
+
+// reset closes client if it is still the connection to the server, so the
+// next use connects again
+func (s *sshConnection) reset(client *ssh.Client) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client == client {
+		s.client.Close()
+		s.client = nil
+	}
+}
+
+// close ends the SSH connection to the server
+func (s *sshConnection) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.client != nil {
+		s.client.Close()
+		s.client = nil
+	}
+}
@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestClientSSHTransport(t *testing.T) {
+	release := make(chan struct{})
+	sshd := newTestSSHD(t, "pass", nil, func(script string) (string, string) {
+		switch script {
+		case "Start-Sleep 3600":
+			<-release
+		case "Get-DnsServerZone -Name missing.local":
+			return "", "Get-DnsServerZone : The zone missing.local was not found on server DC1."
+		}
+		return "ok", ""
+	})
+	defer sshd.Close()
+	defer close(release)
+
+	knownHosts := testKnownHosts(t, sshd.Addr(), sshd.hostKey)
+	defer os.Remove(knownHosts)
+	c := sshd.config()
+	c.SSHKnownHosts = knownHosts
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer client.Close()
+
+	small := "Get-DnsServerZone -Name 'bücher.example.com'"
+	large := strings.Repeat("Add-DnsServerResourceRecord -ZoneName example.com -Txt -Name big -DescriptiveText 'Zürich'\n", 4000)
+	for _, script := range []string{small, large} {
+		if _, err := client.ExecutePowerShellScript(script); err != nil {
+			t.Fatalf("Error running a script of %d bytes: %s", len(script), err)
+		}
+	}
+	if scripts := sshd.Scripts(); len(scripts) != 2 || scripts[0] != small || scripts[1] != large {
+		t.Fatalf("Expected scripts to be received unchanged, got %d scripts", len(scripts))
+	}
+	if shells := sshd.Shells(); shells[0] != "powershell" || shells[1] != "powershell" {
+		t.Fatalf("Expected scripts to run with powershell, got %v", shells)
+	}
+	if logins := sshd.Logins(); logins != 1 {
+		t.Fatalf("Expected one SSH connection, got %d", logins)
+	}
+
+	if _, err := client.ExecutePowerShellScript("Get-DnsServerZone -Name missing.local"); err == nil || !strings.Contains(err.Error(), "was not found") {
+		t.Fatalf("Expected script error, got %v", err)
+	}
+
+	// a dropped connection is made again for the next script
+	sshd.Drop()
+	if _, err := client.ExecutePowerShellScript(small); err != nil {
+		t.Fatalf("Error after the connection dropped: %s", err)
+	}
+	if logins := sshd.Logins(); logins != 2 {
+		t.Fatalf("Expected the SSH connection to be made again, got %d logins", logins)
+	}
+
+	start := time.Now()
+	if _, err := client.WithTimeout(200 * time.Millisecond).ExecutePowerShellScript("Start-Sleep 3600"); err == nil || !strings.Contains(err.Error(), "Timeout waiting for script") {
+		t.Fatalf("Expected timeout error, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("Expected script to be stopped after 200ms, took %s", elapsed)
+	}
+}
+
+func TestClientSSHTransport_Settings(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	der, _ := x509.MarshalECPrivateKey(key)
+	privateKey := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	publicKey, _ := ssh.NewPublicKey(&key.PublicKey)
+
+	sshd := newTestSSHD(t, "pass", publicKey, func(script string) (string, string) {
+		return "ok", ""
+	})
+	defer sshd.Close()
+	other := newTestSSHD(t, "pass", nil, func(script string) (string, string) {
+		return "ok", ""
+	})
+	defer other.Close()
+
+	knownHosts := testKnownHosts(t, sshd.Addr(), sshd.hostKey)
+	defer os.Remove(knownHosts)
+	empty := testKnownHosts(t, "", nil)
+	defer os.Remove(empty)
+	mismatch := testKnownHosts(t, sshd.Addr(), other.hostKey)
+	defer os.Remove(mismatch)
+
+	cases := []struct {
+		name   string
+		config func(c *Client)
+		err    string
+	}{
+		{"password", func(c *Client) {}, ""},
+		{"private key with pwsh", func(c *Client) {
+			c.Password = ""
+			c.SSHPrivateKey = privateKey
+			c.SSHShell = "pwsh"
+		}, ""},
+		{"auto backend", func(c *Client) {
+			c.Backend = "auto"
+		}, ""},
+		{"wrong password", func(c *Client) {
+			c.Password = "wrong"
+		}, "unable to authenticate"},
+		{"unknown host key", func(c *Client) {
+			c.SSHKnownHosts = empty
+		}, "is not in " + empty},
+		{"host key mismatch", func(c *Client) {
+			c.SSHKnownHosts = mismatch
+		}, "does not match " + mismatch},
+		{"insecure", func(c *Client) {
+			c.SSHKnownHosts = mismatch
+			c.SSHInsecure = true
+		}, ""},
+		{"insecure tls", func(c *Client) {
+			c.SSHKnownHosts = mismatch
+			c.Insecure = true
+		}, "does not match " + mismatch},
+		{"missing known hosts", func(c *Client) {
+			c.SSHKnownHosts = knownHosts + ".missing"
+		}, "Error reading known hosts"},
+		{"no credentials", func(c *Client) {
+			c.Password = ""
+		}, "A password or private key is required for the ssh transport"},
+		{"psrp backend", func(c *Client) {
+			c.Backend = "psrp"
+		}, "Backend psrp requires the winrm transport"},
+		{"configuration name", func(c *Client) {
+			c.ConfigurationName = "DnsOperators"
+		}, "Configuration DnsOperators requires the winrm transport"},
+		{"kerberos", func(c *Client) {
+			c.AuthType = "kerberos"
+		}, "Authentication type kerberos is not supported with the ssh transport"},
+		{"unsupported transport", func(c *Client) {
+			c.Transport = "telnet"
+		}, "Unsupported transport: telnet"},
+	}
+	for _, tc := range cases {
+		c := sshd.config()
+		c.SSHKnownHosts = knownHosts
+		tc.config(&c)
+		client, err := testConfigure(c)
+		if err == nil {
+			_, err = client.ExecutePowerShellScript("Get-DnsServerZone")
+			client.Close()
+		}
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: unexpected error: %s", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error containing %q, got %v", tc.name, tc.err, err)
+		}
+	}
+	if shells := sshd.Shells(); len(shells) != 4 || shells[1] != "pwsh" {
+		t.Fatalf("Expected 4 scripts with the second run by pwsh, got %v", shells)
+	}
+}
+
+// testKnownHosts writes a known_hosts file holding key for addr, or an empty
+// one when key is nil
+func testKnownHosts(t *testing.T, addr string, key ssh.PublicKey) string {
+	f, err := ioutil.TempFile("", "known_hosts")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	defer f.Close()
+	if key != nil {
+		fmt.Fprintln(f, knownhosts.Line([]string{knownhosts.Normalize(addr)}, key))
+	}
+	return f.Name()
+}
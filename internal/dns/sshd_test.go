@@ -0,0 +1,214 @@
+package dns
+
+import (
+	"bufio"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+var testSSHCommand = regexp.MustCompile(`^(\S+) -NoProfile -NonInteractive -EncodedCommand ([A-Za-z0-9+/=]+)$`)
+
+// testSSHD is an OpenSSH server stand-in accepting a password or public key,
+// scripts run with an -EncodedCommand exec request, or read from stdin by
+// the loader, are answered by run
+type testSSHD struct {
+	t        *testing.T
+	listener net.Listener
+	server   *ssh.ServerConfig
+	hostKey  ssh.PublicKey
+	run      func(script string) (stdout, stderr string)
+
+	mu      sync.Mutex
+	scripts []string
+	shells  []string
+	conns   []net.Conn
+	logins  int
+}
+
+// newTestSSHD starts the server, logging in user with password or
+// authorizedKey when set
+func newTestSSHD(t *testing.T, password string, authorizedKey ssh.PublicKey, run func(script string) (string, string)) *testSSHD {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting SSH server: %s", err)
+	}
+
+	s := &testSSHD{t: t, listener: l, hostKey: signer.PublicKey(), run: run}
+	s.server = &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() != "user" || password == "" || string(pass) != password {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if c.User() != "user" || authorizedKey == nil || string(key.Marshal()) != string(authorizedKey.Marshal()) {
+				return nil, io.EOF
+			}
+			return nil, nil
+		},
+	}
+	s.server.AddHostKey(signer)
+	go s.serve()
+	return s
+}
+
+// config returns the client settings for connecting to the server, its host
+// key is not in a known_hosts file
+func (s *testSSHD) config() Client {
+	host, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	p, _ := strconv.Atoi(port)
+	return Client{
+		ServerName: host,
+		Port:       p,
+		Username:   "user",
+		Password:   "pass",
+		Transport:  "ssh",
+	}
+}
+
+func (s *testSSHD) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Scripts returns the scripts run so far
+func (s *testSSHD) Scripts() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.scripts...)
+}
+
+// Shells returns the commands scripts were run with
+func (s *testSSHD) Shells() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string{}, s.shells...)
+}
+
+// Logins returns the number of SSH connections that logged in
+func (s *testSSHD) Logins() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.logins
+}
+
+// Drop closes the connections made so far, as a restarted sshd would
+func (s *testSSHD) Drop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, conn := range s.conns {
+		conn.Close()
+	}
+	s.conns = nil
+}
+
+func (s *testSSHD) Close() {
+	s.listener.Close()
+	s.Drop()
+}
+
+func (s *testSSHD) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.conns = append(s.conns, conn)
+		s.mu.Unlock()
+		go s.handle(conn)
+	}
+}
+
+func (s *testSSHD) handle(conn net.Conn) {
+	_, chans, reqs, err := ssh.NewServerConn(conn, s.server)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	s.mu.Lock()
+	s.logins++
+	s.mu.Unlock()
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported channel")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go s.session(channel, requests)
+	}
+}
+
+// session runs the command of an exec request and sends its exit status,
+// which is 1 when the script wrote errors
+func (s *testSSHD) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	for req := range requests {
+		if req.Type != "exec" {
+			req.Reply(false, nil)
+			continue
+		}
+		var exec struct{ Command string }
+		ssh.Unmarshal(req.Payload, &exec)
+		m := testSSHCommand.FindStringSubmatch(exec.Command)
+		if m == nil {
+			s.t.Errorf("Command is not an encoded PowerShell script: %s", exec.Command)
+			req.Reply(false, nil)
+			return
+		}
+		req.Reply(true, nil)
+
+		encoded, _ := base64.StdEncoding.DecodeString(m[2])
+		script := testFromUTF16(encoded)
+		if strings.Contains(script, "IsNullOrEmpty($line)") {
+			var input strings.Builder
+			r := bufio.NewReader(channel)
+			for {
+				line, err := r.ReadString('\n')
+				if strings.TrimSpace(line) == "" || err != nil {
+					break
+				}
+				input.WriteString(strings.TrimSpace(line))
+			}
+			decoded, _ := base64.StdEncoding.DecodeString(input.String())
+			script = string(decoded)
+		}
+
+		stdout, stderr := s.run(script)
+		s.mu.Lock()
+		s.scripts = append(s.scripts, script)
+		s.shells = append(s.shells, m[1])
+		s.mu.Unlock()
+
+		io.WriteString(channel, stdout)
+		io.WriteString(channel.Stderr(), stderr)
+		status := uint32(0)
+		if stderr != "" {
+			status = 1
+		}
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+		return
+	}
+}
@@ -0,0 +1,548 @@
+package dns
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jcmturner/gokrb5/v8/client"
+	"github.com/masterzen/winrm"
+	"github.com/masterzen/winrm/soap"
+)
+
+const soapContentType = "application/soap+xml;charset=UTF-8"
+
+// authIdleTimeout closes authenticated connections left idle before the
+// server's own two minute timeout drops them
+const authIdleTimeout = time.Minute
+
+// wsmanMutualAuthorization is sent instead of credentials when
+// authenticating with a client certificate
+const wsmanMutualAuthorization = "http://schemas.dmtf.org/wbem/wsman/1/wsman/secprofile/https/mutual"
+
+// httpSettings holds the endpoint configuration used to build HTTP
+// transports for the authentication schemes, timeout limits connecting and
+// the TLS handshake
+type httpSettings struct {
+	url       string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	// dial connects through a proxy or bastion when set
+	dial dialFunc
+}
+
+func newHTTPSettings(endpoint *winrm.Endpoint, dial dialFunc) (httpSettings, error) {
+	scheme := "http"
+	if endpoint.HTTPS {
+		scheme = "https"
+	}
+
+	settings := httpSettings{
+		url: fmt.Sprintf("%s://%s:%d/wsman", scheme, endpoint.Host, endpoint.Port),
+		tlsConfig: &tls.Config{
+			InsecureSkipVerify: endpoint.Insecure,
+		},
+		timeout: endpoint.Timeout,
+		dial:    dial,
+	}
+
+	if len(endpoint.CACert) > 0 {
+		certPool := x509.NewCertPool()
+		if !certPool.AppendCertsFromPEM(endpoint.CACert) {
+			return httpSettings{}, fmt.Errorf("Unable to read CA certificate")
+		}
+		settings.tlsConfig.RootCAs = certPool
+	}
+
+	if len(endpoint.Cert) > 0 {
+		cert, err := tls.X509KeyPair(endpoint.Cert, endpoint.Key)
+		if err != nil {
+			return httpSettings{}, fmt.Errorf("Error reading client certificate: %v", err)
+		}
+		settings.tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return settings, nil
+}
+
+// newTransport returns a new HTTP transport
+func (s httpSettings) newTransport() *http.Transport {
+	if s.dial != nil {
+		return &http.Transport{
+			Dial:                s.dial,
+			TLSClientConfig:     s.tlsConfig,
+			TLSHandshakeTimeout: s.timeout,
+		}
+	}
+	return &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		Dial: (&net.Dialer{
+			Timeout:   s.timeout,
+			KeepAlive: 30 * time.Second,
+		}).Dial,
+		TLSClientConfig:     s.tlsConfig,
+		TLSHandshakeTimeout: s.timeout,
+	}
+}
+
+// authConn is a connection authenticated with a scheme bound to it, such as
+// NTLM or Kerberos, its transport holds the one TCP connection the server
+// authenticated and sealer the keys of the session when encrypting
+type authConn struct {
+	transport     *http.Transport
+	sealer        sealer
+	authenticated bool
+}
+
+// authConns keeps the connections of a transport authenticated for the
+// lifetime of the client, a connection is used by one request at a time
+type authConns struct {
+	mu   sync.Mutex
+	idle []*authConn
+}
+
+// get returns an idle connection, or a new one still to be authenticated
+func (p *authConns) get(s httpSettings) *authConn {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if n := len(p.idle); n > 0 {
+		conn := p.idle[n-1]
+		p.idle = p.idle[:n-1]
+		return conn
+	}
+	transport := s.newTransport()
+	transport.MaxConnsPerHost = 1
+	transport.IdleConnTimeout = authIdleTimeout
+	return &authConn{transport: transport}
+}
+
+// put returns conn once its request completed, a connection whose request
+// failed is closed as the state of its session is not known
+func (p *authConns) put(conn *authConn, err error) {
+	if err != nil {
+		conn.transport.CloseIdleConnections()
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idle = append(p.idle, conn)
+}
+
+// close closes the idle connections
+func (p *authConns) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, conn := range p.idle {
+		conn.transport.CloseIdleConnections()
+	}
+	p.idle = nil
+}
+
+// post sends body over conn when it is authenticated, authenticate is
+// called to authenticate it and send body when it is not or the server
+// answers that it no longer is, a new connection replacing one the server
+// closed has to be authenticated again
+func (p *authConns) post(settings httpSettings, httpClient *http.Client, conn *authConn, body string, authenticate func() (string, error)) (string, error) {
+	if !conn.authenticated {
+		return authenticate()
+	}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	if conn.sealer != nil {
+		resp, err = settings.sendEncrypted(httpClient, conn.sealer, spnegoEncryptedProtocol, body)
+	} else {
+		resp, err = settings.send(httpClient, body, "")
+	}
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		discardBody(resp)
+		conn.authenticated = false
+		conn.sealer = nil
+		return authenticate()
+	}
+	if conn.sealer != nil {
+		return readEncryptedResponse(resp, conn.sealer)
+	}
+	return readSoapResponse(resp)
+}
+
+// newClient returns an HTTP client for a request of client, the server
+// holds requests for up to the WS-Management operation timeout so the
+// response is waited for that long on top of the connection timeout
+func (s httpSettings) newClient(client *winrm.Client, transport http.RoundTripper) *http.Client {
+	return &http.Client{
+		Transport: transport,
+		Timeout:   wsmanTimeout(client.Parameters.Timeout) + s.timeout,
+	}
+}
+
+// basicTransport sends WinRM requests with basic authentication, or the
+// client certificate when one is configured
+type basicTransport struct {
+	httpSettings
+	authorization string
+	transport     *http.Transport
+}
+
+// Transport configures the transport for the endpoint
+func (t *basicTransport) Transport(endpoint *winrm.Endpoint) error {
+	settings, err := newHTTPSettings(endpoint, t.dial)
+	if err != nil {
+		return err
+	}
+	t.httpSettings = settings
+	t.transport = settings.newTransport()
+	return nil
+}
+
+// Post sends the request to the WinRM service
+func (t *basicTransport) Post(client *winrm.Client, request *soap.SoapMessage) (string, error) {
+	resp, err := t.send(t.newClient(client, t.transport), request.String(), t.authorization)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		discardBody(resp)
+		return "", basicError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+	}
+	return readSoapResponse(resp)
+}
+
+// ntlmTransport authenticates WinRM requests with NTLM, messages are
+// encrypted when not using HTTPS
+type ntlmTransport struct {
+	httpSettings
+	username string
+	password string
+	encrypt  bool
+	conns    authConns
+}
+
+// Transport configures the transport for the endpoint
+func (t *ntlmTransport) Transport(endpoint *winrm.Endpoint) error {
+	settings, err := newHTTPSettings(endpoint, t.dial)
+	if err != nil {
+		return err
+	}
+	t.httpSettings = settings
+	return nil
+}
+
+// Post sends the request to the WinRM service over an authenticated
+// connection, authenticating one first when needed
+func (t *ntlmTransport) Post(client *winrm.Client, request *soap.SoapMessage) (string, error) {
+	conn := t.conns.get(t.httpSettings)
+	httpClient := t.newClient(client, conn.transport)
+	body := request.String()
+	response, err := t.conns.post(t.httpSettings, httpClient, conn, body, func() (string, error) {
+		return t.authenticate(httpClient, conn, body)
+	})
+	t.conns.put(conn, err)
+	return response, err
+}
+
+// authenticate runs the NTLM handshake over conn and sends body
+func (t *ntlmTransport) authenticate(httpClient *http.Client, conn *authConn, body string) (string, error) {
+	// when encrypting, authenticate with empty messages to set up the keys
+	// used to seal the request
+	handshakeBody := body
+	if t.encrypt {
+		handshakeBody = ""
+	}
+
+	resp, err := t.send(httpClient, handshakeBody, "Negotiate "+base64.StdEncoding.EncodeToString(ntlmNegotiateMessage(t.encrypt)))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		if !t.encrypt {
+			return readSoapResponse(resp)
+		}
+		discardBody(resp)
+		return "", ntlmError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+	}
+
+	scheme, token := authenticateHeader(resp, "Negotiate", "NTLM")
+	discardBody(resp)
+	if token == nil {
+		return "", ntlmError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+	}
+
+	auth, session, err := ntlmAuthenticate(token, t.username, t.password)
+	if err != nil {
+		return "", err
+	}
+
+	resp, err = t.send(httpClient, handshakeBody, scheme+" "+base64.StdEncoding.EncodeToString(auth))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		discardBody(resp)
+		return "", ntlmError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+	}
+	conn.authenticated = true
+	if !t.encrypt {
+		return readSoapResponse(resp)
+	}
+	discardBody(resp)
+
+	conn.sealer = &session
+	resp, err = t.sendEncrypted(httpClient, conn.sealer, spnegoEncryptedProtocol, body)
+	if err != nil {
+		return "", err
+	}
+	return readEncryptedResponse(resp, conn.sealer)
+}
+
+// close closes the authenticated connections
+func (t *ntlmTransport) close() {
+	t.conns.close()
+}
+
+// kerberosTransport authenticates WinRM requests with Kerberos through
+// SPNEGO, messages are encrypted when not using HTTPS
+type kerberosTransport struct {
+	httpSettings
+	krb     *client.Client
+	spn     string
+	encrypt bool
+	conns   authConns
+}
+
+// Transport configures the transport for the endpoint
+func (t *kerberosTransport) Transport(endpoint *winrm.Endpoint) error {
+	settings, err := newHTTPSettings(endpoint, t.dial)
+	if err != nil {
+		return err
+	}
+	t.httpSettings = settings
+	return nil
+}
+
+// Post sends the request to the WinRM service over an authenticated
+// connection, authenticating one first when needed
+func (t *kerberosTransport) Post(client *winrm.Client, request *soap.SoapMessage) (string, error) {
+	conn := t.conns.get(t.httpSettings)
+	httpClient := t.newClient(client, conn.transport)
+	body := request.String()
+	response, err := t.conns.post(t.httpSettings, httpClient, conn, body, func() (string, error) {
+		return t.authenticate(httpClient, conn, body)
+	})
+	t.conns.put(conn, err)
+	return response, err
+}
+
+// authenticate sends a service ticket over conn along with body
+func (t *kerberosTransport) authenticate(httpClient *http.Client, conn *authConn, body string) (string, error) {
+	if err := t.krb.AffirmLogin(); err != nil {
+		return "", fmt.Errorf("Kerberos login failed: %v", err)
+	}
+	tkt, key, err := t.krb.GetServiceTicket(t.spn)
+	if err != nil {
+		return "", fmt.Errorf("Error getting Kerberos ticket for %s: %v", t.spn, err)
+	}
+	token, session, err := kerberosInitiate(t.krb, tkt, key)
+	if err != nil {
+		return "", err
+	}
+	authorization := "Negotiate " + base64.StdEncoding.EncodeToString(token)
+
+	if !t.encrypt {
+		resp, err := t.send(httpClient, body, authorization)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode == http.StatusUnauthorized {
+			discardBody(resp)
+			return "", kerberosError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+		}
+		conn.authenticated = true
+		return readSoapResponse(resp)
+	}
+
+	// authenticate with an empty message to set up the keys used to encrypt
+	// the request
+	resp, err := t.send(httpClient, "", authorization)
+	if err != nil {
+		return "", err
+	}
+	discardBody(resp)
+	if resp.StatusCode != http.StatusOK {
+		return "", kerberosError{status: resp.StatusCode, scheme: strings.Join(resp.Header["Www-Authenticate"], ", ")}
+	}
+	_, reply := authenticateHeader(resp, "Negotiate", "Kerberos")
+	if reply == nil {
+		return "", errors.New("Server did not return a Kerberos reply")
+	}
+	if err := session.acceptReply(reply); err != nil {
+		return "", err
+	}
+	conn.authenticated = true
+	conn.sealer = session
+
+	resp, err = t.sendEncrypted(httpClient, conn.sealer, spnegoEncryptedProtocol, body)
+	if err != nil {
+		return "", err
+	}
+	return readEncryptedResponse(resp, conn.sealer)
+}
+
+// close closes the authenticated connections
+func (t *kerberosTransport) close() {
+	t.conns.close()
+}
+
+// basicError is returned when the server rejects the credentials or client
+// certificate
+type basicError struct {
+	status int
+	scheme string
+}
+
+func (e basicError) Error() string {
+	return fmt.Sprintf("Authentication failed: http error %d, server offered %q", e.status, e.scheme)
+}
+
+// httpError is returned when WinRM answers a request with an error status,
+// fault is the WS-Management fault code of the answer, such as w:TimedOut
+type httpError struct {
+	status  int
+	fault   string
+	message string
+}
+
+func (e httpError) Error() string {
+	return e.message
+}
+
+// newHTTPError returns the error for a WinRM answer with an error status
+// and body
+func newHTTPError(status int, body []byte) httpError {
+	var envelope struct {
+		Fault string `xml:"Body>Fault>Code>Subcode>Value"`
+	}
+	xml.Unmarshal(body, &envelope)
+	return httpError{status: status, fault: strings.TrimSpace(envelope.Fault), message: fmt.Sprintf("http error %d: %s", status, body)}
+}
+
+func (s httpSettings) send(httpClient *http.Client, body, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest("POST", s.url, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("impossible to create http request %s", err)
+	}
+	req.Header.Set("Content-Type", soapContentType)
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	return resp, nil
+}
+
+// sendEncrypted sends body sealed with the keys of the connection's
+// authenticated session
+func (s httpSettings) sendEncrypted(httpClient *http.Client, sealer sealer, protocol, body string) (*http.Response, error) {
+	encrypted, err := encryptMessage(sealer, protocol, []byte(body))
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", s.url, bytes.NewReader(encrypted))
+	if err != nil {
+		return nil, fmt.Errorf("impossible to create http request %s", err)
+	}
+	req.Header.Set("Content-Type", encryptedContentType(protocol))
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unknown error %w", err)
+	}
+	return resp, nil
+}
+
+// authenticateHeader returns the first of the schemes offered by the server
+// along with its decoded token
+func authenticateHeader(resp *http.Response, schemes ...string) (string, []byte) {
+	for _, scheme := range schemes {
+		for _, v := range resp.Header["Www-Authenticate"] {
+			if !strings.HasPrefix(v, scheme+" ") {
+				continue
+			}
+			token, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v[len(scheme)+1:]))
+			if err != nil {
+				continue
+			}
+			return scheme, token
+		}
+	}
+	return "", nil
+}
+
+// readSoapResponse returns the body of a WinRM response in the same way as
+// the default winrm transport
+func readSoapResponse(resp *http.Response) (string, error) {
+	defer resp.Body.Close()
+
+	if !strings.Contains(resp.Header.Get("Content-Type"), "application/soap+xml") {
+		return "", httpError{status: resp.StatusCode, message: fmt.Sprintf("http response error: %d - invalid content type", resp.StatusCode)}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("http response error: %d - error while reading request body %w", resp.StatusCode, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newHTTPError(resp.StatusCode, body)
+	}
+
+	return string(body), nil
+}
+
+// readEncryptedResponse decrypts the body of a WinRM response before
+// reading it as readSoapResponse does
+func readEncryptedResponse(resp *http.Response, sealer sealer) (string, error) {
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "multipart/encrypted") {
+		if resp.StatusCode == http.StatusUnauthorized {
+			return "", httpError{status: resp.StatusCode, message: fmt.Sprintf("http error %d: the server did not accept the encrypted message", resp.StatusCode)}
+		}
+		return "", httpError{status: resp.StatusCode, message: fmt.Sprintf("http response error: %d - invalid content type", resp.StatusCode)}
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("http response error: %d - error while reading request body %w", resp.StatusCode, err)
+	}
+	message, err := decryptMessage(sealer, body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", newHTTPError(resp.StatusCode, message)
+	}
+
+	return string(message), nil
+}
+
+func discardBody(resp *http.Response) {
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+}
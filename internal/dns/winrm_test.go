@@ -0,0 +1,645 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+	"unicode/utf16"
+)
+
+var (
+	testEncodedCommand = regexp.MustCompile(`-EncodedCommand ([A-Za-z0-9+/=]+)`)
+	testCommandID      = regexp.MustCompile(`CommandId="([^"]+)"`)
+	testStdinStream    = regexp.MustCompile(`<rsp:Stream[^>]*>([A-Za-z0-9+/=]*)</rsp:Stream>`)
+	testArguments      = regexp.MustCompile(`<rsp:Arguments>([A-Za-z0-9+/=]*)</rsp:Arguments>`)
+	testResourceURI    = regexp.MustCompile(`ResourceURI[^>]*>([^<]*)<`)
+	testCLIXMLEscape   = regexp.MustCompile(`_x([0-9A-F]{4})_`)
+)
+
+const (
+	testPSRPResourceURI       = "http://schemas.microsoft.com/powershell/Microsoft.PowerShell"
+	testPSRPResourceURIPrefix = "http://schemas.microsoft.com/powershell/"
+)
+
+// testWinRM is a WinRM listener stand-in using basic authentication,
+// scripts run with powershell.exe -EncodedCommand, sent to a persistent
+// session or invoked in a PowerShell remoting runspace pool are answered by
+// run
+type testWinRM struct {
+	*httptest.Server
+	t   *testing.T
+	run func(script string) (stdout, stderr string)
+
+	mu       sync.Mutex
+	scripts  []string
+	commands map[string]*testCommand
+	shells   int
+	closed   int
+	// fail is the number of following requests answered with an error
+	// that is not a SOAP message
+	fail int
+	// noPSRP refuses to open runspace pools as a server with the
+	// PowerShell remoting endpoint disabled does
+	noPSRP bool
+	// configuration is the resource URI of the only session configuration
+	// runspace pools are opened in when set, it runs commands but refuses
+	// scripts as a JEA endpoint in NoLanguage mode does
+	configuration string
+	// pool holds PSRP fragments not yet received by the runspace pool
+	pool []byte
+}
+
+// testCommand holds the output of a command not yet received
+type testCommand struct {
+	session bool
+	// loader reads one script from stdin up to an empty line
+	loader bool
+	// input holds stdin not yet read by the command
+	input  string
+	stdout string
+	stderr string
+	done   bool
+}
+
+func newTestWinRM(t *testing.T, run func(script string) (string, string)) *testWinRM {
+	w := &testWinRM{t: t, run: run, commands: map[string]*testCommand{}}
+	w.Server = httptest.NewServer(w)
+	return w
+}
+
+// newTestWinRMAt starts the listener on addr, to bring back a server that
+// was closed
+func newTestWinRMAt(t *testing.T, addr string, run func(script string) (string, string)) *testWinRM {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	w := &testWinRM{t: t, run: run, commands: map[string]*testCommand{}}
+	w.Server = httptest.NewUnstartedServer(w)
+	w.Server.Listener.Close()
+	w.Server.Listener = l
+	w.Server.Start()
+	return w
+}
+
+// config returns the client settings for connecting to the listener
+func (w *testWinRM) config() Client {
+	u, _ := url.Parse(w.URL)
+	port, _ := strconv.Atoi(u.Port())
+	return Client{
+		ServerName: u.Hostname(),
+		Port:       port,
+		Username:   "user",
+		Password:   "pass",
+	}
+}
+
+// testConfigure configures a copy of c as the provider does, returning the
+// client ready to run scripts
+func testConfigure(c Client) (*Client, error) {
+	if err := c.ConfigureWinRMClient(); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// Scripts returns the scripts run so far
+func (w *testWinRM) Scripts() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return append([]string{}, w.scripts...)
+}
+
+// Shells returns the number of shells created and closed
+func (w *testWinRM) Shells() (int, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.shells, w.closed
+}
+
+// Fail answers the next n requests with an internal server error
+func (w *testWinRM) Fail(n int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.fail = n
+}
+
+func (w *testWinRM) ServeHTTP(rw http.ResponseWriter, r *http.Request) {
+	if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+		rw.Header().Add("WWW-Authenticate", "Negotiate")
+		rw.Header().Add("WWW-Authenticate", `Basic realm="WSMAN"`)
+		rw.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	body, _ := ioutil.ReadAll(r.Body)
+
+	w.mu.Lock()
+	if w.fail > 0 {
+		w.fail--
+		w.mu.Unlock()
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.mu.Unlock()
+
+	rw.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+	if m := testResourceURI.FindSubmatch(body); m != nil && strings.HasPrefix(string(m[1]), testPSRPResourceURIPrefix) {
+		if uri := string(m[1]); (w.configuration == "" && uri != testPSRPResourceURI) || (w.configuration != "" && uri != w.configuration) {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(testResourceNotFoundFault))
+			return
+		}
+		w.servePSRP(rw, body)
+		return
+	}
+	switch {
+	case bytes.Contains(body, []byte("transfer/Create")):
+		w.mu.Lock()
+		w.shells++
+		w.mu.Unlock()
+		rw.Write([]byte(testOpenShellResponse))
+	case bytes.Contains(body, []byte("shell/Command")):
+		m := testEncodedCommand.FindSubmatch(body)
+		if m == nil {
+			w.t.Errorf("Command is not an encoded PowerShell script: %s", body)
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if len(m[0]) > 8191 {
+			w.t.Errorf("Command line of %d characters is too long for Windows", len(m[0]))
+		}
+		encoded, _ := base64.StdEncoding.DecodeString(string(m[1]))
+		script := testFromUTF16(encoded)
+
+		cmd := &testCommand{
+			loader:  strings.Contains(script, "IsNullOrEmpty($line)"),
+			session: strings.Contains(script, "[Console]::In.ReadLine()"),
+		}
+		if !cmd.session {
+			cmd.stdout, cmd.stderr = w.runScript(script)
+			cmd.done = true
+		}
+		w.mu.Lock()
+		id := fmt.Sprintf("66666666-7777-8888-9999-%012d", len(w.commands))
+		w.commands[id] = cmd
+		w.mu.Unlock()
+
+		rw.Write([]byte(fmt.Sprintf(testCommandResponseTemplate, id)))
+	case bytes.Contains(body, []byte("shell/Send")):
+		id := string(testCommandID.FindSubmatch(body)[1])
+		stdin, _ := base64.StdEncoding.DecodeString(string(testStdinStream.FindSubmatch(body)[1]))
+		w.mu.Lock()
+		cmd := w.commands[id]
+		cmd.input += string(stdin)
+		w.mu.Unlock()
+		w.readInput(cmd)
+		rw.Write([]byte(testEmptyResponse))
+	case bytes.Contains(body, []byte("shell/Receive")):
+		id := string(testCommandID.FindSubmatch(body)[1])
+
+		// hold the request until there is output as the WinRM service
+		// does, up to a short operation timeout
+		var stdout, stderr string
+		var done bool
+		for i := 0; i < 20; i++ {
+			w.mu.Lock()
+			cmd := w.commands[id]
+			stdout, stderr, done = cmd.stdout, cmd.stderr, cmd.done
+			cmd.stdout, cmd.stderr = "", ""
+			w.mu.Unlock()
+			if stdout != "" || stderr != "" || done {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if stdout == "" && stderr == "" && !done {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(testOperationTimeoutFault))
+			return
+		}
+
+		state := "Running"
+		if done {
+			state = "Done"
+		}
+		rw.Write([]byte(fmt.Sprintf(testReceiveResponseTemplate,
+			id, base64.StdEncoding.EncodeToString([]byte(stdout)),
+			id, base64.StdEncoding.EncodeToString([]byte(stderr)),
+			id, state)))
+	case bytes.Contains(body, []byte("shell/Signal")):
+		id := string(testCommandID.FindSubmatch(body)[1])
+		w.mu.Lock()
+		w.commands[id].done = true
+		w.mu.Unlock()
+		rw.Write([]byte(testEmptyResponse))
+	case bytes.Contains(body, []byte("transfer/Delete")):
+		w.mu.Lock()
+		w.closed++
+		w.mu.Unlock()
+		rw.Write([]byte(testEmptyResponse))
+	default:
+		rw.Write([]byte(testEmptyResponse))
+	}
+}
+
+// readInput runs the scripts completed by the input sent to a command, one
+// per line for a persistent session or a script split over lines ending
+// with an empty line for the loader
+func (w *testWinRM) readInput(cmd *testCommand) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if cmd.loader {
+		i := strings.Index(cmd.input, "\r\n\r\n")
+		if i < 0 {
+			return
+		}
+		script, _ := base64.StdEncoding.DecodeString(strings.Replace(cmd.input[:i], "\r\n", "", -1))
+		w.mu.Unlock()
+		stdout, stderr := w.runScript(string(script))
+		w.mu.Lock()
+		cmd.stdout, cmd.stderr, cmd.done = stdout, stderr, true
+		return
+	}
+
+	for {
+		i := strings.Index(cmd.input, "\n")
+		if i < 0 {
+			return
+		}
+		line := strings.TrimSpace(cmd.input[:i])
+		cmd.input = cmd.input[i+1:]
+		if line == "" {
+			continue
+		}
+		script, _ := base64.StdEncoding.DecodeString(line)
+		w.mu.Unlock()
+		stdout, stderr := w.runScript(string(script))
+		w.mu.Lock()
+		cmd.stdout += fmt.Sprintf("#DNS-RESULT# %s %s\r\n",
+			base64.StdEncoding.EncodeToString([]byte(stdout)),
+			base64.StdEncoding.EncodeToString([]byte(stderr)))
+	}
+}
+
+// servePSRP answers requests for the PowerShell remoting plugin, pipelines
+// return the output of run as a string and its errors as an error record
+func (w *testWinRM) servePSRP(rw http.ResponseWriter, body []byte) {
+	switch {
+	case bytes.Contains(body, []byte("transfer/Create")):
+		if w.noPSRP {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(testResourceNotFoundFault))
+			return
+		}
+		w.mu.Lock()
+		w.shells++
+		w.pool = testPSRPMessage(0x00021005, `<Obj RefId="0"><MS><I32 N="RunspaceState">2</I32></MS></Obj>`)
+		w.mu.Unlock()
+		rw.Write([]byte(testOpenShellResponse))
+	case bytes.Contains(body, []byte("shell/Command")):
+		arguments, _ := base64.StdEncoding.DecodeString(string(testArguments.FindSubmatch(body)[1]))
+		cmd := &testCommand{input: string(arguments)}
+		w.mu.Lock()
+		id := fmt.Sprintf("66666666-7777-8888-9999-%012d", len(w.commands))
+		w.commands[id] = cmd
+		w.mu.Unlock()
+		w.runPipeline(cmd)
+		rw.Write([]byte(fmt.Sprintf(testCommandResponseTemplate, id)))
+	case bytes.Contains(body, []byte("shell/Send")):
+		id := string(testCommandID.FindSubmatch(body)[1])
+		stdin, _ := base64.StdEncoding.DecodeString(string(testStdinStream.FindSubmatch(body)[1]))
+		w.mu.Lock()
+		cmd := w.commands[id]
+		cmd.input += string(stdin)
+		w.mu.Unlock()
+		w.runPipeline(cmd)
+		rw.Write([]byte(testEmptyResponse))
+	case bytes.Contains(body, []byte("shell/Receive")):
+		var output []byte
+		var state string
+		w.mu.Lock()
+		if m := testCommandID.FindSubmatch(body); m != nil {
+			cmd := w.commands[string(m[1])]
+			output, state = []byte(cmd.stdout), "Done"
+			cmd.stdout = ""
+		} else {
+			output, state = w.pool, "Running"
+			w.pool = nil
+		}
+		w.mu.Unlock()
+		if len(output) == 0 {
+			rw.WriteHeader(http.StatusInternalServerError)
+			rw.Write([]byte(testOperationTimeoutFault))
+			return
+		}
+		rw.Write([]byte(fmt.Sprintf(testPSRPReceiveResponseTemplate, base64.StdEncoding.EncodeToString(output), state)))
+	case bytes.Contains(body, []byte("transfer/Delete")):
+		w.mu.Lock()
+		w.closed++
+		w.mu.Unlock()
+		rw.Write([]byte(testEmptyResponse))
+	default:
+		rw.Write([]byte(testEmptyResponse))
+	}
+}
+
+// runPipeline runs the script of a pipeline once all fragments of its
+// CREATE_PIPELINE message were sent
+func (w *testWinRM) runPipeline(cmd *testCommand) {
+	w.mu.Lock()
+	fragments := []byte(cmd.input)
+	w.mu.Unlock()
+	if !testPSRPComplete(fragments) {
+		return
+	}
+
+	script, isScript, err := testPipeline(testPSRPData(fragments))
+	if err != nil {
+		w.t.Errorf("Command does not create a pipeline: %s", err)
+		return
+	}
+
+	var stdout, stderr string
+	if isScript && w.configuration != "" {
+		stderr = "The syntax is not supported by this runspace. This can occur if the runspace is in no-language mode."
+	} else {
+		stdout, stderr = w.runScript(script)
+	}
+	var output []byte
+	switch {
+	case strings.HasPrefix(stdout, "<Obj"):
+		// objects are written one per line as CLIXML
+		for _, o := range strings.Split(stdout, "\n") {
+			output = append(output, testPSRPMessage(0x00041004, o)...)
+		}
+	case stdout != "":
+		output = append(output, testPSRPMessage(0x00041004, "<S>"+testCLIXMLString(stdout)+"</S>")...)
+	}
+	if stderr != "" {
+		output = append(output, testPSRPMessage(0x00041005, fmt.Sprintf(testErrorRecordTemplate, testCLIXMLString(stderr), testCLIXMLString(stderr)))...)
+	}
+	output = append(output, testPSRPMessage(0x00041006, `<Obj RefId="0"><MS><I32 N="PipelineState">4</I32></MS></Obj>`)...)
+
+	w.mu.Lock()
+	cmd.input, cmd.stdout, cmd.done = "", string(output), true
+	w.mu.Unlock()
+}
+
+// testCLIXMLNode is an element of a CLIXML document
+type testCLIXMLNode struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr       `xml:",any,attr"`
+	Content string           `xml:",chardata"`
+	Nodes   []testCLIXMLNode `xml:",any"`
+}
+
+// property returns the child of an object or its member set named name
+func (n *testCLIXMLNode) property(name string) *testCLIXMLNode {
+	for i := range n.Nodes {
+		child := &n.Nodes[i]
+		if child.XMLName.Local == "MS" {
+			if p := child.property(name); p != nil {
+				return p
+			}
+		}
+		for _, a := range child.Attrs {
+			if a.Name.Local == "N" && a.Value == name {
+				return child
+			}
+		}
+	}
+	return nil
+}
+
+// list returns the items of a list object
+func (n *testCLIXMLNode) list() []testCLIXMLNode {
+	for _, child := range n.Nodes {
+		if child.XMLName.Local == "LST" {
+			return child.Nodes
+		}
+	}
+	return nil
+}
+
+// testPipeline returns the script of a CREATE_PIPELINE message, or its
+// commands written as a script when they are not scripts themselves
+func testPipeline(data []byte) (string, bool, error) {
+	var root testCLIXMLNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return "", false, err
+	}
+	powershell := root.property("PowerShell")
+	if powershell == nil || powershell.property("Cmds") == nil {
+		return "", false, fmt.Errorf("no commands in %q", data)
+	}
+
+	var commands []string
+	isScript := false
+	for _, cmd := range powershell.property("Cmds").list() {
+		text := testCLIXMLUnescape(cmd.property("Cmd").Content)
+		if cmd.property("IsScript").Content == "true" {
+			isScript = true
+		}
+		for _, arg := range cmd.property("Args").list() {
+			name, value := arg.property("N"), arg.property("V")
+			switch {
+			case name.XMLName.Local == "Nil":
+				text += " " + testPSValue(value)
+			case value.XMLName.Local == "B":
+				text += " -" + testCLIXMLUnescape(name.Content) + ":$" + value.Content
+			default:
+				text += " -" + testCLIXMLUnescape(name.Content) + " " + testPSValue(value)
+			}
+		}
+		commands = append(commands, text)
+	}
+	return strings.Join(commands, " | "), isScript, nil
+}
+
+// testPSValue writes a deserialised argument as a PowerShell literal
+func testPSValue(n *testCLIXMLNode) string {
+	switch n.XMLName.Local {
+	case "S":
+		return "'" + strings.Replace(testCLIXMLUnescape(n.Content), "'", "''", -1) + "'"
+	case "B":
+		return "$" + n.Content
+	case "Nil":
+		return "$null"
+	case "Obj":
+		var items []string
+		for _, item := range n.list() {
+			items = append(items, testPSValue(&item))
+		}
+		if len(items) == 0 {
+			return "@()"
+		}
+		return strings.Join(items, ",")
+	default:
+		return n.Content
+	}
+}
+
+// testCLIXMLUnescape reverses the _xHHHH_ escaping of CLIXML strings
+func testCLIXMLUnescape(s string) string {
+	return testCLIXMLEscape.ReplaceAllStringFunc(s, func(s string) string {
+		c, _ := strconv.ParseUint(s[2:6], 16, 16)
+		return string(rune(c))
+	})
+}
+
+// testPSRPMessage returns a PSRP message from the server in one fragment
+func testPSRPMessage(messageType uint32, data string) []byte {
+	message := make([]byte, 40)
+	binary.LittleEndian.PutUint32(message[0:], 1)
+	binary.LittleEndian.PutUint32(message[4:], messageType)
+	message = append(message, data...)
+
+	fragment := make([]byte, 21)
+	fragment[16] = 3
+	binary.BigEndian.PutUint32(fragment[17:], uint32(len(message)))
+	return append(fragment, message...)
+}
+
+// testCLIXMLString escapes s for a CLIXML string as PowerShell does
+func testCLIXMLString(s string) string {
+	var escaped bytes.Buffer
+	for i, r := range s {
+		switch {
+		case r == '_' && strings.HasPrefix(s[i+1:], "x"):
+			escaped.WriteString("_x005F_")
+		case r < 0x20 && r != '\t' && r != '\n' && r != '\r':
+			fmt.Fprintf(&escaped, "_x%04X_", r)
+		default:
+			xml.EscapeText(&escaped, []byte(string(r)))
+		}
+	}
+	return escaped.String()
+}
+
+// testPSRPComplete returns if the last of the fragments ends a message
+func testPSRPComplete(fragments []byte) bool {
+	complete := false
+	for len(fragments) >= 21 {
+		length := int(binary.BigEndian.Uint32(fragments[17:]))
+		complete = fragments[16]&2 != 0
+		fragments = fragments[21+length:]
+	}
+	return complete
+}
+
+// testPSRPData returns the data of the PSRP messages in fragments
+func testPSRPData(fragments []byte) []byte {
+	var data []byte
+	for len(fragments) >= 21 {
+		length := int(binary.BigEndian.Uint32(fragments[17:]))
+		message := fragments[21 : 21+length]
+		if fragments[16]&1 != 0 {
+			message = message[40:]
+		}
+		data = append(data, message...)
+		fragments = fragments[21+length:]
+	}
+	return data
+}
+
+// runScript answers a script and records it was run
+func (w *testWinRM) runScript(script string) (string, string) {
+	stdout, stderr := w.run(script)
+
+	w.mu.Lock()
+	w.scripts = append(w.scripts, script)
+	w.mu.Unlock()
+	return stdout, stderr
+}
+
+const testCommandResponseTemplate = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<s:Body><rsp:CommandResponse><rsp:CommandId>%s</rsp:CommandId></rsp:CommandResponse></s:Body>
+</s:Envelope>`
+
+const testReceiveResponseTemplate = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<s:Body><rsp:ReceiveResponse>
+<rsp:Stream Name="stdout" CommandId="%s">%s</rsp:Stream>
+<rsp:Stream Name="stderr" CommandId="%s">%s</rsp:Stream>
+<rsp:CommandState CommandId="%s" State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/%s"><rsp:ExitCode>0</rsp:ExitCode></rsp:CommandState>
+</rsp:ReceiveResponse></s:Body>
+</s:Envelope>`
+
+const testPSRPReceiveResponseTemplate = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:rsp="http://schemas.microsoft.com/wbem/wsman/1/windows/shell">
+<s:Body><rsp:ReceiveResponse>
+<rsp:Stream Name="stdout">%s</rsp:Stream>
+<rsp:CommandState State="http://schemas.microsoft.com/wbem/wsman/1/windows/shell/CommandState/%s"></rsp:CommandState>
+</rsp:ReceiveResponse></s:Body>
+</s:Envelope>`
+
+const testErrorRecordTemplate = `<Obj RefId="0"><TN RefId="0"><T>System.Management.Automation.ErrorRecord</T><T>System.Object</T></TN>` +
+	`<ToString>%s</ToString><MS><Obj N="Exception" RefId="1"><Props><S N="Message">%s</S></Props></Obj>` +
+	`<S N="FullyQualifiedErrorId">PSRPTest</S><S N="ErrorCategory_Message">NotSpecified: (:) [], CimException</S></MS></Obj>`
+
+const testResourceNotFoundFault = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+<s:Body><s:Fault><s:Code><s:Value>s:Sender</s:Value><s:Subcode><s:Value>w:DestinationUnreachable</s:Value></s:Subcode></s:Code>
+<s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot process the request because the resource URI was not found.</s:Text></s:Reason></s:Fault></s:Body>
+</s:Envelope>`
+
+// testSlowCommandHandler emulates a WinRM listener running a command that
+// never finishes, output requests fail with the WS-Management operation
+// timeout fault which is expected to be operationTimeout
+func testSlowCommandHandler(t *testing.T, operationTimeout string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/soap+xml;charset=UTF-8")
+
+		switch {
+		case bytes.Contains(body, []byte("transfer/Create")):
+			w.Write([]byte(testOpenShellResponse))
+		case bytes.Contains(body, []byte("shell/Command")):
+			w.Write([]byte(fmt.Sprintf(testCommandResponseTemplate, "66666666-7777-8888-9999-000000000000")))
+		case bytes.Contains(body, []byte("shell/Receive")):
+			if !bytes.Contains(body, []byte("<w:OperationTimeout>"+operationTimeout+"</w:OperationTimeout>")) {
+				t.Errorf("Expected operation timeout %s: %s", operationTimeout, body)
+			}
+			time.Sleep(100 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte(testOperationTimeoutFault))
+		default:
+			w.Write([]byte(testEmptyResponse))
+		}
+	})
+}
+
+func testToUTF16(s string) []byte {
+	b := &bytes.Buffer{}
+	binary.Write(b, binary.LittleEndian, utf16.Encode([]rune(s)))
+	return b.Bytes()
+}
+
+func testFromUTF16(b []byte) string {
+	u := make([]uint16, len(b)/2)
+	binary.Read(bytes.NewReader(b), binary.LittleEndian, u)
+	return string(utf16.Decode(u))
+}
+
+const testOpenShellResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+<s:Header><w:SelectorSet><w:Selector Name="ShellId">11111111-2222-3333-4444-555555555555</w:Selector></w:SelectorSet></s:Header>
+<s:Body/>
+</s:Envelope>`
+
+const testOperationTimeoutFault = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope" xmlns:w="http://schemas.dmtf.org/wbem/wsman/1/wsman.xsd">
+<s:Body><s:Fault><s:Code><s:Value>s:Receiver</s:Value><s:Subcode><s:Value>w:TimedOut</s:Value></s:Subcode></s:Code>
+<s:Reason><s:Text xml:lang="en-US">The WS-Management service cannot complete the operation within the time specified in OperationTimeout.</s:Text></s:Reason></s:Fault></s:Body>
+</s:Envelope>`
+
+const testEmptyResponse = `<s:Envelope xmlns:s="http://www.w3.org/2003/05/soap-envelope"><s:Body/></s:Envelope>`
@@ -0,0 +1,191 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// zoneSnapshotTTL is how long a zone is answered from its snapshot, long
+// enough for a refresh to read its records from one fetch while changes made
+// outside Terraform are seen by later reads in a long running provider
+const zoneSnapshotTTL = 30 * time.Second
+
+// zoneCache holds the A and CNAME records of every zone read, so reading many
+// records from a zone fetches it once instead of running a script per name
+type zoneCache struct {
+	mu    sync.Mutex
+	zones map[string]*zoneSnapshot
+	// ttl replaces zoneSnapshotTTL when set
+	ttl time.Duration
+}
+
+// zoneSnapshot is the content of a zone, ready is closed once it has been
+// fetched
+type zoneSnapshot struct {
+	ready   chan struct{}
+	err     error
+	records map[string][]Record
+	fetched time.Time
+	// stale names were changed after the zone was fetched and are read
+	// from the server again, generation counts the changes to a name so a
+	// read racing a change does not store what it saw before it
+	stale      map[string]bool
+	generation map[string]int
+}
+
+// records returns the records of name in zone, fetching the zone when it has
+// not been read yet or its snapshot expired, and reading name again when it
+// was changed since
+func (z *zoneCache) records(c *Client, rec Record) ([]Record, error) {
+	zone, name := strings.ToLower(rec.Dnszone), strings.ToLower(rec.Name)
+
+	z.mu.Lock()
+	snapshot, ok := z.zones[zone]
+	if ok && z.expired(snapshot) {
+		ok = false
+	}
+	if !ok {
+		snapshot = &zoneSnapshot{
+			ready:      make(chan struct{}),
+			stale:      map[string]bool{},
+			generation: map[string]int{},
+		}
+		z.zones[zone] = snapshot
+	}
+	z.mu.Unlock()
+
+	if !ok {
+		snapshot.fetched = time.Now()
+		snapshot.records, snapshot.err = c.queryZone(rec.Dnszone)
+		if snapshot.err != nil {
+			// errors are not kept, the next read tries again
+			z.mu.Lock()
+			if z.zones[zone] == snapshot {
+				delete(z.zones, zone)
+			}
+			z.mu.Unlock()
+		}
+		close(snapshot.ready)
+	}
+	<-snapshot.ready
+	if snapshot.err != nil {
+		return nil, snapshot.err
+	}
+
+	z.mu.Lock()
+	if !snapshot.stale[name] {
+		records := snapshot.records[name]
+		z.mu.Unlock()
+		if len(records) == 0 {
+			return nil, fmt.Errorf("No Record found: %v", rec.Name)
+		}
+		return withZone(records, rec.Dnszone), nil
+	}
+	generation := snapshot.generation[name]
+	z.mu.Unlock()
+
+	records, err := c.queryRecords(rec)
+	if err != nil && !strings.HasPrefix(err.Error(), "No Record found") {
+		return nil, err
+	}
+	z.mu.Lock()
+	if snapshot.generation[name] == generation {
+		snapshot.records[name] = records
+		delete(snapshot.stale, name)
+	}
+	z.mu.Unlock()
+	return records, err
+}
+
+// expired returns if snapshot was fetched longer than the TTL ago, snapshots
+// still being fetched have not expired
+func (z *zoneCache) expired(snapshot *zoneSnapshot) bool {
+	ttl := z.ttl
+	if ttl <= 0 {
+		ttl = zoneSnapshotTTL
+	}
+	select {
+	case <-snapshot.ready:
+		return time.Since(snapshot.fetched) > ttl
+	default:
+		return false
+	}
+}
+
+// invalidate marks the records of name in zone as changed
+func (z *zoneCache) invalidate(zone, name string) {
+	zone, name = strings.ToLower(zone), strings.ToLower(name)
+
+	z.mu.Lock()
+	defer z.mu.Unlock()
+	snapshot, ok := z.zones[zone]
+	if !ok {
+		return
+	}
+	select {
+	case <-snapshot.ready:
+		snapshot.stale[name] = true
+		snapshot.generation[name]++
+	default:
+		// a fetch still running may have missed the change
+		delete(z.zones, zone)
+	}
+}
+
+// queryZone reads all A and CNAME records of zone by name
+func (c *Client) queryZone(zone string) (map[string][]Record, error) {
+	const tmplpscript = `
+Get-DnsServerResourceRecord -ZoneName {{.Dnszone}} | ?{$_.RecordType -eq 'A' -or $_.RecordType -eq 'CNAME'} | select DistinguishedName, HostName, RecordData, RecordType, TimeToLive | ConvertTo-Json
+`
+	// restricted endpoints return all records as objects, those of other
+	// types are skipped by recordObjects
+	const tmplpscriptRestricted = `
+Get-DnsServerResourceRecord -ZoneName {{.Dnszone}}
+`
+	tmpl := tmplpscript
+	if c.restricted() {
+		tmpl = tmplpscriptRestricted
+	}
+
+	rec := Record{Dnszone: zone}
+	pscript, err := tmplExec(rec, tmpl)
+	if err != nil {
+		return nil, fmt.Errorf("Creating template: %v", err)
+	}
+	output, err := c.ExecutePowerShellScript(pscript)
+	if err != nil {
+		return nil, fmt.Errorf("Running PowerShell script: %v", err)
+	}
+
+	records := map[string][]Record{}
+	var found []Record
+	switch {
+	case c.restricted():
+		found = recordObjects(output.objects, zone)
+	case output.stdout != "":
+		resp, err := unmarshalResponse(makeResponseArray(output.stdout))
+		if err != nil {
+			return nil, fmt.Errorf("Unmarshalling response: %v", err)
+		}
+		found = *convertResponse(resp, rec)
+	}
+	for _, r := range found {
+		name := strings.ToLower(r.Name)
+		records[name] = append(records[name], r)
+	}
+	return records, nil
+}
+
+// withZone returns a copy of records with the zone written as it was asked
+// for, as a query by name returns them
+func withZone(records []Record, zone string) []Record {
+	result := make([]Record, len(records))
+	for i, r := range records {
+		r.Dnszone = zone
+		r.ID = fmt.Sprintf("%s|%s|%s", r.Dnszone, r.Name, r.Value)
+		result[i] = r
+	}
+	return result
+}
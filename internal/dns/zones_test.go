@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestClientZoneCache(t *testing.T) {
+	const recordTemplate = `{"HostName":"%s","RecordType":"A","RecordData":{"CimInstanceProperties":"IPv4Address = \"%s\""},"TimeToLive":{"TotalSeconds":600}}`
+	var mu sync.Mutex
+	zone := map[string]string{"web": "10.0.0.1", "db": "10.0.0.2", "mail": "10.0.0.3"}
+	server := newTestWinRM(t, func(script string) (string, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if m := regexp.MustCompile(`-Name (\w+) -A -IPv4Address ([\d.]+)`).FindStringSubmatch(script); m != nil {
+			zone[m[1]] = m[2]
+			return "", ""
+		}
+		var records []string
+		for name, value := range zone {
+			if strings.Contains(script, "-Name "+name+" ") || !strings.Contains(script, "-Name") {
+				records = append(records, fmt.Sprintf(recordTemplate, name, value))
+			}
+		}
+		if len(records) == 0 {
+			return "", ""
+		}
+		return "[" + strings.Join(records, ",") + "]", ""
+	})
+	defer server.Close()
+
+	c := server.config()
+	client, err := testConfigure(c)
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"web", "db", "mail"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if _, err := client.ReadRecordfromID("test.local|" + name + "|" + zone[name]); err != nil {
+				t.Errorf("Error reading %s: %s", name, err)
+			}
+		}(name)
+	}
+	wg.Wait()
+	if scripts := server.Scripts(); len(scripts) != 1 {
+		t.Fatalf("Expected the zone to be read once, ran %d scripts", len(scripts))
+	}
+	if client.RecordExist(Record{Dnszone: "test.local", Name: "ftp", Value: "10.0.0.4"}) {
+		t.Fatal("Expected ftp not to exist")
+	}
+
+	if _, err := client.CreateRecord(Record{Dnszone: "test.local", Name: "ftp", Type: "A", Value: "10.0.0.4", TTL: 600}); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	record, err := client.ReadRecordfromID("test.local|ftp|10.0.0.4")
+	if err != nil {
+		t.Fatalf("Expected the created record to be read again, got %s", err)
+	}
+	if record.Value != "10.0.0.4" {
+		t.Fatalf("Unexpected record: %v", record)
+	}
+	if _, err := client.ReadRecordfromID("test.local|db|10.0.0.2"); err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	// the zone, the add and reading the added name once
+	if scripts := server.Scripts(); len(scripts) != 3 {
+		t.Fatalf("Expected only the changed name to be read again, ran %d scripts: %q", len(scripts), scripts)
+	}
+
+	// a change made outside Terraform is seen once the snapshot expired
+	mu.Lock()
+	zone["db"] = "10.0.0.5"
+	mu.Unlock()
+	client.zones.ttl = time.Millisecond
+	time.Sleep(5 * time.Millisecond)
+	if _, err := client.ReadRecordfromID("test.local|db|10.0.0.5"); err != nil {
+		t.Fatalf("Expected the zone to be read again, got %s", err)
+	}
+	if scripts := server.Scripts(); len(scripts) != 4 || strings.Contains(scripts[3], "-Name") {
+		t.Fatalf("Expected the zone to be read again, ran %d scripts: %q", len(scripts), scripts)
+	}
+}
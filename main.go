@@ -11,4 +11,7 @@ func main() {
 			return Provider()
 		},
 	})
+
+	// Serve returns when Terraform is done with the plugin
+	closeClients()
 }
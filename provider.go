@@ -1,9 +1,19 @@
 package main
 
 import (
+	"sync"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
+// clients configured by this plugin, their persistent shells are closed
+// when it exits
+var (
+	clientsMu sync.Mutex
+	clients   []*dns.Client
+)
+
 // Provider allows making changes to Windows DNS server
 // Utilises Powershell to connect to domain controller
 func Provider() *schema.Provider {
@@ -11,25 +21,371 @@ func Provider() *schema.Provider {
 		Schema: map[string]*schema.Schema{
 			"server_name": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("WINRM_SERVER", nil),
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_SERVER", ""),
+			},
+
+			"servers": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Servers tried in order, used instead of server_name",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+
+			"sticky_server": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Keep using the server failed over to instead of going back to the first one",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_STICKY_SERVER", true),
 			},
 
 			"username": {
 				Type:        schema.TypeString,
-				Required:    true,
-				DefaultFunc: schema.EnvDefaultFunc("WINRM_USERNAME", nil),
+				Optional:    true,
+				Description: "Username, not needed when credential_command or vault returns one",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_USERNAME", ""),
 			},
 
 			"password": {
 				Type:        schema.TypeString,
-				Required:    true,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "Password, not needed when authenticating with a certificate, keytab or credential cache",
 				DefaultFunc: schema.EnvDefaultFunc("WINRM_PASSWORD", nil),
 			},
+
+			"password_file": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to a file holding the password on its first line",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_PASSWORD_FILE", ""),
+			},
+
+			"credential_command": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Command and arguments printing the username and password as a JSON object",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+
+			"vault": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Read the username and password from a HashiCorp Vault KV secret",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"address": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Vault address, defaults to VAULT_ADDR",
+						},
+						"token": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Sensitive:   true,
+							Description: "Vault token, defaults to VAULT_TOKEN or ~/.vault-token",
+						},
+						"namespace": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Vault namespace, defaults to VAULT_NAMESPACE",
+						},
+						"cacert_file": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Path to the PEM encoded CA certificate of Vault, defaults to VAULT_CACERT",
+						},
+						"path": {
+							Type:        schema.TypeString,
+							Required:    true,
+							Description: "Path of the secret, e.g. secret/data/dns for a KV version 2 secret",
+						},
+						"username_field": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Field of the secret holding the username",
+							Default:     "username",
+						},
+						"password_field": {
+							Type:        schema.TypeString,
+							Optional:    true,
+							Description: "Field of the secret holding the password",
+							Default:     "password",
+						},
+					},
+				},
+			},
+
+			"auth_type": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Authentication scheme, basic, ntlm or kerberos",
+				DefaultFunc:  schema.EnvDefaultFunc("WINRM_AUTH_TYPE", "basic"),
+				ValidateFunc: validateStringInSlice([]string{"basic", "ntlm", "kerberos"}),
+			},
+
+			"realm": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Kerberos realm, defaults to the realm of the username or the default realm in krb5.conf",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_REALM", ""),
+			},
+
+			"krb5_conf": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Path to the Kerberos configuration, defaults to /etc/krb5.conf",
+				DefaultFunc: schema.EnvDefaultFunc("KRB5_CONFIG", ""),
+			},
+
+			"keytab": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a keytab holding the key of username",
+				DefaultFunc:   schema.EnvDefaultFunc("WINRM_KEYTAB", ""),
+				ConflictsWith: []string{"ccache"},
+			},
+
+			"ccache": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a Kerberos credential cache holding a ticket granting ticket",
+				DefaultFunc:   schema.EnvDefaultFunc("KRB5CCNAME", ""),
+				ConflictsWith: []string{"keytab"},
+			},
+
+			"https": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_HTTPS", false),
+			},
+
+			"port": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "WinRM port, defaults to 5985 or 5986 when using HTTPS, or the SSH port, defaulting to 22",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_PORT", 0),
+			},
+
+			"insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Skip verification of the server certificate",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_INSECURE", false),
+			},
+
+			"cacert": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "PEM encoded CA certificate used to verify the server",
+				ConflictsWith: []string{"cacert_file"},
+			},
+
+			"cacert_file": {
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Path to a PEM encoded CA certificate used to verify the server",
+				DefaultFunc:   schema.EnvDefaultFunc("WINRM_CACERT", nil),
+				ConflictsWith: []string{"cacert"},
+			},
+
+			"cert": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "PEM encoded client certificate used to authenticate",
+			},
+
+			"key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PEM encoded private key of the client certificate",
+			},
+
+			"proxy_url": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "http, socks5 or socks5h URL of a proxy to connect through",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_PROXY_URL", ""),
+			},
+
+			"bastion_host": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "SSH server to connect from, as host or host:port",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_BASTION_HOST", ""),
+			},
+
+			"bastion_user": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_BASTION_USER", ""),
+			},
+
+			"bastion_password": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_BASTION_PASSWORD", ""),
+			},
+
+			"bastion_private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PEM encoded private key to authenticate to the bastion with",
+			},
+
+			"bastion_host_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Public key of the bastion in authorized_keys format, checked against ssh_known_hosts when unset",
+			},
+
+			"bastion_insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Do not check the host key of the bastion",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_BASTION_INSECURE", false),
+			},
+
+			"connection_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Time allowed to connect to the server as a duration",
+				DefaultFunc:  schema.EnvDefaultFunc("WINRM_CONNECTION_TIMEOUT", "60s"),
+				ValidateFunc: validateDuration,
+			},
+
+			"operation_timeout": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "Time the server is allowed to take answering a WinRM request as a duration",
+				DefaultFunc:  schema.EnvDefaultFunc("WINRM_OPERATION_TIMEOUT", "60s"),
+				ValidateFunc: validateDuration,
+			},
+
+			"max_retries": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Times a script failing with a transient WinRM or DNS server error is retried",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_MAX_RETRIES", 3),
+			},
+
+			"max_concurrent_operations": {
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Scripts run at once, 0 does not limit them",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_MAX_CONCURRENT_OPERATIONS", 5),
+			},
+
+			"wait_for_replication": {
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Wait for record changes to be seen by other DNS servers",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"servers": {
+							Type:        schema.TypeList,
+							Required:    true,
+							Description: "DNS servers checked for the change",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+						"timeout": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Time to wait for the change to replicate as a duration",
+							Default:      "5m",
+							ValidateFunc: validateDuration,
+						},
+						"interval": {
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Time between checks as a duration",
+							Default:      "10s",
+							ValidateFunc: validateDuration,
+						},
+					},
+				},
+			},
+
+			"persistent_shell": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Run scripts in one PowerShell process kept open between operations",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_PERSISTENT_SHELL", true),
+			},
+
+			"backend": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "How scripts are run, psrp, powershell or auto to use psrp falling back to powershell",
+				DefaultFunc:  schema.EnvDefaultFunc("WINRM_BACKEND", "auto"),
+				ValidateFunc: validateStringInSlice([]string{"auto", "psrp", "powershell"}),
+			},
+
+			"configuration_name": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "PowerShell session configuration, such as a JEA endpoint, scripts are run in, as a name or resource URI",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_CONFIGURATION_NAME", ""),
+			},
+
+			"verify_connection": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Check the server can be reached, the credentials and the DnsServer module when the provider is configured",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_VERIFY_CONNECTION", true),
+			},
+
+			"transport": {
+				Type:         schema.TypeString,
+				Optional:     true,
+				Description:  "How the server is connected to, winrm or ssh to run scripts with PowerShell over SSH",
+				DefaultFunc:  schema.EnvDefaultFunc("WINRM_TRANSPORT", "winrm"),
+				ValidateFunc: validateStringInSlice([]string{"winrm", "ssh"}),
+			},
+
+			"ssh_private_key": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Sensitive:   true,
+				Description: "PEM encoded private key to authenticate with using the ssh transport",
+			},
+
+			"ssh_known_hosts": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "known_hosts file the server's host key is checked against, defaults to ~/.ssh/known_hosts",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_SSH_KNOWN_HOSTS", ""),
+			},
+
+			"ssh_shell": {
+				Type:        schema.TypeString,
+				Optional:    true,
+				Description: "Command running scripts with the ssh transport, such as powershell or pwsh",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_SSH_SHELL", "powershell"),
+			},
+
+			"ssh_insecure": {
+				Type:        schema.TypeBool,
+				Optional:    true,
+				Description: "Do not check the host key of the server with the ssh transport",
+				DefaultFunc: schema.EnvDefaultFunc("WINRM_SSH_INSECURE", false),
+			},
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"windows-dns_record": resourceDNSRecord(),
+			"windows-dns_record":              resourceDNSRecord(),
+			"windows-dns_server_cache":        resourceDNSServerCache(),
+			"windows-dns_cache_flush":         resourceDNSCacheFlush(),
+			"windows-dns_server_settings":     resourceDNSServerSettings(),
+			"windows-dns_query_policy":        resourceDNSQueryPolicy(),
+			"windows-dns_recursion_scope":     resourceDNSRecursionScope(),
+			"windows-dns_block_policy":        resourceDNSBlockPolicy(),
+			"windows-dns_directory_partition": resourceDNSDirectoryPartition(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -39,10 +395,91 @@ func Provider() *schema.Provider {
 func providerConfigure(d *schema.ResourceData) (interface{}, error) {
 
 	config := config{
-		ServerName: d.Get("server_name").(string),
-		Username:   d.Get("username").(string),
-		Password:   d.Get("password").(string),
+		ServerName:        d.Get("server_name").(string),
+		Servers:           interfaceToStrings(d.Get("servers").([]interface{})),
+		StickyServer:      d.Get("sticky_server").(bool),
+		Username:          d.Get("username").(string),
+		Password:          d.Get("password").(string),
+		PasswordFile:      d.Get("password_file").(string),
+		CredentialCommand: interfaceToStrings(d.Get("credential_command").([]interface{})),
+		AuthType:          d.Get("auth_type").(string),
+		Realm:             d.Get("realm").(string),
+		Krb5Conf:          d.Get("krb5_conf").(string),
+		Keytab:            d.Get("keytab").(string),
+		CCache:            d.Get("ccache").(string),
+		Port:              d.Get("port").(int),
+		HTTPS:             d.Get("https").(bool),
+		Insecure:          d.Get("insecure").(bool),
+		CACert:            d.Get("cacert").(string),
+		CACertFile:        d.Get("cacert_file").(string),
+		Cert:              d.Get("cert").(string),
+		Key:               d.Get("key").(string),
+		ProxyURL:          d.Get("proxy_url").(string),
+		BastionHost:       d.Get("bastion_host").(string),
+		BastionUser:       d.Get("bastion_user").(string),
+		BastionPassword:   d.Get("bastion_password").(string),
+		BastionPrivateKey: d.Get("bastion_private_key").(string),
+		BastionHostKey:    d.Get("bastion_host_key").(string),
+		BastionInsecure:   d.Get("bastion_insecure").(bool),
+		ConnectionTimeout: d.Get("connection_timeout").(string),
+		OperationTimeout:  d.Get("operation_timeout").(string),
+		MaxRetries:        d.Get("max_retries").(int),
+		MaxConcurrent:     d.Get("max_concurrent_operations").(int),
+		PersistentShell:   d.Get("persistent_shell").(bool),
+		Backend:           d.Get("backend").(string),
+		ConfigurationName: d.Get("configuration_name").(string),
+		Transport:         d.Get("transport").(string),
+		SSHPrivateKey:     d.Get("ssh_private_key").(string),
+		SSHKnownHosts:     d.Get("ssh_known_hosts").(string),
+		SSHShell:          d.Get("ssh_shell").(string),
+		SSHInsecure:       d.Get("ssh_insecure").(bool),
+	}
+
+	if v, ok := d.GetOk("vault"); ok {
+		vault := v.([]interface{})[0].(map[string]interface{})
+		config.Vault = &vaultConfig{
+			Address:       vault["address"].(string),
+			Token:         vault["token"].(string),
+			Namespace:     vault["namespace"].(string),
+			CACertFile:    vault["cacert_file"].(string),
+			Path:          vault["path"].(string),
+			UsernameField: vault["username_field"].(string),
+			PasswordField: vault["password_field"].(string),
+		}
 	}
 
-	return config.Client()
+	if v, ok := d.GetOk("wait_for_replication"); ok {
+		replication := v.([]interface{})[0].(map[string]interface{})
+		config.ReplicationServers = interfaceToStrings(replication["servers"].([]interface{}))
+		config.ReplicationTimeout = replication["timeout"].(string)
+		config.ReplicationInterval = replication["interval"].(string)
+	}
+
+	client, err := config.Client()
+	if err != nil {
+		return nil, err
+	}
+	if d.Get("verify_connection").(bool) {
+		if err := client.Probe(); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	clientsMu.Lock()
+	clients = append(clients, client)
+	clientsMu.Unlock()
+
+	return client, nil
+}
+
+// closeClients ends the persistent shells of the configured clients
+func closeClients() {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+
+	for _, client := range clients {
+		client.Close()
+	}
+	clients = nil
 }
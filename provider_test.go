@@ -1,7 +1,9 @@
 package main
 
 import (
+	"net"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/hashicorp/terraform/helper/schema"
@@ -28,6 +30,34 @@ func TestProvider_impl(t *testing.T) {
 	var _ terraform.ResourceProvider = Provider()
 }
 
+func TestProviderConfigure_VerifyConnection(t *testing.T) {
+	// nothing listens on the port once the listener is closed
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error: %s", err)
+	}
+	host, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	raw := map[string]interface{}{
+		"server_name": host,
+		"port":        port,
+		"username":    "user",
+		"password":    "pass",
+	}
+	d := schema.TestResourceDataRaw(t, Provider().Schema, raw)
+	if _, err := providerConfigure(d); err == nil || !strings.Contains(err.Error(), "refused the connection to port "+port) {
+		t.Fatalf("Expected the closed port to fail configuring the provider, got %v", err)
+	}
+
+	raw["verify_connection"] = false
+	d = schema.TestResourceDataRaw(t, Provider().Schema, raw)
+	if _, err := providerConfigure(d); err != nil {
+		t.Fatalf("Expected the server not to be checked without verify_connection, got %s", err)
+	}
+	closeClients()
+}
+
 func testAccPreCheck(t *testing.T) {
 	if v := os.Getenv("WINRM_USERNAME"); v == "" {
 		t.Fatal("WINRM_USERNAME must be set for tests")
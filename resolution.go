@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+	miekg "github.com/miekg/dns"
+)
+
+const (
+	// resolutionInterval is the wait before asking resolvers again whose
+	// answer did not match
+	resolutionInterval = 2 * time.Second
+	// resolutionQueryTimeout limits a single query
+	resolutionQueryTimeout = 5 * time.Second
+)
+
+// verifyResolution queries the resolvers of the verify_resolution block for
+// rec, a mismatch is only logged when warn_only is set
+func verifyResolution(d *schema.ResourceData, rec dns.Record) error {
+	v, ok := d.GetOk("verify_resolution")
+	if !ok {
+		return nil
+	}
+	verify := v.([]interface{})[0].(map[string]interface{})
+
+	timeout, err := time.ParseDuration(verify["timeout"].(string))
+	if err != nil {
+		return fmt.Errorf("Invalid time duration: %v", err)
+	}
+	err = resolve(rec, interfaceToStrings(verify["resolvers"].([]interface{})), verify["protocol"].(string), timeout)
+	if err != nil && verify["warn_only"].(bool) {
+		log.Printf("[WARN] %v", err)
+		return nil
+	}
+	return err
+}
+
+// resolve asks each resolver for rec until they all answer with its value or
+// timeout passes
+func resolve(rec dns.Record, resolvers []string, protocol string, timeout time.Duration) error {
+	fqdn := miekg.Fqdn(rec.Name + "." + rec.Dnszone)
+	if rec.Name == "@" {
+		fqdn = miekg.Fqdn(rec.Dnszone)
+	}
+	qtype := miekg.TypeA
+	if rec.Type == "CNAME" {
+		qtype = miekg.TypeCNAME
+	}
+	client := &miekg.Client{Net: protocol, Timeout: resolutionQueryTimeout}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		var lagging, answers []string
+		for _, resolver := range resolvers {
+			answer, err := query(client, resolver, fqdn, qtype)
+			switch {
+			case err != nil:
+				answers = append(answers, fmt.Sprintf("%s: %v", resolver, err))
+			case !matchesAnswer(rec, answer):
+				answers = append(answers, fmt.Sprintf("%s answered %s", resolver, strings.Join(answer, ", ")))
+			default:
+				continue
+			}
+			lagging = append(lagging, resolver)
+		}
+		if len(lagging) == 0 {
+			return nil
+		}
+
+		if time.Now().Add(resolutionInterval).After(deadline) {
+			return fmt.Errorf("Error verifying resolution of %s, expected %s: %s", fqdn, rec.Value, strings.Join(answers, "; "))
+		}
+		log.Printf("[DEBUG] Waiting for %s to resolve on %s", fqdn, strings.Join(lagging, ", "))
+		resolvers = lagging
+		time.Sleep(resolutionInterval)
+	}
+}
+
+// query returns the records of qtype a resolver answers for fqdn, or the
+// response code when it did not succeed
+func query(client *miekg.Client, resolver, fqdn string, qtype uint16) ([]string, error) {
+	if _, _, err := net.SplitHostPort(resolver); err != nil {
+		resolver = net.JoinHostPort(resolver, "53")
+	}
+
+	m := new(miekg.Msg)
+	m.SetQuestion(fqdn, qtype)
+	resp, _, err := client.Exchange(m, resolver)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Rcode != miekg.RcodeSuccess {
+		return []string{miekg.RcodeToString[resp.Rcode]}, nil
+	}
+
+	answer := []string{}
+	for _, rr := range resp.Answer {
+		switch rr := rr.(type) {
+		case *miekg.A:
+			if qtype == miekg.TypeA {
+				answer = append(answer, rr.A.String())
+			}
+		case *miekg.CNAME:
+			if qtype == miekg.TypeCNAME {
+				answer = append(answer, strings.TrimSuffix(rr.Target, "."))
+			}
+		}
+	}
+	if len(answer) == 0 {
+		answer = append(answer, "no records")
+	}
+	return answer, nil
+}
+
+// matchesAnswer returns if the value of rec is one of the records answered
+func matchesAnswer(rec dns.Record, answer []string) bool {
+	value := strings.TrimSuffix(rec.Value, ".")
+	for _, a := range answer {
+		if strings.EqualFold(a, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// resourceDNSBlockPolicy manages a server level query resolution policy
+// that denies or ignores queries for a set of domains
+func resourceDNSBlockPolicy() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSBlockPolicyCreate,
+		Read:   resourceDNSBlockPolicyRead,
+		Update: resourceDNSBlockPolicyUpdate,
+		Delete: resourceDNSBlockPolicyDelete,
+		Exists: resourceDNSBlockPolicyExists,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+			Delete: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"action": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "DENY",
+				ValidateFunc: validateStringInSlice([]string{"DENY", "IGNORE"}),
+			},
+			"domains": &schema.Schema{
+				Type:        schema.TypeSet,
+				Required:    true,
+				Description: "Domains to block, wildcards such as *.example.com are allowed",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Set:         hashDomain,
+			},
+			"processing_order": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Computed: true,
+			},
+			"enabled": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+		},
+	}
+}
+
+func resourceDNSBlockPolicyCreate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_block_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(""))()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
+
+	policy, err := client.CreateQueryPolicy(expandBlockPolicy(d))
+	if err != nil {
+		return fmt.Errorf("Error creating policy: %v", err)
+	}
+
+	d.SetId(policy.Name)
+	return nil
+}
+
+func resourceDNSBlockPolicyRead(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_block_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(""))()
+	client := m.(*dns.Client)
+
+	policy, err := client.ReadQueryPolicy("", d.Id())
+	if dns.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading policy: %v", err)
+	}
+
+	var domains []string
+	for _, c := range policy.Criteria {
+		if !strings.EqualFold(c.CriteriaType, "Fqdn") {
+			continue
+		}
+		for _, part := range strings.Split(c.Criteria, ";") {
+			values := strings.Split(part, ",")
+			if strings.EqualFold(values[0], "EQ") {
+				domains = append(domains, values[1:]...)
+			}
+		}
+	}
+
+	d.Set("name", policy.Name)
+	d.Set("action", policy.Action)
+	d.Set("processing_order", policy.ProcessingOrder)
+	d.Set("enabled", policy.IsEnabled)
+	if err := d.Set("domains", domains); err != nil {
+		return fmt.Errorf("Error setting domains: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSBlockPolicyUpdate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_block_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(""))()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	if _, err := client.UpdateQueryPolicy(expandBlockPolicy(d)); err != nil {
+		return fmt.Errorf("Error updating policy: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSBlockPolicyDelete(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_block_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(""))()
+	client := timeoutClient(d, m, schema.TimeoutDelete)
+
+	err := client.DeleteQueryPolicy("", d.Id())
+	if dns.IsNotFound(err) {
+		// removed outside Terraform
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error deleting policy: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSBlockPolicyExists(d *schema.ResourceData, m interface{}) (bool, error) {
+	if err := singleServer(m, "windows-dns_block_policy"); err != nil {
+		return false, err
+	}
+	defer locks.Lock(queryPolicyLockKey(""))()
+	client := m.(*dns.Client)
+
+	exists, err := client.QueryPolicyExist("", d.Id())
+	if err != nil {
+		return false, fmt.Errorf("Error reading policy: %v", err)
+	}
+	return exists, nil
+}
+
+func expandBlockPolicy(d *schema.ResourceData) dns.QueryPolicy {
+	domains := []string{"EQ"}
+	for _, v := range d.Get("domains").(*schema.Set).List() {
+		domains = append(domains, v.(string))
+	}
+
+	return dns.QueryPolicy{
+		Name:            d.Get("name").(string),
+		Action:          d.Get("action").(string),
+		Condition:       "AND",
+		ProcessingOrder: d.Get("processing_order").(int),
+		IsEnabled:       d.Get("enabled").(bool),
+		Criteria: []dns.PolicyCriteria{
+			{CriteriaType: "Fqdn", Criteria: strings.Join(domains, ",")},
+		},
+	}
+}
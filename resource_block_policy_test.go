@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+)
+
+func TestAccWinDNSBlockPolicy_Basic(t *testing.T) {
+	var policy dns.QueryPolicy
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWinDNSQueryPolicyDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckWinDNSBlockPolicyConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSQueryPolicyExists("windows-dns_block_policy.foobar", &policy),
+					testAccCheckWinDNSQueryPolicyAttributes(&policy, "DENY", 1),
+					resource.TestCheckResourceAttr("windows-dns_block_policy.foobar", "name", "terraform"),
+					resource.TestCheckResourceAttr("windows-dns_block_policy.foobar", "domains.#", "2"),
+				),
+			},
+			{
+				Config: testAccCheckWinDNSBlockPolicyConfig_updated,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSQueryPolicyExists("windows-dns_block_policy.foobar", &policy),
+					testAccCheckWinDNSQueryPolicyAttributes(&policy, "IGNORE", 1),
+					resource.TestCheckResourceAttr("windows-dns_block_policy.foobar", "domains.#", "3"),
+				),
+			},
+		},
+	})
+}
+
+const testAccCheckWinDNSBlockPolicyConfig_basic = `
+resource "windows-dns_block_policy" "foobar" {
+	name = "terraform"
+	domains = ["malware.terraform.test", "*.malware.terraform.test"]
+}`
+
+const testAccCheckWinDNSBlockPolicyConfig_updated = `
+resource "windows-dns_block_policy" "foobar" {
+	name = "terraform"
+	action = "IGNORE"
+	domains = ["malware.terraform.test", "*.malware.terraform.test", "phishing.terraform.test"]
+}`
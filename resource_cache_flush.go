@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// resourceDNSCacheFlush clears the DNS server cache when it is created, any
+// change to name or triggers replaces it and so clears the cache again
+func resourceDNSCacheFlush() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSCacheFlushCreate,
+		Read:   resourceDNSCacheFlushRead,
+		Delete: resourceDNSCacheFlushDelete,
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Only remove cached records for this name",
+			},
+			"triggers": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+			},
+		},
+	}
+}
+
+func resourceDNSCacheFlushCreate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_cache_flush"); err != nil {
+		return err
+	}
+	defer locks.Lock("server_cache")()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
+
+	if err := client.ClearServerCache(d.Get("name").(string)); err != nil {
+		return fmt.Errorf("Error clearing cache: %v", err)
+	}
+
+	d.SetId(resource.UniqueId())
+	return nil
+}
+
+func resourceDNSCacheFlushRead(d *schema.ResourceData, m interface{}) error {
+	return nil
+}
+
+func resourceDNSCacheFlushDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+	return nil
+}
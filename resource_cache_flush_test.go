@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/resource"
+)
+
+func TestAccWinDNSCacheFlush_Basic(t *testing.T) {
+	domain := os.Getenv("WINRM_DOMAIN")
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWinDNSRecordDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccCheckWinDNSCacheFlushConfig_basic, domain),
+				Check: resource.ComposeTestCheckFunc(
+					resource.TestCheckResourceAttrSet("windows-dns_cache_flush.foobar", "id"),
+					resource.TestCheckResourceAttr("windows-dns_cache_flush.foobar", "name", fmt.Sprintf("terraform.%s", domain)),
+				),
+			},
+		},
+	})
+}
+
+const testAccCheckWinDNSCacheFlushConfig_basic = `
+resource "windows-dns_record" "foobar" {
+	domain = "%[1]s"
+	name = "terraform"
+	value = "10.99.0.10"
+	type = "A"
+	ttl = "1h0m0s"
+}
+
+resource "windows-dns_cache_flush" "foobar" {
+	name = "terraform.%[1]s"
+
+	triggers {
+		value = "${windows-dns_record.foobar.value}"
+	}
+}`
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/hashcode"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceDNSDirectoryPartition() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSDirectoryPartitionCreate,
+		Read:   resourceDNSDirectoryPartitionRead,
+		Update: resourceDNSDirectoryPartitionUpdate,
+		Delete: resourceDNSDirectoryPartitionDelete,
+		Exists: resourceDNSDirectoryPartitionExists,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+			Delete: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:        schema.TypeString,
+				Required:    true,
+				ForceNew:    true,
+				Description: "FQDN of the directory partition",
+			},
+			"servers": &schema.Schema{
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Computed:    true,
+				Description: "DNS servers enlisted in the directory partition",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Set:         hashServerName,
+			},
+			"zones": &schema.Schema{
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "AD integrated zones replicated through the directory partition",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Set:         hashDomain,
+			},
+		},
+	}
+}
+
+func resourceDNSDirectoryPartitionCreate(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("directory_partition")()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
+
+	name := d.Get("name").(string)
+	partition, err := client.CreateDirectoryPartition(name)
+	if err != nil {
+		return fmt.Errorf("Error creating directory partition: %v", err)
+	}
+	d.SetId(partition.Name)
+
+	for _, v := range d.Get("servers").(*schema.Set).List() {
+		if containsServerName(partition.Replica, v.(string)) {
+			continue
+		}
+		if err := client.EnlistDirectoryPartition(name, v.(string)); err != nil {
+			return fmt.Errorf("Error enlisting %s in directory partition: %v", v.(string), err)
+		}
+	}
+
+	for _, v := range d.Get("zones").(*schema.Set).List() {
+		if err := client.SetZoneDirectoryPartition(v.(string), name); err != nil {
+			return fmt.Errorf("Error replicating zone %s through directory partition: %v", v.(string), err)
+		}
+	}
+
+	return nil
+}
+
+func resourceDNSDirectoryPartitionRead(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("directory_partition")()
+	client := m.(*dns.Client)
+
+	partition, err := client.ReadDirectoryPartition(d.Id())
+	if dns.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading directory partition: %v", err)
+	}
+
+	// keep the names servers were configured with when they match the
+	// short names returned from the server
+	configured := d.Get("servers").(*schema.Set).List()
+	var servers []string
+	for _, replica := range partition.Replica {
+		server := replica
+		for _, v := range configured {
+			if strings.EqualFold(shortServerName(v.(string)), replica) {
+				server = v.(string)
+			}
+		}
+		servers = append(servers, server)
+	}
+
+	d.Set("name", partition.Name)
+	if err := d.Set("servers", servers); err != nil {
+		return fmt.Errorf("Error setting servers: %v", err)
+	}
+	if err := d.Set("zones", partition.Zones); err != nil {
+		return fmt.Errorf("Error setting zones: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSDirectoryPartitionUpdate(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("directory_partition")()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	name := d.Id()
+
+	if d.HasChange("servers") {
+		o, n := d.GetChange("servers")
+		for _, v := range n.(*schema.Set).Difference(o.(*schema.Set)).List() {
+			if err := client.EnlistDirectoryPartition(name, v.(string)); err != nil {
+				return fmt.Errorf("Error enlisting %s in directory partition: %v", v.(string), err)
+			}
+		}
+		for _, v := range o.(*schema.Set).Difference(n.(*schema.Set)).List() {
+			if err := client.UnenlistDirectoryPartition(name, v.(string)); err != nil {
+				return fmt.Errorf("Error unenlisting %s from directory partition: %v", v.(string), err)
+			}
+		}
+	}
+
+	if d.HasChange("zones") {
+		o, n := d.GetChange("zones")
+		for _, v := range o.(*schema.Set).Difference(n.(*schema.Set)).List() {
+			if err := client.SetZoneDirectoryPartition(v.(string), ""); err != nil {
+				return fmt.Errorf("Error removing zone %s from directory partition: %v", v.(string), err)
+			}
+		}
+		for _, v := range n.(*schema.Set).Difference(o.(*schema.Set)).List() {
+			if err := client.SetZoneDirectoryPartition(v.(string), name); err != nil {
+				return fmt.Errorf("Error replicating zone %s through directory partition: %v", v.(string), err)
+			}
+		}
+	}
+
+	return nil
+}
+
+func resourceDNSDirectoryPartitionDelete(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("directory_partition")()
+	client := timeoutClient(d, m, schema.TimeoutDelete)
+
+	// zones have to be moved out before the partition can be removed
+	for _, v := range d.Get("zones").(*schema.Set).List() {
+		if err := client.SetZoneDirectoryPartition(v.(string), ""); err != nil {
+			return fmt.Errorf("Error removing zone %s from directory partition: %v", v.(string), err)
+		}
+	}
+
+	if err := client.DeleteDirectoryPartition(d.Id()); err != nil {
+		return fmt.Errorf("Error deleting directory partition: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSDirectoryPartitionExists(d *schema.ResourceData, m interface{}) (bool, error) {
+	defer locks.Lock("directory_partition")()
+	client := m.(*dns.Client)
+
+	exists, err := client.DirectoryPartitionExist(d.Id())
+	if err != nil {
+		return false, fmt.Errorf("Error reading directory partition: %v", err)
+	}
+	return exists, nil
+}
+
+// shortServerName returns the host name of server without its domain
+func shortServerName(server string) string {
+	return strings.SplitN(server, ".", 2)[0]
+}
+
+// hashServerName hashes servers by their short name so dc1 and
+// dc1.test.local are treated as the same server
+func hashServerName(v interface{}) int {
+	return hashcode.String(strings.ToLower(shortServerName(v.(string))))
+}
+
+func containsServerName(servers []string, server string) bool {
+	for _, v := range servers {
+		if strings.EqualFold(shortServerName(v), shortServerName(server)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccWinDNSDirectoryPartition_Basic(t *testing.T) {
+	var partition dns.DirectoryPartition
+	domain := os.Getenv("WINRM_DOMAIN")
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWinDNSDirectoryPartitionDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccCheckWinDNSDirectoryPartitionConfig_basic, domain),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSDirectoryPartitionExists("windows-dns_directory_partition.foobar", &partition),
+					testAccCheckWinDNSDirectoryPartitionAttributes(&partition, 0),
+					resource.TestCheckResourceAttr("windows-dns_directory_partition.foobar", "name", fmt.Sprintf("terraform.%s", domain)),
+					resource.TestCheckResourceAttr("windows-dns_directory_partition.foobar", "servers.#", "1"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccCheckWinDNSDirectoryPartitionConfig_zone, domain),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSDirectoryPartitionExists("windows-dns_directory_partition.foobar", &partition),
+					testAccCheckWinDNSDirectoryPartitionAttributes(&partition, 1),
+					resource.TestCheckResourceAttr("windows-dns_directory_partition.foobar", "zones.#", "1"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckWinDNSDirectoryPartitionDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*dns.Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "windows-dns_directory_partition" {
+			continue
+		}
+
+		exists, err := client.DirectoryPartitionExist(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("Directory partition still exists")
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckWinDNSDirectoryPartitionAttributes(partition *dns.DirectoryPartition, zones int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+
+		if len(partition.Replica) == 0 {
+			return fmt.Errorf("No enlisted servers")
+		}
+
+		if len(partition.Zones) != zones {
+			return fmt.Errorf("Bad zones: %v", partition.Zones)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckWinDNSDirectoryPartitionExists(n string, partition *dns.DirectoryPartition) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("No directory partition ID is set")
+		}
+
+		client := testAccProvider.Meta().(*dns.Client)
+
+		found, err := client.ReadDirectoryPartition(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+
+		*partition = found
+
+		return nil
+	}
+}
+
+const testAccCheckWinDNSDirectoryPartitionConfig_basic = `
+resource "windows-dns_directory_partition" "foobar" {
+	name = "terraform.%s"
+}`
+
+const testAccCheckWinDNSDirectoryPartitionConfig_zone = `
+resource "windows-dns_directory_partition" "foobar" {
+	name = "terraform.%[1]s"
+	zones = ["%[1]s"]
+}`
@@ -2,15 +2,13 @@ package main
 
 import (
 	"fmt"
-	"sync"
+	"strings"
 	"time"
 
-	"github.com/elliottsam/winrm-dns-client/dns"
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
 	"github.com/hashicorp/terraform/helper/schema"
 )
 
-var mutex = &sync.Mutex{}
-
 func resourceDNSRecord() *schema.Resource {
 	return &schema.Resource{
 		Create: resourceDNSRecordCreate,
@@ -22,16 +20,26 @@ func resourceDNSRecord() *schema.Resource {
 			State: schema.ImportStatePassthrough,
 		},
 
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+			Delete: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
 		Schema: map[string]*schema.Schema{
 			"domain": &schema.Schema{
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				StateFunc:    stateASCII,
+				ValidateFunc: validateDomainName,
 			},
 			"name": &schema.Schema{
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				StateFunc:    stateASCII,
+				ValidateFunc: validateDomainName,
 			},
 			"type": &schema.Schema{
 				Type:     schema.TypeString,
@@ -39,8 +47,9 @@ func resourceDNSRecord() *schema.Resource {
 				ForceNew: true,
 			},
 			"value": &schema.Schema{
-				Type:     schema.TypeString,
-				Required: true,
+				Type:             schema.TypeString,
+				Required:         true,
+				DiffSuppressFunc: suppressEquivalentHostname,
 			},
 			"ttl": &schema.Schema{
 				Type:        schema.TypeString,
@@ -52,26 +61,63 @@ func resourceDNSRecord() *schema.Resource {
 				Type:     schema.TypeString,
 				Computed: true,
 			},
+			"verify_resolution": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				MaxItems:    1,
+				Description: "Query resolvers for the record after it is created or changed",
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"resolvers": &schema.Schema{
+							Type:        schema.TypeList,
+							Required:    true,
+							Description: "Resolvers queried, as host or host:port",
+							Elem:        &schema.Schema{Type: schema.TypeString},
+						},
+						"protocol": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "udp",
+							ValidateFunc: validateStringInSlice([]string{"udp", "tcp"}),
+						},
+						"timeout": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Description:  "Time to wait for the answers to match as a duration",
+							Default:      "1m",
+							ValidateFunc: validateDuration,
+						},
+						"warn_only": &schema.Schema{
+							Type:        schema.TypeBool,
+							Optional:    true,
+							Description: "Log answers that do not match instead of failing",
+							Default:     false,
+						},
+					},
+				},
+			},
 		},
 	}
 }
 
 func resourceDNSRecordCreate(d *schema.ResourceData, m interface{}) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-	client := m.(*dns.Client)
+	defer locks.Lock(recordLockKey(d.Get("domain").(string), d.Get("name").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
 
 	ttl, err := time.ParseDuration(d.Get("ttl").(string))
 	if err != nil {
 		return fmt.Errorf("Invalid time duration: %v", err)
 	}
 
-	rec := dns.Record{
+	rec, err := asciiRecord(dns.Record{
 		Dnszone: d.Get("domain").(string),
 		Name:    d.Get("name").(string),
 		Type:    d.Get("type").(string),
 		Value:   d.Get("value").(string),
 		TTL:     ttl.Seconds(),
+	})
+	if err != nil {
+		return err
 	}
 
 	resp, err := client.CreateRecord(rec)
@@ -80,21 +126,23 @@ func resourceDNSRecordCreate(d *schema.ResourceData, m interface{}) error {
 	}
 
 	d.SetId(resp[0].ID)
-	return nil
+
+	return verifyResolution(d, resp[0])
 }
 
 func resourceDNSRecordRead(d *schema.ResourceData, m interface{}) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+	defer locks.Lock(recordLockKey(d.Get("domain").(string), d.Get("name").(string)))()
 	client := m.(*dns.Client)
 
-	var err error
-	rec := dns.Record{
+	rec, err := asciiRecord(dns.Record{
 		Dnszone: d.Get("domain").(string),
 		Name:    d.Get("name").(string),
 		Type:    d.Get("type").(string),
 		Value:   d.Get("value").(string),
 		ID:      d.Id(),
+	})
+	if err != nil {
+		return err
 	}
 
 	if rec.ID != "" {
@@ -111,7 +159,7 @@ func resourceDNSRecordRead(d *schema.ResourceData, m interface{}) error {
 
 	ttl, err := time.ParseDuration(fmt.Sprintf("%vs", rec.TTL))
 	if err != nil {
-		fmt.Errorf("Invalid time duration: %v", err)
+		return fmt.Errorf("Invalid time duration: %v", err)
 	}
 
 	d.Set("domain", rec.Dnszone)
@@ -126,14 +174,13 @@ func resourceDNSRecordRead(d *schema.ResourceData, m interface{}) error {
 }
 
 func resourceDNSRecordUpdate(d *schema.ResourceData, m interface{}) error {
-	mutex.Lock()
-	defer mutex.Unlock()
+	defer locks.Lock(recordLockKey(d.Get("domain").(string), d.Get("name").(string)))()
 	var (
 		err      error
 		newValue string
 		newTTL   time.Duration
 	)
-	client := m.(*dns.Client)
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
 
 	rec, err := client.ReadRecordfromID(d.Id())
 	if err != nil {
@@ -143,6 +190,11 @@ func resourceDNSRecordUpdate(d *schema.ResourceData, m interface{}) error {
 	oldval, newval := d.GetChange("value")
 	if oldval != newval {
 		newValue = d.Get("value").(string)
+		if rec.Type == "CNAME" {
+			if newValue, err = dns.ToASCII(newValue); err != nil {
+				return err
+			}
+		}
 	}
 
 	oldval, newval = d.GetChange("ttl")
@@ -154,28 +206,36 @@ func resourceDNSRecordUpdate(d *schema.ResourceData, m interface{}) error {
 	}
 
 	rec, err = client.UpdateRecord(rec, newValue, newTTL.Seconds())
+	if rec.ID != "" {
+		d.SetId(rec.ID)
+	}
 	if err != nil {
 		return fmt.Errorf("Error updating record: %v", err)
 	}
 
-	d.SetId(rec.ID)
-
-	return nil
+	return verifyResolution(d, rec)
 }
 
 func resourceDNSRecordDelete(d *schema.ResourceData, m interface{}) error {
-	mutex.Lock()
-	defer mutex.Unlock()
-	client := m.(*dns.Client)
+	defer locks.Lock(recordLockKey(d.Get("domain").(string), d.Get("name").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutDelete)
 
-	rec := dns.Record{
+	rec, err := asciiRecord(dns.Record{
 		Dnszone: d.Get("domain").(string),
 		Name:    d.Get("name").(string),
 		Type:    d.Get("type").(string),
 		Value:   d.Get("value").(string),
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := client.DeleteRecord(rec); err != nil {
+	err = client.DeleteRecord(rec)
+	if dns.IsNotFound(err) {
+		// removed outside Terraform, or by an earlier run
+		return nil
+	}
+	if err != nil {
 		return fmt.Errorf("Error deleting record: %v", err)
 	}
 
@@ -183,16 +243,18 @@ func resourceDNSRecordDelete(d *schema.ResourceData, m interface{}) error {
 }
 
 func resourceDNSRecordExists(d *schema.ResourceData, m interface{}) (bool, error) {
-	mutex.Lock()
-	defer mutex.Unlock()
+	defer locks.Lock(recordLockKey(d.Get("domain").(string), d.Get("name").(string)))()
 	client := m.(*dns.Client)
 
-	rec := dns.Record{
+	rec, err := asciiRecord(dns.Record{
 		Dnszone: d.Get("domain").(string),
 		Name:    d.Get("name").(string),
 		Type:    d.Get("type").(string),
 		Value:   d.Get("value").(string),
 		ID:      d.Id(),
+	})
+	if err != nil {
+		return false, err
 	}
 
 	if !client.RecordExist(rec) {
@@ -201,3 +263,27 @@ func resourceDNSRecordExists(d *schema.ResourceData, m interface{}) (bool, error
 
 	return true, nil
 }
+
+// asciiRecord converts the names of rec given in Unicode to the A-labels
+// stored by the DNS server, the value is a name only for CNAME records
+func asciiRecord(rec dns.Record) (dns.Record, error) {
+	var err error
+	if rec.Dnszone, err = dns.ToASCII(rec.Dnszone); err != nil {
+		return rec, err
+	}
+	if rec.Name, err = dns.ToASCII(rec.Name); err != nil {
+		return rec, err
+	}
+	if rec.Type == "CNAME" {
+		if rec.Value, err = dns.ToASCII(rec.Value); err != nil {
+			return rec, err
+		}
+	}
+	return rec, nil
+}
+
+// recordLockKey returns the lock serialising changes to the records of an
+// owner name, updates read the record before changing it
+func recordLockKey(zone, name string) string {
+	return "record|" + strings.ToLower(stateASCII(zone)) + "|" + strings.ToLower(stateASCII(name))
+}
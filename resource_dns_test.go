@@ -2,14 +2,149 @@ package main
 
 import (
 	"fmt"
+	"net"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
-	"github.com/elliottsam/winrm-dns-client/dns"
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
 	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/helper/schema"
 	"github.com/hashicorp/terraform/terraform"
+	miekg "github.com/miekg/dns"
 )
 
+func TestAsciiRecord(t *testing.T) {
+	cases := []struct {
+		rec      dns.Record
+		expected dns.Record
+	}{
+		{
+			dns.Record{Dnszone: "example.com", Name: "terraform", Type: "A", Value: "10.99.0.10"},
+			dns.Record{Dnszone: "example.com", Name: "terraform", Type: "A", Value: "10.99.0.10"},
+		},
+		{
+			dns.Record{Dnszone: "bücher.example.com", Name: "München", Type: "A", Value: "10.99.0.10"},
+			dns.Record{Dnszone: "xn--bcher-kva.example.com", Name: "xn--mnchen-3ya", Type: "A", Value: "10.99.0.10"},
+		},
+		{
+			dns.Record{Dnszone: "テスト", Name: "www", Type: "CNAME", Value: "例え.テスト."},
+			dns.Record{Dnszone: "xn--zckzah", Name: "www", Type: "CNAME", Value: "xn--r8jz45g.xn--zckzah."},
+		},
+	}
+
+	for _, c := range cases {
+		rec, err := asciiRecord(c.rec)
+		if err != nil {
+			t.Fatalf("Error converting %v: %s", c.rec, err)
+		}
+		if rec != c.expected {
+			t.Fatalf("Expected %v, got %v", c.expected, rec)
+		}
+	}
+
+	if _, err := asciiRecord(dns.Record{Dnszone: "example..com", Name: "www"}); err == nil {
+		t.Fatal("Expected an error for an empty label")
+	}
+	if _, err := asciiRecord(dns.Record{Dnszone: "example.com", Name: strings.Repeat("ü", 60)}); err == nil {
+		t.Fatal("Expected an error for a label longer than 63 characters")
+	}
+}
+
+func TestVerifyResolution(t *testing.T) {
+	records := map[string]miekg.RR{
+		"test99.test.local.": &miekg.A{
+			Hdr: miekg.RR_Header{Name: "test99.test.local.", Rrtype: miekg.TypeA, Class: miekg.ClassINET, Ttl: 600},
+			A:   net.ParseIP("10.0.0.99"),
+		},
+		"www.test.local.": &miekg.CNAME{
+			Hdr:    miekg.RR_Header{Name: "www.test.local.", Rrtype: miekg.TypeCNAME, Class: miekg.ClassINET, Ttl: 600},
+			Target: "test99.test.local.",
+		},
+	}
+	server, current := testDNSServer(t, "udp", records)
+	defer server.Shutdown()
+	server, tcp := testDNSServer(t, "tcp", records)
+	defer server.Shutdown()
+	server, stale := testDNSServer(t, "udp", map[string]miekg.RR{})
+	defer server.Shutdown()
+
+	cases := []struct {
+		rec       dns.Record
+		resolvers []string
+		protocol  string
+		err       string
+	}{
+		{dns.Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99"}, []string{current}, "udp", ""},
+		{dns.Record{Dnszone: "test.local", Name: "www", Type: "CNAME", Value: "Test99.test.local."}, []string{current}, "udp", ""},
+		{dns.Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99"}, []string{tcp}, "tcp", ""},
+		{dns.Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.98"}, []string{current}, "udp", current + " answered 10.0.0.99"},
+		{dns.Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99"}, []string{current, stale}, "udp", stale + " answered NXDOMAIN"},
+	}
+	for _, tc := range cases {
+		err := resolve(tc.rec, tc.resolvers, tc.protocol, 100*time.Millisecond)
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: %s", tc.rec.Name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: expected error %q, got %v", tc.rec.Name, tc.err, err)
+		}
+	}
+
+	for _, warnOnly := range []bool{false, true} {
+		d := schema.TestResourceDataRaw(t, resourceDNSRecord().Schema, map[string]interface{}{
+			"verify_resolution": []interface{}{map[string]interface{}{
+				"resolvers": []interface{}{stale},
+				"timeout":   "100ms",
+				"warn_only": warnOnly,
+			}},
+		})
+		err := verifyResolution(d, dns.Record{Dnszone: "test.local", Name: "test99", Type: "A", Value: "10.0.0.99"})
+		if (err != nil) == warnOnly {
+			t.Errorf("warn_only %t: unexpected result %v", warnOnly, err)
+		}
+	}
+}
+
+// testDNSServer answers queries for records over protocol, it returns the
+// server and its address
+func testDNSServer(t *testing.T, protocol string, records map[string]miekg.RR) (*miekg.Server, string) {
+	handler := miekg.HandlerFunc(func(w miekg.ResponseWriter, r *miekg.Msg) {
+		m := new(miekg.Msg)
+		m.SetReply(r)
+		if rr, ok := records[strings.ToLower(r.Question[0].Name)]; ok && rr.Header().Rrtype == r.Question[0].Qtype {
+			m.Answer = append(m.Answer, miekg.Copy(rr))
+		} else if !ok {
+			m.Rcode = miekg.RcodeNameError
+		}
+		w.WriteMsg(m)
+	})
+
+	started := make(chan struct{})
+	server := &miekg.Server{Handler: handler, NotifyStartedFunc: func() { close(started) }}
+	if protocol == "tcp" {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		server.Listener = l
+	} else {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Error: %s", err)
+		}
+		server.PacketConn = pc
+	}
+	go server.ActivateAndServe()
+	<-started
+
+	if server.Listener != nil {
+		return server, server.Listener.Addr().String()
+	}
+	return server, server.PacketConn.LocalAddr().String()
+}
+
 func TestWinDNS_A_Record_Basic(t *testing.T) {
 	var record dns.Record
 	domain := os.Getenv("WINRM_DOMAIN")
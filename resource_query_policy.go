@@ -0,0 +1,382 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+var policyCriteriaTypes = []string{
+	"ClientSubnet",
+	"Fqdn",
+	"QType",
+	"TimeOfDay",
+	"ServerInterfaceIP",
+	"TransportProtocol",
+	"InternetProtocol",
+}
+
+func resourceDNSQueryPolicy() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSQueryPolicyCreate,
+		Read:   resourceDNSQueryPolicyRead,
+		Update: resourceDNSQueryPolicyUpdate,
+		Delete: resourceDNSQueryPolicyDelete,
+		Exists: resourceDNSQueryPolicyExists,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+			Delete: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"zone": &schema.Schema{
+				Type:        schema.TypeString,
+				Optional:    true,
+				ForceNew:    true,
+				Description: "Zone the policy applies to, server level when not set",
+			},
+			"action": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "ALLOW",
+				ValidateFunc: validateStringInSlice([]string{"ALLOW", "DENY", "IGNORE"}),
+			},
+			"condition": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "AND",
+				ValidateFunc: validateStringInSlice([]string{"AND", "OR"}),
+			},
+			"processing_order": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Computed: true,
+			},
+			"enabled": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"criteria": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"type": &schema.Schema{
+							Type:         schema.TypeString,
+							Required:     true,
+							ValidateFunc: validateStringInSlice(policyCriteriaTypes),
+						},
+						"operator": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "EQ",
+							ValidateFunc: validateStringInSlice([]string{"EQ", "NE"}),
+						},
+						"values": &schema.Schema{
+							Type:     schema.TypeList,
+							Required: true,
+							Elem:     &schema.Schema{Type: schema.TypeString},
+						},
+					},
+				},
+			},
+			"apply_on_recursion": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"recursion_scope": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				Description:   "Recursion scope used for queries matching a recursion policy",
+				ConflictsWith: []string{"zone_scope"},
+			},
+			"zone_scope": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"weight": &schema.Schema{
+							Type:     schema.TypeInt,
+							Optional: true,
+							Default:  1,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func resourceDNSQueryPolicyCreate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_query_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(d.Get("zone").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
+
+	policy, err := client.CreateQueryPolicy(expandQueryPolicy(d))
+	if err != nil {
+		return fmt.Errorf("Error creating policy: %v", err)
+	}
+
+	d.SetId(queryPolicyID(policy.ZoneName, policy.Name))
+	return nil
+}
+
+func resourceDNSQueryPolicyRead(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_query_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(d.Get("zone").(string)))()
+	client := m.(*dns.Client)
+
+	zone, name := parseQueryPolicyID(d.Id())
+	policy, err := client.ReadQueryPolicy(zone, name)
+	if dns.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading policy: %v", err)
+	}
+
+	d.Set("name", policy.Name)
+	d.Set("zone", zone)
+	d.Set("action", policy.Action)
+	d.Set("condition", policy.Condition)
+	d.Set("processing_order", policy.ProcessingOrder)
+	d.Set("enabled", policy.IsEnabled)
+	d.Set("apply_on_recursion", policy.ApplyOnRecursion)
+	d.Set("recursion_scope", policy.RecursionScope)
+	if err := d.Set("criteria", flattenPolicyCriteria(policy.Criteria, d.Get("criteria").([]interface{}))); err != nil {
+		return fmt.Errorf("Error setting criteria: %v", err)
+	}
+	if err := d.Set("zone_scope", flattenPolicyScopes(policy.Content, d.Get("zone_scope").([]interface{}))); err != nil {
+		return fmt.Errorf("Error setting zone scopes: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSQueryPolicyUpdate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_query_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(d.Get("zone").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	if _, err := client.UpdateQueryPolicy(expandQueryPolicy(d)); err != nil {
+		return fmt.Errorf("Error updating policy: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSQueryPolicyDelete(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_query_policy"); err != nil {
+		return err
+	}
+	defer locks.Lock(queryPolicyLockKey(d.Get("zone").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutDelete)
+
+	zone, name := parseQueryPolicyID(d.Id())
+	err := client.DeleteQueryPolicy(zone, name)
+	if dns.IsNotFound(err) {
+		// removed outside Terraform
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error deleting policy: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSQueryPolicyExists(d *schema.ResourceData, m interface{}) (bool, error) {
+	if err := singleServer(m, "windows-dns_query_policy"); err != nil {
+		return false, err
+	}
+	defer locks.Lock(queryPolicyLockKey(d.Get("zone").(string)))()
+	client := m.(*dns.Client)
+
+	zone, name := parseQueryPolicyID(d.Id())
+	exists, err := client.QueryPolicyExist(zone, name)
+	if err != nil {
+		return false, fmt.Errorf("Error reading policy: %v", err)
+	}
+	return exists, nil
+}
+
+// queryPolicyID returns the ID of a policy, zone level policies are
+// prefixed with the zone name
+func queryPolicyID(zone, name string) string {
+	if zone == "" {
+		return name
+	}
+	return fmt.Sprintf("%s|%s", zone, name)
+}
+
+func parseQueryPolicyID(id string) (string, string) {
+	parts := strings.SplitN(id, "|", 2)
+	if len(parts) == 1 {
+		return "", parts[0]
+	}
+	return parts[0], parts[1]
+}
+
+func expandQueryPolicy(d *schema.ResourceData) dns.QueryPolicy {
+	policy := dns.QueryPolicy{
+		Name:            d.Get("name").(string),
+		ZoneName:        d.Get("zone").(string),
+		Action:          d.Get("action").(string),
+		Condition:       d.Get("condition").(string),
+		ProcessingOrder: d.Get("processing_order").(int),
+		IsEnabled:       d.Get("enabled").(bool),
+		Criteria:        expandPolicyCriteria(d.Get("criteria").([]interface{})),
+
+		ApplyOnRecursion: d.Get("apply_on_recursion").(bool),
+		RecursionScope:   d.Get("recursion_scope").(string),
+	}
+
+	for _, v := range d.Get("zone_scope").([]interface{}) {
+		scope := v.(map[string]interface{})
+		policy.Content = append(policy.Content, dns.PolicyScope{
+			ScopeName: scope["name"].(string),
+			Weight:    scope["weight"].(int),
+		})
+	}
+
+	return policy
+}
+
+// expandPolicyCriteria combines criteria blocks of the same type into the
+// format used by the DnsServer cmdlets, e.g. EQ,A,AAAA;NE,MX
+func expandPolicyCriteria(l []interface{}) []dns.PolicyCriteria {
+	var (
+		order    []string
+		criteria = map[string][]string{}
+	)
+
+	for _, v := range l {
+		c := v.(map[string]interface{})
+		t := c["type"].(string)
+		if _, ok := criteria[t]; !ok {
+			order = append(order, t)
+		}
+		values := []string{c["operator"].(string)}
+		for _, val := range c["values"].([]interface{}) {
+			values = append(values, val.(string))
+		}
+		criteria[t] = append(criteria[t], strings.Join(values, ","))
+	}
+
+	var result []dns.PolicyCriteria
+	for _, t := range order {
+		result = append(result, dns.PolicyCriteria{
+			CriteriaType: t,
+			Criteria:     strings.Join(criteria[t], ";"),
+		})
+	}
+
+	return result
+}
+
+// flattenPolicyCriteria splits criteria returned from the server into
+// blocks, ordered to match current so that the server reordering criteria
+// or values does not show as a change
+func flattenPolicyCriteria(found []dns.PolicyCriteria, current []interface{}) []map[string]interface{} {
+	var blocks []map[string]interface{}
+	for _, c := range found {
+		for _, part := range strings.Split(c.Criteria, ";") {
+			values := strings.Split(part, ",")
+			block := map[string]interface{}{
+				"type":     c.CriteriaType,
+				"operator": strings.ToUpper(values[0]),
+				"values":   values[1:],
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	var result []map[string]interface{}
+	used := make([]bool, len(blocks))
+	for _, v := range current {
+		cur := v.(map[string]interface{})
+		for i, block := range blocks {
+			if used[i] || !strings.EqualFold(block["type"].(string), cur["type"].(string)) || block["operator"] != cur["operator"] {
+				continue
+			}
+			curValues := interfaceToStrings(cur["values"].([]interface{}))
+			if !sameStrings(block["values"].([]string), curValues) {
+				continue
+			}
+			block["type"] = cur["type"]
+			block["values"] = curValues
+			result = append(result, block)
+			used[i] = true
+			break
+		}
+	}
+	for i, block := range blocks {
+		if !used[i] {
+			result = append(result, block)
+		}
+	}
+
+	return result
+}
+
+// flattenPolicyScopes orders zone scopes returned from the server to
+// match current
+func flattenPolicyScopes(found []dns.PolicyScope, current []interface{}) []map[string]interface{} {
+	var result []map[string]interface{}
+	used := make([]bool, len(found))
+	for _, v := range current {
+		cur := v.(map[string]interface{})
+		for i, scope := range found {
+			if !used[i] && strings.EqualFold(scope.ScopeName, cur["name"].(string)) {
+				result = append(result, map[string]interface{}{
+					"name":   cur["name"],
+					"weight": scope.Weight,
+				})
+				used[i] = true
+				break
+			}
+		}
+	}
+	for i, scope := range found {
+		if !used[i] {
+			result = append(result, map[string]interface{}{
+				"name":   scope.ScopeName,
+				"weight": scope.Weight,
+			})
+		}
+	}
+
+	return result
+}
+
+// queryPolicyLockKey returns the lock serialising changes to the policies of
+// a zone, or server level policies when zone is empty, as their processing
+// orders depend on each other
+func queryPolicyLockKey(zone string) string {
+	return "query_policy|" + strings.ToLower(zone)
+}
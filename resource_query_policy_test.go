@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccWinDNSQueryPolicy_Basic(t *testing.T) {
+	var policy dns.QueryPolicy
+	domain := os.Getenv("WINRM_DOMAIN")
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWinDNSQueryPolicyDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: fmt.Sprintf(testAccCheckWinDNSQueryPolicyConfig_basic, domain),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSQueryPolicyExists("windows-dns_query_policy.foobar", &policy),
+					testAccCheckWinDNSQueryPolicyAttributes(&policy, "DENY", 2),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "name", "terraform"),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "zone", domain),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "criteria.#", "2"),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "criteria.0.type", "TimeOfDay"),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "criteria.1.type", "QType"),
+				),
+			},
+			{
+				Config: fmt.Sprintf(testAccCheckWinDNSQueryPolicyConfig_updated, domain),
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSQueryPolicyExists("windows-dns_query_policy.foobar", &policy),
+					testAccCheckWinDNSQueryPolicyAttributes(&policy, "IGNORE", 1),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "condition", "OR"),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "criteria.#", "1"),
+				),
+			},
+		},
+	})
+}
+
+func TestExpandPolicyCriteria(t *testing.T) {
+	criteria := []interface{}{
+		map[string]interface{}{"type": "QType", "operator": "EQ", "values": []interface{}{"A", "AAAA"}},
+		map[string]interface{}{"type": "ClientSubnet", "operator": "EQ", "values": []interface{}{"Europe"}},
+		map[string]interface{}{"type": "QType", "operator": "NE", "values": []interface{}{"MX"}},
+	}
+
+	expected := []dns.PolicyCriteria{
+		{CriteriaType: "QType", Criteria: "EQ,A,AAAA;NE,MX"},
+		{CriteriaType: "ClientSubnet", Criteria: "EQ,Europe"},
+	}
+
+	if result := expandPolicyCriteria(criteria); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestFlattenPolicyCriteria(t *testing.T) {
+	found := []dns.PolicyCriteria{
+		{CriteriaType: "ClientSubnet", Criteria: "EQ,Europe"},
+		{CriteriaType: "QType", Criteria: "EQ,AAAA,A;NE,MX"},
+		{CriteriaType: "TimeOfDay", Criteria: "EQ,10:00-12:00"},
+	}
+	current := []interface{}{
+		map[string]interface{}{"type": "QType", "operator": "NE", "values": []interface{}{"MX"}},
+		map[string]interface{}{"type": "QType", "operator": "EQ", "values": []interface{}{"A", "AAAA"}},
+		map[string]interface{}{"type": "ClientSubnet", "operator": "EQ", "values": []interface{}{"Europe"}},
+	}
+
+	expected := []map[string]interface{}{
+		{"type": "QType", "operator": "NE", "values": []string{"MX"}},
+		{"type": "QType", "operator": "EQ", "values": []string{"A", "AAAA"}},
+		{"type": "ClientSubnet", "operator": "EQ", "values": []string{"Europe"}},
+		{"type": "TimeOfDay", "operator": "EQ", "values": []string{"10:00-12:00"}},
+	}
+
+	if result := flattenPolicyCriteria(found, current); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, result)
+	}
+}
+
+func TestFlattenPolicyScopes(t *testing.T) {
+	found := []dns.PolicyScope{
+		{ScopeName: "dublin", Weight: 1},
+		{ScopeName: "london", Weight: 3},
+	}
+	current := []interface{}{
+		map[string]interface{}{"name": "london", "weight": 2},
+		map[string]interface{}{"name": "dublin", "weight": 1},
+	}
+
+	expected := []map[string]interface{}{
+		{"name": "london", "weight": 3},
+		{"name": "dublin", "weight": 1},
+	}
+
+	if result := flattenPolicyScopes(found, current); !reflect.DeepEqual(result, expected) {
+		t.Fatalf("Expected %v, got %v", expected, result)
+	}
+}
+
+func testAccCheckWinDNSQueryPolicyDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*dns.Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "windows-dns_query_policy" && rs.Type != "windows-dns_block_policy" {
+			continue
+		}
+
+		zone, name := parseQueryPolicyID(rs.Primary.ID)
+		exists, err := client.QueryPolicyExist(zone, name)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("Policy still exists")
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckWinDNSQueryPolicyAttributes(policy *dns.QueryPolicy, action string, criteria int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+
+		if policy.Action != action {
+			return fmt.Errorf("Bad action: %s", policy.Action)
+		}
+
+		if len(policy.Criteria) != criteria {
+			return fmt.Errorf("Bad criteria: %v", policy.Criteria)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckWinDNSQueryPolicyExists(n string, policy *dns.QueryPolicy) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("No policy ID is set")
+		}
+
+		client := testAccProvider.Meta().(*dns.Client)
+
+		zone, name := parseQueryPolicyID(rs.Primary.ID)
+		found, err := client.ReadQueryPolicy(zone, name)
+		if err != nil {
+			return err
+		}
+
+		*policy = found
+
+		return nil
+	}
+}
+
+const testAccCheckWinDNSQueryPolicyConfig_basic = `
+resource "windows-dns_query_policy" "foobar" {
+	name = "terraform"
+	zone = "%s"
+	action = "DENY"
+
+	criteria {
+		type = "TimeOfDay"
+		values = ["01:00-02:00"]
+	}
+
+	criteria {
+		type = "QType"
+		values = ["AAAA", "MX"]
+	}
+}`
+
+const testAccCheckWinDNSQueryPolicyConfig_updated = `
+resource "windows-dns_query_policy" "foobar" {
+	name = "terraform"
+	zone = "%s"
+	action = "IGNORE"
+	condition = "OR"
+
+	criteria {
+		type = "QType"
+		operator = "NE"
+		values = ["A"]
+	}
+}`
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceDNSRecursionScope() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSRecursionScopeCreate,
+		Read:   resourceDNSRecursionScopeRead,
+		Update: resourceDNSRecursionScopeUpdate,
+		Delete: resourceDNSRecursionScopeDelete,
+		Exists: resourceDNSRecursionScopeExists,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+			Delete: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"forwarders": &schema.Schema{
+				Type:        schema.TypeList,
+				Optional:    true,
+				Description: "Forwarders used for queries in this scope, in order of preference",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+			},
+			"enable_recursion": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+		},
+	}
+}
+
+func resourceDNSRecursionScopeCreate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_recursion_scope"); err != nil {
+		return err
+	}
+	defer locks.Lock("recursion_scope|" + strings.ToLower(d.Get("name").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutCreate)
+
+	scope, err := client.CreateRecursionScope(expandRecursionScope(d))
+	if err != nil {
+		return fmt.Errorf("Error creating recursion scope: %v", err)
+	}
+
+	d.SetId(scope.Name)
+	return nil
+}
+
+func resourceDNSRecursionScopeRead(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_recursion_scope"); err != nil {
+		return err
+	}
+	defer locks.Lock("recursion_scope|" + strings.ToLower(d.Get("name").(string)))()
+	client := m.(*dns.Client)
+
+	scope, err := client.ReadRecursionScope(d.Id())
+	if dns.IsNotFound(err) {
+		d.SetId("")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error reading recursion scope: %v", err)
+	}
+
+	d.Set("name", scope.Name)
+	d.Set("forwarders", scope.Forwarder)
+	d.Set("enable_recursion", scope.EnableRecursion)
+
+	return nil
+}
+
+func resourceDNSRecursionScopeUpdate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_recursion_scope"); err != nil {
+		return err
+	}
+	defer locks.Lock("recursion_scope|" + strings.ToLower(d.Get("name").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	if _, err := client.UpdateRecursionScope(expandRecursionScope(d)); err != nil {
+		return fmt.Errorf("Error updating recursion scope: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSRecursionScopeDelete(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_recursion_scope"); err != nil {
+		return err
+	}
+	defer locks.Lock("recursion_scope|" + strings.ToLower(d.Get("name").(string)))()
+	client := timeoutClient(d, m, schema.TimeoutDelete)
+
+	err := client.DeleteRecursionScope(d.Id())
+	if dns.IsNotFound(err) {
+		// removed outside Terraform
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("Error deleting recursion scope: %v", err)
+	}
+
+	return nil
+}
+
+func resourceDNSRecursionScopeExists(d *schema.ResourceData, m interface{}) (bool, error) {
+	if err := singleServer(m, "windows-dns_recursion_scope"); err != nil {
+		return false, err
+	}
+	defer locks.Lock("recursion_scope|" + strings.ToLower(d.Get("name").(string)))()
+	client := m.(*dns.Client)
+
+	exists, err := client.RecursionScopeExist(d.Id())
+	if err != nil {
+		return false, fmt.Errorf("Error reading recursion scope: %v", err)
+	}
+	return exists, nil
+}
+
+func expandRecursionScope(d *schema.ResourceData) dns.RecursionScope {
+	return dns.RecursionScope{
+		Name:            d.Get("name").(string),
+		Forwarder:       interfaceToStrings(d.Get("forwarders").([]interface{})),
+		EnableRecursion: d.Get("enable_recursion").(bool),
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccWinDNSRecursionScope_Basic(t *testing.T) {
+	var scope dns.RecursionScope
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:     func() { testAccPreCheck(t) },
+		Providers:    testAccProviders,
+		CheckDestroy: testAccCheckWinDNSRecursionScopeDestroy,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckWinDNSRecursionScopeConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSRecursionScopeExists("windows-dns_recursion_scope.foobar", &scope),
+					testAccCheckWinDNSRecursionScopeAttributes(&scope, []string{"10.99.0.53"}),
+					resource.TestCheckResourceAttr("windows-dns_recursion_scope.foobar", "name", "terraform"),
+					resource.TestCheckResourceAttr("windows-dns_recursion_scope.foobar", "forwarders.#", "1"),
+					resource.TestCheckResourceAttr("windows-dns_query_policy.foobar", "recursion_scope", "terraform"),
+				),
+			},
+			{
+				Config: testAccCheckWinDNSRecursionScopeConfig_updated,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSRecursionScopeExists("windows-dns_recursion_scope.foobar", &scope),
+					testAccCheckWinDNSRecursionScopeAttributes(&scope, []string{"10.99.0.53", "10.99.1.53"}),
+					resource.TestCheckResourceAttr("windows-dns_recursion_scope.foobar", "forwarders.#", "2"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckWinDNSRecursionScopeDestroy(s *terraform.State) error {
+	client := testAccProvider.Meta().(*dns.Client)
+
+	for _, rs := range s.RootModule().Resources {
+		if rs.Type != "windows-dns_recursion_scope" {
+			continue
+		}
+
+		exists, err := client.RecursionScopeExist(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("Recursion scope still exists")
+		}
+	}
+
+	return nil
+}
+
+func testAccCheckWinDNSRecursionScopeAttributes(scope *dns.RecursionScope, forwarders []string) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+
+		if !sameStrings(scope.Forwarder, forwarders) {
+			return fmt.Errorf("Bad forwarders: %v", scope.Forwarder)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckWinDNSRecursionScopeExists(n string, scope *dns.RecursionScope) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("No recursion scope ID is set")
+		}
+
+		client := testAccProvider.Meta().(*dns.Client)
+
+		found, err := client.ReadRecursionScope(rs.Primary.ID)
+		if err != nil {
+			return err
+		}
+
+		*scope = found
+
+		return nil
+	}
+}
+
+const testAccCheckWinDNSRecursionScopeConfig_basic = `
+resource "windows-dns_recursion_scope" "foobar" {
+	name = "terraform"
+	forwarders = ["10.99.0.53"]
+}
+
+resource "windows-dns_query_policy" "foobar" {
+	name = "terraform-recursion"
+	apply_on_recursion = true
+	recursion_scope = "${windows-dns_recursion_scope.foobar.name}"
+
+	criteria {
+		type = "Fqdn"
+		values = ["*.terraform.test"]
+	}
+}`
+
+const testAccCheckWinDNSRecursionScopeConfig_updated = `
+resource "windows-dns_recursion_scope" "foobar" {
+	name = "terraform"
+	forwarders = ["10.99.0.53", "10.99.1.53"]
+}`
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+func resourceDNSServerCache() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSServerCacheCreate,
+		Read:   resourceDNSServerCacheRead,
+		Update: resourceDNSServerCacheUpdate,
+		Delete: resourceDNSServerCacheDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"max_ttl": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Maximum time records are kept in the cache as a duration",
+				Default:          "24h0m0s",
+				ValidateFunc:     validateDuration,
+				DiffSuppressFunc: suppressEquivalentDuration,
+			},
+			"max_negative_ttl": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Maximum time negative responses are kept in the cache as a duration",
+				Default:          "15m0s",
+				ValidateFunc:     validateDuration,
+				DiffSuppressFunc: suppressEquivalentDuration,
+			},
+			"enable_pollution_protection": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"locking_percent": &schema.Schema{
+				Type:     schema.TypeInt,
+				Optional: true,
+				Default:  100,
+			},
+			"max_kb_size": &schema.Schema{
+				Type:        schema.TypeInt,
+				Optional:    true,
+				Description: "Maximum size of the cache in KB, 0 is unlimited",
+				Default:     0,
+			},
+		},
+	}
+}
+
+func resourceDNSServerCacheCreate(d *schema.ResourceData, m interface{}) error {
+	if err := resourceDNSServerCacheUpdate(d, m); err != nil {
+		return err
+	}
+
+	d.SetId(m.(*dns.Client).ServerName)
+	return resourceDNSServerCacheRead(d, m)
+}
+
+func resourceDNSServerCacheRead(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("server_cache")()
+	client := m.(*dns.Client)
+
+	cache, err := client.ReadServerCache()
+	if err != nil {
+		return fmt.Errorf("Error reading cache settings: %v", err)
+	}
+
+	d.Set("max_ttl", secondsToDuration(cache.MaxTTL))
+	d.Set("max_negative_ttl", secondsToDuration(cache.MaxNegativeTTL))
+	d.Set("enable_pollution_protection", cache.EnablePollutionProtection)
+	d.Set("locking_percent", cache.LockingPercent)
+	d.Set("max_kb_size", cache.MaxKBSize)
+
+	return nil
+}
+
+func resourceDNSServerCacheUpdate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_server_cache"); err != nil {
+		return err
+	}
+	defer locks.Lock("server_cache")()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	maxTTL, err := time.ParseDuration(d.Get("max_ttl").(string))
+	if err != nil {
+		return fmt.Errorf("Invalid time duration: %v", err)
+	}
+	maxNegativeTTL, err := time.ParseDuration(d.Get("max_negative_ttl").(string))
+	if err != nil {
+		return fmt.Errorf("Invalid time duration: %v", err)
+	}
+
+	cache := dns.ServerCache{
+		MaxTTL:                    maxTTL.Seconds(),
+		MaxNegativeTTL:            maxNegativeTTL.Seconds(),
+		EnablePollutionProtection: d.Get("enable_pollution_protection").(bool),
+		LockingPercent:            d.Get("locking_percent").(int),
+		MaxKBSize:                 d.Get("max_kb_size").(int),
+	}
+
+	if _, err := client.UpdateServerCache(cache); err != nil {
+		return fmt.Errorf("Error updating cache settings: %v", err)
+	}
+
+	return nil
+}
+
+// resourceDNSServerCacheDelete only removes the settings from state, the
+// server keeps whatever cache configuration was last applied
+func resourceDNSServerCacheDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccWinDNSServerCache_Basic(t *testing.T) {
+	var cache dns.ServerCache
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckWinDNSServerCacheConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSServerCacheExists("windows-dns_server_cache.foobar", &cache),
+					testAccCheckWinDNSServerCacheAttributes(&cache, 3600, false),
+					resource.TestCheckResourceAttr("windows-dns_server_cache.foobar", "max_ttl", "1h0m0s"),
+					resource.TestCheckResourceAttr("windows-dns_server_cache.foobar", "enable_pollution_protection", "false"),
+				),
+			},
+			{
+				Config: testAccCheckWinDNSServerCacheConfig_updated,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSServerCacheExists("windows-dns_server_cache.foobar", &cache),
+					testAccCheckWinDNSServerCacheAttributes(&cache, 86400, true),
+					resource.TestCheckResourceAttr("windows-dns_server_cache.foobar", "max_ttl", "24h0m0s"),
+					resource.TestCheckResourceAttr("windows-dns_server_cache.foobar", "enable_pollution_protection", "true"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckWinDNSServerCacheAttributes(cache *dns.ServerCache, maxTTL float64, pollution bool) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+
+		if cache.MaxTTL != maxTTL {
+			return fmt.Errorf("Bad max TTL: %v", cache.MaxTTL)
+		}
+
+		if cache.EnablePollutionProtection != pollution {
+			return fmt.Errorf("Bad pollution protection: %v", cache.EnablePollutionProtection)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckWinDNSServerCacheExists(n string, cache *dns.ServerCache) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("No server cache ID is set")
+		}
+
+		client := testAccProvider.Meta().(*dns.Client)
+
+		found, err := client.ReadServerCache()
+		if err != nil {
+			return err
+		}
+
+		*cache = found
+
+		return nil
+	}
+}
+
+const testAccCheckWinDNSServerCacheConfig_basic = `
+resource "windows-dns_server_cache" "foobar" {
+	max_ttl = "1h"
+	max_negative_ttl = "5m0s"
+	enable_pollution_protection = false
+}`
+
+const testAccCheckWinDNSServerCacheConfig_updated = `
+resource "windows-dns_server_cache" "foobar" {
+	max_ttl = "24h0m0s"
+}`
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/schema"
+)
+
+// nameCheckFlags maps the NameCheckFlag values used by the DNS server to
+// the names used in configuration
+var nameCheckFlags = []string{"StrictRFCANSI", "NonRFCANSI", "MultibyteUTF8", "AllNames"}
+
+func resourceDNSServerSettings() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceDNSServerSettingsCreate,
+		Read:   resourceDNSServerSettingsRead,
+		Update: resourceDNSServerSettingsUpdate,
+		Delete: resourceDNSServerSettingsDelete,
+		Importer: &schema.ResourceImporter{
+			State: schema.ImportStatePassthrough,
+		},
+
+		Timeouts: &schema.ResourceTimeout{
+			Create: schema.DefaultTimeout(defaultResourceTimeout),
+			Update: schema.DefaultTimeout(defaultResourceTimeout),
+		},
+
+		Schema: map[string]*schema.Schema{
+			"listening_addresses": &schema.Schema{
+				Type:        schema.TypeSet,
+				Optional:    true,
+				Description: "IP addresses the server listens on, all of its addresses when not set",
+				Elem:        &schema.Schema{Type: schema.TypeString},
+				Set:         schema.HashString,
+			},
+			"round_robin": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"local_net_priority": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"name_checking": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "MultibyteUTF8",
+				ValidateFunc: validateStringInSlice(nameCheckFlags),
+			},
+			"strict_file_parsing": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			"edns_cache_timeout": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Description:      "Time EDNS information is cached as a duration",
+				Default:          "15m0s",
+				ValidateFunc:     validateDuration,
+				DiffSuppressFunc: suppressEquivalentDuration,
+			},
+			"edns_enable_probes": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+			"edns_enable_reception": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  true,
+			},
+		},
+	}
+}
+
+func resourceDNSServerSettingsCreate(d *schema.ResourceData, m interface{}) error {
+	if err := resourceDNSServerSettingsUpdate(d, m); err != nil {
+		return err
+	}
+
+	d.SetId(m.(*dns.Client).ServerName)
+	return resourceDNSServerSettingsRead(d, m)
+}
+
+func resourceDNSServerSettingsRead(d *schema.ResourceData, m interface{}) error {
+	defer locks.Lock("server_settings")()
+	client := m.(*dns.Client)
+
+	settings, err := client.ReadServerSettings()
+	if err != nil {
+		return fmt.Errorf("Error reading server settings: %v", err)
+	}
+
+	if settings.NameCheckFlag < 0 || settings.NameCheckFlag >= len(nameCheckFlags) {
+		return fmt.Errorf("Unknown name check flag: %d", settings.NameCheckFlag)
+	}
+
+	d.Set("listening_addresses", settings.ListeningIPAddress)
+	d.Set("round_robin", settings.RoundRobin)
+	d.Set("local_net_priority", settings.LocalNetPriority)
+	d.Set("name_checking", nameCheckFlags[settings.NameCheckFlag])
+	d.Set("strict_file_parsing", settings.StrictFileParsing)
+	d.Set("edns_cache_timeout", secondsToDuration(settings.EDnsCacheTimeout))
+	d.Set("edns_enable_probes", settings.EDnsEnableProbes)
+	d.Set("edns_enable_reception", settings.EDnsEnableReception)
+
+	return nil
+}
+
+func resourceDNSServerSettingsUpdate(d *schema.ResourceData, m interface{}) error {
+	if err := singleServer(m, "windows-dns_server_settings"); err != nil {
+		return err
+	}
+	defer locks.Lock("server_settings")()
+	client := timeoutClient(d, m, schema.TimeoutUpdate)
+
+	cacheTimeout, err := time.ParseDuration(d.Get("edns_cache_timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Invalid time duration: %v", err)
+	}
+
+	settings := dns.ServerSettings{
+		RoundRobin:          d.Get("round_robin").(bool),
+		LocalNetPriority:    d.Get("local_net_priority").(bool),
+		StrictFileParsing:   d.Get("strict_file_parsing").(bool),
+		EDnsCacheTimeout:    cacheTimeout.Seconds(),
+		EDnsEnableProbes:    d.Get("edns_enable_probes").(bool),
+		EDnsEnableReception: d.Get("edns_enable_reception").(bool),
+	}
+
+	for i, v := range nameCheckFlags {
+		if v == d.Get("name_checking").(string) {
+			settings.NameCheckFlag = i
+		}
+	}
+
+	for _, addr := range d.Get("listening_addresses").(*schema.Set).List() {
+		settings.ListeningIPAddress = append(settings.ListeningIPAddress, addr.(string))
+	}
+
+	if _, err := client.UpdateServerSettings(settings); err != nil {
+		return fmt.Errorf("Error updating server settings: %v", err)
+	}
+
+	return nil
+}
+
+// resourceDNSServerSettingsDelete only removes the settings from state, the
+// server keeps whatever configuration was last applied
+func resourceDNSServerSettingsDelete(d *schema.ResourceData, m interface{}) error {
+	d.SetId("")
+	return nil
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/elliottsam/terraform-provider-windows-dns/internal/dns"
+	"github.com/hashicorp/terraform/helper/resource"
+	"github.com/hashicorp/terraform/terraform"
+)
+
+func TestAccWinDNSServerSettings_Basic(t *testing.T) {
+	var settings dns.ServerSettings
+
+	resource.Test(t, resource.TestCase{
+		PreCheck:  func() { testAccPreCheck(t) },
+		Providers: testAccProviders,
+		Steps: []resource.TestStep{
+			{
+				Config: testAccCheckWinDNSServerSettingsConfig_basic,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSServerSettingsExists("windows-dns_server_settings.foobar", &settings),
+					testAccCheckWinDNSServerSettingsAttributes(&settings, false, 3),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "round_robin", "false"),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "name_checking", "AllNames"),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "edns_cache_timeout", "30m0s"),
+				),
+			},
+			{
+				Config: testAccCheckWinDNSServerSettingsConfig_defaults,
+				Check: resource.ComposeTestCheckFunc(
+					testAccCheckWinDNSServerSettingsExists("windows-dns_server_settings.foobar", &settings),
+					testAccCheckWinDNSServerSettingsAttributes(&settings, true, 2),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "round_robin", "true"),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "name_checking", "MultibyteUTF8"),
+					resource.TestCheckResourceAttr("windows-dns_server_settings.foobar", "edns_cache_timeout", "15m0s"),
+				),
+			},
+		},
+	})
+}
+
+func testAccCheckWinDNSServerSettingsAttributes(settings *dns.ServerSettings, roundRobin bool, nameCheck int) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+
+		if settings.RoundRobin != roundRobin {
+			return fmt.Errorf("Bad round robin: %v", settings.RoundRobin)
+		}
+
+		if settings.NameCheckFlag != nameCheck {
+			return fmt.Errorf("Bad name check flag: %v", settings.NameCheckFlag)
+		}
+
+		return nil
+	}
+}
+
+func testAccCheckWinDNSServerSettingsExists(n string, settings *dns.ServerSettings) resource.TestCheckFunc {
+	return func(s *terraform.State) error {
+		rs, ok := s.RootModule().Resources[n]
+
+		if !ok {
+			return fmt.Errorf("Not found: %s", n)
+		}
+
+		if rs.Primary.ID == "" {
+			return fmt.Errorf("No server settings ID is set")
+		}
+
+		client := testAccProvider.Meta().(*dns.Client)
+
+		found, err := client.ReadServerSettings()
+		if err != nil {
+			return err
+		}
+
+		*settings = found
+
+		return nil
+	}
+}
+
+const testAccCheckWinDNSServerSettingsConfig_basic = `
+resource "windows-dns_server_settings" "foobar" {
+	round_robin = false
+	name_checking = "AllNames"
+	edns_cache_timeout = "30m"
+}`
+
+const testAccCheckWinDNSServerSettingsConfig_defaults = `
+resource "windows-dns_server_settings" "foobar" {
+}`